@@ -0,0 +1,257 @@
+package codec
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	ErrShortAudioSpecificConfig = errors.New("codec: AudioSpecificConfig is too short")
+	ErrInvalidSamplingFrequency = errors.New("codec: invalid sampling frequency index")
+)
+
+// SamplingFrequencies are the sample rates of the sampling frequency indexes (ISO/IEC 14496-3 1.6.3.3).
+var SamplingFrequencies = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// Audio object types (ISO/IEC 14496-3 1.5.1.1)
+const (
+	ObjectTypeAACMain = 1
+	ObjectTypeAACLC   = 2
+	ObjectTypeAACSSR  = 3
+	ObjectTypeAACLTP  = 4
+	ObjectTypeSBR     = 5
+	ObjectTypePS      = 29
+)
+
+const (
+	// explicitFrequencyIndex means the sampling frequency follows as a 24 bit number
+	explicitFrequencyIndex = 0x0F
+	// escapeObjectType means the object type continues on 6 more bits
+	escapeObjectType = 31
+	// Sync extension types of the backward compatible SBR and PS signalling
+	syncExtensionSBR = 0x2B7
+	syncExtensionPS  = 0x548
+)
+
+// AudioSpecificConfig is the payload of an AAC sequence header (ISO/IEC 14496-3 1.6.2.1).
+type AudioSpecificConfig struct {
+	// ObjectType is the type of the core coder, 2 for AAC-LC. With explicit SBR signalling (object type 5 or 29)
+	// it is the object type following the extension.
+	ObjectType int
+	// SamplingFrequencyIndex is 15 if the frequency is given explicitly
+	SamplingFrequencyIndex int
+	// SampleRate of the core coder in Hz
+	SampleRate           int
+	ChannelConfiguration int
+	// FrameLength is the number of samples in a frame of the core coder, 1024 or 960
+	FrameLength int
+
+	// SBR is set for HE-AAC (Spectral Band Replication), PS for HE-AACv2 (Parametric Stereo)
+	SBR bool
+	PS  bool
+	// ExtensionSampleRate is the output sample rate of SBR, 0 if it is not signalled
+	ExtensionSampleRate int
+}
+
+// ParseAudioSpecificConfig parses the object type, the sampling frequency and the channel configuration,
+// and the explicit or backward compatible SBR and PS signalling.
+func ParseAudioSpecificConfig(b []byte) (*AudioSpecificConfig, error) {
+	if len(b) < 2 {
+		return nil, ErrShortAudioSpecificConfig
+	}
+	r := &bitReader{b: b}
+	c := &AudioSpecificConfig{FrameLength: 1024}
+
+	objectType, err := readObjectType(r)
+	if err != nil {
+		return nil, err
+	}
+	if c.SamplingFrequencyIndex, c.SampleRate, err = readSamplingFrequency(r); err != nil {
+		return nil, err
+	}
+	channels, err := r.u(4)
+	if err != nil {
+		return nil, ErrShortAudioSpecificConfig
+	}
+	c.ChannelConfiguration = int(channels)
+
+	// Explicit hierarchical signalling: the SBR output rate, then the core object type
+	if objectType == ObjectTypeSBR || objectType == ObjectTypePS {
+		c.SBR = true
+		c.PS = objectType == ObjectTypePS
+		if _, c.ExtensionSampleRate, err = readSamplingFrequency(r); err != nil {
+			return nil, err
+		}
+		if objectType, err = readObjectType(r); err != nil {
+			return nil, err
+		}
+	}
+	c.ObjectType = objectType
+
+	switch objectType {
+	case ObjectTypeAACMain, ObjectTypeAACLC, ObjectTypeAACSSR, ObjectTypeAACLTP:
+	default:
+		// Only the GASpecificConfig of the AAC object types is known, the rest is not read
+		return c, nil
+	}
+	// GASpecificConfig: frameLengthFlag, dependsOnCoreCoder (with a 14 bit coreCoderDelay), extensionFlag
+	if frameLength960, err := r.flag(); err != nil {
+		return c, nil
+	} else if frameLength960 {
+		c.FrameLength = 960
+	}
+	if dependsOnCoreCoder, _ := r.flag(); dependsOnCoreCoder {
+		r.u(14)
+	}
+	if _, err := r.flag(); err != nil || c.ChannelConfiguration == 0 {
+		// The program_config_element of channel configuration 0 is not read, nor the sync extensions after it
+		return c, nil
+	}
+
+	c.parseSyncExtensions(r)
+	return c, nil
+}
+
+// parseSyncExtensions reads the backward compatible SBR and PS signalling at the end of the config, if it's there.
+func (c *AudioSpecificConfig) parseSyncExtensions(r *bitReader) {
+	if c.SBR {
+		return
+	}
+	if syncType, err := r.u(11); err != nil || syncType != syncExtensionSBR {
+		return
+	}
+	if objectType, err := readObjectType(r); err != nil || objectType != ObjectTypeSBR {
+		return
+	}
+	if present, err := r.flag(); err != nil || !present {
+		return
+	}
+	c.SBR = true
+	var err error
+	if _, c.ExtensionSampleRate, err = readSamplingFrequency(r); err != nil {
+		return
+	}
+	if syncType, err := r.u(11); err != nil || syncType != syncExtensionPS {
+		return
+	}
+	c.PS, _ = r.flag()
+}
+
+// readObjectType reads an audio object type, which could be escaped to 6 more bits.
+func readObjectType(r *bitReader) (int, error) {
+	objectType, err := r.u(5)
+	if err != nil {
+		return 0, ErrShortAudioSpecificConfig
+	}
+	if objectType == escapeObjectType {
+		extended, err := r.u(6)
+		if err != nil {
+			return 0, ErrShortAudioSpecificConfig
+		}
+		objectType = 32 + extended
+	}
+	return int(objectType), nil
+}
+
+// readSamplingFrequency reads a sampling frequency index, and the explicit frequency after the index 15.
+func readSamplingFrequency(r *bitReader) (index int, frequency int, err error) {
+	i, err := r.u(4)
+	if err != nil {
+		return 0, 0, ErrShortAudioSpecificConfig
+	}
+	if i == explicitFrequencyIndex {
+		f, err := r.u(24)
+		if err != nil {
+			return 0, 0, ErrShortAudioSpecificConfig
+		}
+		return int(i), int(f), nil
+	}
+	if int(i) >= len(SamplingFrequencies) {
+		return 0, 0, ErrInvalidSamplingFrequency
+	}
+	return int(i), SamplingFrequencies[i], nil
+}
+
+// Channels returns the number of output channels. Parametric Stereo decodes a mono core into stereo.
+// Channel configuration 0 (channels given by a program config element) returns 0.
+func (c *AudioSpecificConfig) Channels() int {
+	if c.PS {
+		return 2
+	}
+	switch {
+	case c.ChannelConfiguration >= 1 && c.ChannelConfiguration <= 6:
+		return c.ChannelConfiguration
+	case c.ChannelConfiguration == 7:
+		// 7.1
+		return 8
+	}
+	return 0
+}
+
+// OutputSampleRate returns the sample rate of the decoded audio: the SBR rate of HE-AAC, the core rate otherwise.
+func (c *AudioSpecificConfig) OutputSampleRate() int {
+	if c.SBR && c.ExtensionSampleRate > 0 {
+		return c.ExtensionSampleRate
+	}
+	if c.SBR && c.SampleRate <= 24000 {
+		// Implicit SBR doubles the core rate
+		return 2 * c.SampleRate
+	}
+	return c.SampleRate
+}
+
+// Codec returns the codecs parameter of the MIME type (RFC 6381), like "mp4a.40.2".
+// HE-AAC is announced with the SBR or PS object type, the way the players expect it.
+func (c *AudioSpecificConfig) Codec() string {
+	objectType := c.ObjectType
+	if c.PS {
+		objectType = ObjectTypePS
+	} else if c.SBR {
+		objectType = ObjectTypeSBR
+	}
+	return "mp4a.40." + strconv.Itoa(objectType)
+}
+
+// ADTSHeader returns the 7 byte ADTS header (without CRC) of a raw AAC frame of frameLength bytes.
+// ADTS carries the core coder, the SBR and PS of HE-AAC are found implicitly by the decoders.
+func (c *AudioSpecificConfig) ADTSHeader(frameLength int) []byte {
+	length := frameLength + 7
+	// The profile field is the object type minus one, it only has 2 bits
+	profile := c.ObjectType - 1
+	if profile < 0 || profile > 3 {
+		profile = ObjectTypeAACLC - 1
+	}
+	frequencyIndex := c.adtsFrequencyIndex()
+	return []byte{
+		// Syncword, MPEG-4, layer 0, no CRC
+		0xFF, 0xF1,
+		byte(profile<<6) | byte(frequencyIndex<<2) | byte(c.ChannelConfiguration>>2)&0x01,
+		byte(c.ChannelConfiguration&0x03)<<6 | byte(length>>11)&0x03,
+		byte(length >> 3),
+		byte(length&0x07)<<5 | 0x1F,
+		// Buffer fullness 0x7FF (variable bitrate), one raw data block
+		0xFC,
+	}
+}
+
+// adtsFrequencyIndex returns the sampling frequency index for ADTS, which can't carry an explicit frequency:
+// the index of the closest standard frequency then.
+func (c *AudioSpecificConfig) adtsFrequencyIndex() int {
+	if c.SamplingFrequencyIndex < len(SamplingFrequencies) {
+		return c.SamplingFrequencyIndex
+	}
+	best := 0
+	for i, f := range SamplingFrequencies {
+		if abs(f-c.SampleRate) < abs(SamplingFrequencies[best]-c.SampleRate) {
+			best = i
+		}
+	}
+	return best
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,133 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseAudioSpecificConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   []byte
+		want     AudioSpecificConfig
+		channels int
+		rate     int
+		codec    string
+	}{
+		{
+			name:     "AAC-LC 44100 Hz stereo",
+			config:   []byte{0x12, 0x10},
+			want:     AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 4, SampleRate: 44100, ChannelConfiguration: 2, FrameLength: 1024},
+			channels: 2, rate: 44100, codec: "mp4a.40.2",
+		},
+		{
+			name:     "AAC-LC with a sync extension without SBR",
+			config:   []byte{0x12, 0x10, 0x56, 0xE5, 0x00},
+			want:     AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 4, SampleRate: 44100, ChannelConfiguration: 2, FrameLength: 1024},
+			channels: 2, rate: 44100, codec: "mp4a.40.2",
+		},
+		{
+			name:     "960 samples per frame",
+			config:   []byte{0x12, 0x14},
+			want:     AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 4, SampleRate: 44100, ChannelConfiguration: 2, FrameLength: 960},
+			channels: 2, rate: 44100, codec: "mp4a.40.2",
+		},
+		{
+			name:     "explicit sampling frequency",
+			config:   []byte{0x17, 0x80, 0x56, 0x22, 0x10},
+			want:     AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 15, SampleRate: 44100, ChannelConfiguration: 2, FrameLength: 1024},
+			channels: 2, rate: 44100, codec: "mp4a.40.2",
+		},
+		{
+			name:     "7.1 channels",
+			config:   []byte{0x12, 0x38},
+			want:     AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 4, SampleRate: 44100, ChannelConfiguration: 7, FrameLength: 1024},
+			channels: 8, rate: 44100, codec: "mp4a.40.2",
+		},
+		{
+			name:   "HE-AAC with explicit signalling",
+			config: []byte{0x2B, 0x92, 0x08, 0x00},
+			want: AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 7, SampleRate: 22050, ChannelConfiguration: 2, FrameLength: 1024,
+				SBR: true, ExtensionSampleRate: 44100},
+			channels: 2, rate: 44100, codec: "mp4a.40.5",
+		},
+		{
+			name:   "HE-AACv2 with explicit signalling",
+			config: []byte{0xEB, 0x09, 0x88, 0x00},
+			want: AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 6, SampleRate: 24000, ChannelConfiguration: 1, FrameLength: 1024,
+				SBR: true, PS: true, ExtensionSampleRate: 48000},
+			channels: 2, rate: 48000, codec: "mp4a.40.29",
+		},
+		{
+			name:   "HE-AACv2 with backward compatible signalling",
+			config: []byte{0x13, 0x08, 0x56, 0xE5, 0x9D, 0x48, 0x80},
+			want: AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 6, SampleRate: 24000, ChannelConfiguration: 1, FrameLength: 1024,
+				SBR: true, PS: true, ExtensionSampleRate: 48000},
+			channels: 2, rate: 48000, codec: "mp4a.40.29",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAudioSpecificConfig(tt.config)
+			if err != nil {
+				t.Fatalf("ParseAudioSpecificConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ParseAudioSpecificConfig() = %+v, want %+v", *got, tt.want)
+			}
+			if channels := got.Channels(); channels != tt.channels {
+				t.Errorf("Channels() = %d, want %d", channels, tt.channels)
+			}
+			if rate := got.OutputSampleRate(); rate != tt.rate {
+				t.Errorf("OutputSampleRate() = %d, want %d", rate, tt.rate)
+			}
+			if codec := got.Codec(); codec != tt.codec {
+				t.Errorf("Codec() = %q, want %q", codec, tt.codec)
+			}
+		})
+	}
+}
+
+func TestParseAudioSpecificConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config []byte
+		want   error
+	}{
+		{"empty", nil, ErrShortAudioSpecificConfig},
+		{"single byte", []byte{0x12}, ErrShortAudioSpecificConfig},
+		{"truncated explicit frequency", []byte{0x17, 0x80, 0x56}, ErrShortAudioSpecificConfig},
+		{"invalid frequency index", []byte{0x16, 0x90}, ErrInvalidSamplingFrequency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseAudioSpecificConfig(tt.config); err != tt.want {
+				t.Errorf("ParseAudioSpecificConfig() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestADTSHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		config AudioSpecificConfig
+		want   []byte
+	}{
+		{"AAC-LC 44100 Hz stereo", AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 4, SampleRate: 44100, ChannelConfiguration: 2},
+			[]byte{0xFF, 0xF1, 0x50, 0x80, 0x0D, 0x7F, 0xFC}},
+		{"HE-AAC core", AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 7, SampleRate: 22050, ChannelConfiguration: 2, SBR: true},
+			[]byte{0xFF, 0xF1, 0x5C, 0x80, 0x0D, 0x7F, 0xFC}},
+		{"explicit frequency", AudioSpecificConfig{ObjectType: 2, SamplingFrequencyIndex: 15, SampleRate: 44000, ChannelConfiguration: 2},
+			[]byte{0xFF, 0xF1, 0x50, 0x80, 0x0D, 0x7F, 0xFC}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 100 bytes of raw data
+			if got := tt.config.ADTSHeader(100); !bytes.Equal(got, tt.want) {
+				t.Errorf("ADTSHeader() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package codec
+
+import (
+	"errors"
+)
+
+var ErrBitstreamTooShort = errors.New("codec: bitstream is too short")
+
+// bitReader reads bits MSB first, and the Exp-Golomb codes of the H.264 syntax elements.
+type bitReader struct {
+	b   []byte
+	pos int
+}
+
+// u reads an n bit unsigned integer, n is at most 32.
+func (r *bitReader) u(n int) (uint32, error) {
+	if r.pos+n > len(r.b)*8 {
+		return 0, ErrBitstreamTooShort
+	}
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = v<<1 | uint32(r.b[r.pos>>3]>>(7-uint(r.pos&7)))&1
+		r.pos++
+	}
+	return v, nil
+}
+
+// flag reads a single bit.
+func (r *bitReader) flag() (bool, error) {
+	v, err := r.u(1)
+	return v == 1, err
+}
+
+// ue reads an unsigned Exp-Golomb code (ue(v) in H.264 7.2).
+func (r *bitReader) ue() (uint32, error) {
+	zeros := 0
+	for {
+		bit, err := r.u(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, ErrBitstreamTooShort
+		}
+	}
+	v, err := r.u(zeros)
+	return 1<<uint(zeros) - 1 + v, err
+}
+
+// se reads a signed Exp-Golomb code (se(v) in H.264 7.2).
+func (r *bitReader) se() (int32, error) {
+	v, err := r.ue()
+	if v&1 == 1 {
+		return int32(v/2) + 1, err
+	}
+	return -int32(v / 2), err
+}
+
+// unescapeRBSP removes the emulation prevention bytes (0x03 after two zero bytes) of a NAL unit.
+func unescapeRBSP(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 0x03 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+package codec
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	ErrInvalidNALULengthSize = errors.New("codec: NAL unit length size must be 1, 2 or 4")
+	ErrNALUTooLong           = errors.New("codec: NAL unit is longer than the frame")
+)
+
+// NALUType is the type in the header byte of a NAL unit (H.264 Table 7-1).
+type NALUType uint8
+
+const (
+	NALUTypeNonIDR NALUType = 1
+	NALUTypeIDR    NALUType = 5
+	NALUTypeSEI    NALUType = 6
+	NALUTypeSPS    NALUType = 7
+	NALUTypePPS    NALUType = 8
+	NALUTypeAUD    NALUType = 9
+)
+
+func (t NALUType) String() string {
+	switch t {
+	case NALUTypeNonIDR:
+		return "non-IDR"
+	case NALUTypeIDR:
+		return "IDR"
+	case NALUTypeSEI:
+		return "SEI"
+	case NALUTypeSPS:
+		return "SPS"
+	case NALUTypePPS:
+		return "PPS"
+	case NALUTypeAUD:
+		return "AUD"
+	}
+	return "NALU(" + strconv.Itoa(int(t)) + ")"
+}
+
+// NALU is a NAL unit including its header byte, without a length prefix or a start code.
+type NALU []byte
+
+// Type returns the type of the NAL unit, 0 for an empty one.
+func (n NALU) Type() NALUType {
+	if len(n) == 0 {
+		return 0
+	}
+	return NALUType(n[0] & 0x1F)
+}
+
+// Annex-B start code and the access unit delimiter NAL unit (primary_pic_type 7: any slice type)
+var (
+	startCode = []byte{0x00, 0x00, 0x00, 0x01}
+	audNALU   = []byte{0x09, 0xF0}
+)
+
+// VideoFrame is an H.264 access unit, the NAL units of an AVC NALU packet.
+type VideoFrame struct {
+	// KeyFrame is set for the IDR frames by ParseVideoFrame, the containers could set it from their frame type.
+	// CompositionTime is PTS - DTS in milliseconds, from the container too.
+	KeyFrame        bool
+	CompositionTime int32
+	NALUs           []NALU
+}
+
+// ParseVideoFrame splits an AVCC frame (NAL units with a length prefix of lengthSize bytes, the NALULengthSize
+// of the sequence header) into NAL units. The slices point into data. If a length is broken, the NAL units
+// before it are returned with the error.
+func ParseVideoFrame(data []byte, lengthSize int) (*VideoFrame, error) {
+	if lengthSize != 1 && lengthSize != 2 && lengthSize != 4 {
+		return nil, ErrInvalidNALULengthSize
+	}
+	f := &VideoFrame{}
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return f, ErrNALUTooLong
+		}
+		var size int
+		for i := 0; i < lengthSize; i++ {
+			size = size<<8 | int(data[i])
+		}
+		data = data[lengthSize:]
+		if size > len(data) {
+			return f, ErrNALUTooLong
+		}
+		if size > 0 {
+			f.NALUs = append(f.NALUs, NALU(data[:size]))
+		}
+		data = data[size:]
+	}
+	f.KeyFrame = f.IsIDR()
+	return f, nil
+}
+
+// Types returns the types of the NAL units in order.
+func (f *VideoFrame) Types() []NALUType {
+	types := make([]NALUType, len(f.NALUs))
+	for i, n := range f.NALUs {
+		types[i] = n.Type()
+	}
+	return types
+}
+
+// Has reports whether the frame has a NAL unit of the type.
+func (f *VideoFrame) Has(t NALUType) bool {
+	for _, n := range f.NALUs {
+		if n.Type() == t {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIDR reports whether the frame has an IDR slice, the decoding can start on it.
+func (f *VideoFrame) IsIDR() bool {
+	return f.Has(NALUTypeIDR)
+}
+
+// AnnexB returns the frame as an Annex-B byte stream, see AppendAnnexB.
+func (f *VideoFrame) AnnexB(avc *AVCDecoderConfigurationRecord) []byte {
+	return f.AppendAnnexB(nil, avc)
+}
+
+// AppendAnnexB appends the frame to dst as an Annex-B byte stream (NAL units with start codes), the way
+// the MPEG-TS muxers and the raw .h264 decoders need it. The access unit starts with an AUD, and the SPS
+// and PPS of avc (which could be nil) are added before an IDR frame which doesn't carry both of them,
+// so decoding could start at any IDR frame.
+func (f *VideoFrame) AppendAnnexB(dst []byte, avc *AVCDecoderConfigurationRecord) []byte {
+	dst = append(dst, startCode...)
+	dst = append(dst, audNALU...)
+	if avc != nil && f.IsIDR() && !(f.Has(NALUTypeSPS) && f.Has(NALUTypePPS)) {
+		for _, sps := range avc.SPS {
+			dst = append(append(dst, startCode...), sps...)
+		}
+		for _, pps := range avc.PPS {
+			dst = append(append(dst, startCode...), pps...)
+		}
+	}
+	for _, n := range f.NALUs {
+		if n.Type() == NALUTypeAUD {
+			// Replaced by the one at the start
+			continue
+		}
+		dst = append(append(dst, startCode...), n...)
+	}
+	return dst
+}
@@ -0,0 +1,156 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var (
+	testIDR    = NALU{0x65, 0x88, 0x84}
+	testNonIDR = NALU{0x41, 0x9A}
+	testSEI    = NALU{0x06, 0x05, 0x01}
+	testSPS    = NALU{0x67, 0x42, 0xC0, 0x1E}
+	testPPS    = NALU{0x68, 0xCE, 0x3C, 0x80}
+	testAUD    = NALU{0x09, 0x10}
+)
+
+func TestParseVideoFrame(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		lengthSize int
+		want       *VideoFrame
+		wantErr    error
+	}{
+		{
+			name:       "4 byte lengths",
+			data:       []byte{0, 0, 0, 3, 0x06, 0x05, 0x01, 0, 0, 0, 3, 0x65, 0x88, 0x84},
+			lengthSize: 4,
+			want:       &VideoFrame{KeyFrame: true, NALUs: []NALU{testSEI, testIDR}},
+		},
+		{
+			name:       "2 byte lengths",
+			data:       []byte{0, 2, 0x41, 0x9A},
+			lengthSize: 2,
+			want:       &VideoFrame{NALUs: []NALU{testNonIDR}},
+		},
+		{
+			name:       "1 byte lengths",
+			data:       []byte{2, 0x41, 0x9A, 3, 0x65, 0x88, 0x84},
+			lengthSize: 1,
+			want:       &VideoFrame{KeyFrame: true, NALUs: []NALU{testNonIDR, testIDR}},
+		},
+		{
+			name:       "empty NAL units are skipped",
+			data:       []byte{0, 0, 0, 2, 0x41, 0x9A},
+			lengthSize: 2,
+			want:       &VideoFrame{NALUs: []NALU{testNonIDR}},
+		},
+		{
+			name:       "empty frame",
+			lengthSize: 4,
+			want:       &VideoFrame{},
+		},
+		{
+			name:       "3 byte lengths",
+			data:       []byte{0, 0, 2, 0x41, 0x9A},
+			lengthSize: 3,
+			wantErr:    ErrInvalidNALULengthSize,
+		},
+		{
+			name:       "truncated NAL unit",
+			data:       []byte{0, 0, 0, 2, 0x41, 0x9A, 0, 0, 0, 3, 0x65, 0x88},
+			lengthSize: 4,
+			want:       &VideoFrame{NALUs: []NALU{testNonIDR}},
+			wantErr:    ErrNALUTooLong,
+		},
+		{
+			name:       "truncated length",
+			data:       []byte{0, 0, 0, 2, 0x41, 0x9A, 0, 0},
+			lengthSize: 4,
+			want:       &VideoFrame{NALUs: []NALU{testNonIDR}},
+			wantErr:    ErrNALUTooLong,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVideoFrame(tt.data, tt.lengthSize)
+			if err != tt.wantErr {
+				t.Fatalf("ParseVideoFrame() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseVideoFrame() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// annexB joins the NAL units with start codes.
+func annexB(nalus ...NALU) []byte {
+	var b []byte
+	for _, n := range nalus {
+		b = append(append(b, startCode...), n...)
+	}
+	return b
+}
+
+func TestAppendAnnexB(t *testing.T) {
+	avc := &AVCDecoderConfigurationRecord{SPS: [][]byte{testSPS}, PPS: [][]byte{testPPS}}
+	aud := NALU(audNALU)
+
+	tests := []struct {
+		name  string
+		frame *VideoFrame
+		avc   *AVCDecoderConfigurationRecord
+		want  []byte
+	}{
+		{
+			name:  "SPS and PPS before an IDR frame",
+			frame: &VideoFrame{NALUs: []NALU{testSEI, testIDR}},
+			avc:   avc,
+			want:  annexB(aud, testSPS, testPPS, testSEI, testIDR),
+		},
+		{
+			name:  "IDR frame with SPS and PPS",
+			frame: &VideoFrame{NALUs: []NALU{testSPS, testPPS, testIDR}},
+			avc:   avc,
+			want:  annexB(aud, testSPS, testPPS, testIDR),
+		},
+		{
+			name:  "IDR frame with only a PPS",
+			frame: &VideoFrame{NALUs: []NALU{testPPS, testIDR}},
+			avc:   avc,
+			want:  annexB(aud, testSPS, testPPS, testPPS, testIDR),
+		},
+		{
+			name:  "non-IDR frame",
+			frame: &VideoFrame{NALUs: []NALU{testNonIDR}},
+			avc:   avc,
+			want:  annexB(aud, testNonIDR),
+		},
+		{
+			name:  "AUD first",
+			frame: &VideoFrame{NALUs: []NALU{testAUD, testNonIDR}},
+			avc:   avc,
+			want:  annexB(aud, testNonIDR),
+		},
+		{
+			name:  "IDR frame without a sequence header",
+			frame: &VideoFrame{NALUs: []NALU{testIDR}},
+			want:  annexB(aud, testIDR),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := []byte{0x47}
+			got := tt.frame.AppendAnnexB(prefix, tt.avc)
+			if !bytes.Equal(got, append(prefix, tt.want...)) {
+				t.Errorf("AppendAnnexB() = % X, want % X", got, append(prefix, tt.want...))
+			}
+			if got := tt.frame.AnnexB(tt.avc); !bytes.Equal(got, tt.want) {
+				t.Errorf("AnnexB() = % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
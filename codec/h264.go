@@ -0,0 +1,85 @@
+// Package codec parses the codec configuration of the H.264 and AAC streams carried in RTMP.
+package codec
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrShortAVCDecoderConfigurationRecord = errors.New("codec: AVCDecoderConfigurationRecord is too short")
+	ErrNoSPS                              = errors.New("codec: AVCDecoderConfigurationRecord has no SPS")
+)
+
+// AVCDecoderConfigurationRecord is the payload of an AVC sequence header (ISO/IEC 14496-15 5.2.4.1).
+// It is also the content of the avcC box of the MP4 files.
+type AVCDecoderConfigurationRecord struct {
+	ConfigurationVersion uint8
+	ProfileIndication    uint8
+	ProfileCompatibility uint8
+	LevelIndication      uint8
+	// NALULengthSize is the size of the length field before every NAL unit of the frames: 1, 2 or 4 bytes
+	NALULengthSize int
+	SPS            [][]byte
+	PPS            [][]byte
+}
+
+// ParseAVCDecoderConfigurationRecord parses the record, the SPS and PPS slices point into b.
+func ParseAVCDecoderConfigurationRecord(b []byte) (*AVCDecoderConfigurationRecord, error) {
+	if len(b) < 7 {
+		return nil, ErrShortAVCDecoderConfigurationRecord
+	}
+	r := &AVCDecoderConfigurationRecord{
+		ConfigurationVersion: b[0],
+		ProfileIndication:    b[1],
+		ProfileCompatibility: b[2],
+		LevelIndication:      b[3],
+		NALULengthSize:       int(b[4]&0x03) + 1,
+	}
+
+	pos := 5
+	var err error
+	// The number of SPS is in the lower 5 bits, the number of PPS is a whole byte
+	if r.SPS, pos, err = readParameterSets(b, pos, int(b[pos]&0x1F)); err != nil {
+		return nil, err
+	}
+	if pos >= len(b) {
+		return nil, ErrShortAVCDecoderConfigurationRecord
+	}
+	if r.PPS, _, err = readParameterSets(b, pos, int(b[pos])); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Codec returns the codecs parameter of the MIME type (RFC 6381), like "avc1.64001f".
+func (r *AVCDecoderConfigurationRecord) Codec() string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", r.ProfileIndication, r.ProfileCompatibility, r.LevelIndication)
+}
+
+// DecodeSPS decodes the first SPS of the record.
+func (r *AVCDecoderConfigurationRecord) DecodeSPS() (*SPS, error) {
+	if len(r.SPS) == 0 {
+		return nil, ErrNoSPS
+	}
+	return ParseSPS(r.SPS[0])
+}
+
+// readParameterSets reads count parameter sets after the count byte at pos, each of them has a 16 bit length.
+func readParameterSets(b []byte, pos int, count int) ([][]byte, int, error) {
+	pos++
+	sets := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if pos+2 > len(b) {
+			return nil, pos, ErrShortAVCDecoderConfigurationRecord
+		}
+		size := int(b[pos])<<8 | int(b[pos+1])
+		pos += 2
+		if pos+size > len(b) {
+			return nil, pos, ErrShortAVCDecoderConfigurationRecord
+		}
+		sets = append(sets, b[pos:pos+size])
+		pos += size
+	}
+	return sets, pos, nil
+}
@@ -0,0 +1,303 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrNotSPS = errors.New("codec: NAL unit is not an SPS")
+
+// Pixel aspect ratios of the aspect_ratio_idc values 1 to 16 (H.264 Table E-1)
+var sampleAspectRatios = [][2]int{
+	{1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11}, {32, 11},
+	{80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+// aspectRatioExtendedSAR is the aspect_ratio_idc of an explicit width and height
+const aspectRatioExtendedSAR = 255
+
+// SPS is a decoded H.264 sequence parameter set (H.264 7.3.2.1.1), the fields describing the video.
+type SPS struct {
+	ProfileIDC uint8
+	// ConstraintFlags are the constraint_set0_flag to constraint_set5_flag bits, as in the profile compatibility byte
+	ConstraintFlags uint8
+	LevelIDC        uint8
+	ID              uint32
+	// ChromaFormatIDC is 0 for monochrome, 1 for 4:2:0, 2 for 4:2:2 and 3 for 4:4:4
+	ChromaFormatIDC uint32
+	BitDepthLuma    int
+	BitDepthChroma  int
+	// FrameMBsOnly is false for interlaced video
+	FrameMBsOnly bool
+	// Width and Height of the decoded pictures after the cropping, in pixels
+	Width  int
+	Height int
+	// FrameRate from the VUI timing info, 0 if it is not there
+	FrameRate float64
+	// SARWidth and SARHeight are the pixel aspect ratio from the VUI, 1:1 if it is not there
+	SARWidth  int
+	SARHeight int
+}
+
+// ParseSPS decodes an SPS NAL unit, including its NAL header byte.
+func ParseSPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 4 {
+		return nil, ErrBitstreamTooShort
+	}
+	if NALU(nalu).Type() != NALUTypeSPS {
+		return nil, ErrNotSPS
+	}
+	s := &SPS{
+		ProfileIDC:      nalu[1],
+		ConstraintFlags: nalu[2],
+		LevelIDC:        nalu[3],
+		ChromaFormatIDC: 1,
+		BitDepthLuma:    8,
+		BitDepthChroma:  8,
+		SARWidth:        1,
+		SARHeight:       1,
+	}
+	r := &bitReader{b: unescapeRBSP(nalu[4:])}
+	if err := s.parse(r); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parse reads the fields after the level. The syntax elements are read one by one, the first error is kept.
+func (s *SPS) parse(r *bitReader) error {
+	var err error
+	ue := func() uint32 {
+		var v uint32
+		if err == nil {
+			v, err = r.ue()
+		}
+		return v
+	}
+	se := func() int32 {
+		var v int32
+		if err == nil {
+			v, err = r.se()
+		}
+		return v
+	}
+	u := func(n int) uint32 {
+		var v uint32
+		if err == nil {
+			v, err = r.u(n)
+		}
+		return v
+	}
+
+	s.ID = ue()
+	separateColourPlane := false
+	switch s.ProfileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		s.ChromaFormatIDC = ue()
+		if s.ChromaFormatIDC == 3 {
+			separateColourPlane = u(1) == 1
+		}
+		s.BitDepthLuma = int(ue()) + 8
+		s.BitDepthChroma = int(ue()) + 8
+		// qpprime_y_zero_transform_bypass_flag
+		u(1)
+		if u(1) == 1 {
+			lists := 8
+			if s.ChromaFormatIDC == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists && err == nil; i++ {
+				if u(1) == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					err = skipScalingList(r, size)
+				}
+			}
+		}
+	}
+
+	// log2_max_frame_num_minus4
+	ue()
+	switch pocType := ue(); pocType {
+	case 0:
+		// log2_max_pic_order_cnt_lsb_minus4
+		ue()
+	case 1:
+		// delta_pic_order_always_zero_flag, offset_for_non_ref_pic, offset_for_top_to_bottom_field
+		u(1)
+		se()
+		se()
+		cycle := ue()
+		for i := uint32(0); i < cycle && err == nil; i++ {
+			se()
+		}
+	}
+	// max_num_ref_frames, gaps_in_frame_num_value_allowed_flag
+	ue()
+	u(1)
+	widthInMBs := ue() + 1
+	heightInMapUnits := ue() + 1
+	s.FrameMBsOnly = u(1) == 1
+	if !s.FrameMBsOnly {
+		// mb_adaptive_frame_field_flag
+		u(1)
+	}
+	// direct_8x8_inference_flag
+	u(1)
+	var cropLeft, cropRight, cropTop, cropBottom uint32
+	if u(1) == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = ue(), ue(), ue(), ue()
+	}
+	if err != nil {
+		return err
+	}
+
+	// The cropping is in chroma samples (H.264 7.4.2.1.1)
+	frameHeightFactor := 1
+	if !s.FrameMBsOnly {
+		frameHeightFactor = 2
+	}
+	cropUnitX, cropUnitY := 1, frameHeightFactor
+	if !separateColourPlane && s.ChromaFormatIDC != 0 {
+		subWidthC, subHeightC := 2, 2
+		if s.ChromaFormatIDC == 2 {
+			subHeightC = 1
+		} else if s.ChromaFormatIDC == 3 {
+			subWidthC, subHeightC = 1, 1
+		}
+		cropUnitX, cropUnitY = subWidthC, subHeightC*frameHeightFactor
+	}
+	s.Width = int(widthInMBs)*16 - cropUnitX*int(cropLeft+cropRight)
+	s.Height = int(heightInMapUnits)*16*frameHeightFactor - cropUnitY*int(cropTop+cropBottom)
+
+	if u(1) == 1 {
+		s.parseVUI(r)
+	}
+	return nil
+}
+
+// parseVUI reads the pixel aspect ratio and the frame rate of the VUI parameters (H.264 E.1.1).
+// The VUI is optional information, a truncated one leaves the fields read so far.
+func (s *SPS) parseVUI(r *bitReader) {
+	if present, err := r.flag(); err != nil {
+		return
+	} else if present {
+		idc, err := r.u(8)
+		if err != nil {
+			return
+		}
+		if idc == aspectRatioExtendedSAR {
+			width, _ := r.u(16)
+			height, err := r.u(16)
+			if err != nil {
+				return
+			}
+			if width > 0 && height > 0 {
+				s.SARWidth, s.SARHeight = int(width), int(height)
+			}
+		} else if idc >= 1 && int(idc) <= len(sampleAspectRatios) {
+			s.SARWidth, s.SARHeight = sampleAspectRatios[idc-1][0], sampleAspectRatios[idc-1][1]
+		}
+	}
+	// overscan_info_present_flag and overscan_appropriate_flag
+	if present, err := r.flag(); err != nil {
+		return
+	} else if present {
+		r.u(1)
+	}
+	// video_signal_type_present_flag: video_format, video_full_range_flag, colour_description_present_flag
+	if present, err := r.flag(); err != nil {
+		return
+	} else if present {
+		r.u(4)
+		if colour, _ := r.flag(); colour {
+			// colour_primaries, transfer_characteristics, matrix_coefficients
+			r.u(24)
+		}
+	}
+	// chroma_loc_info_present_flag
+	if present, err := r.flag(); err != nil {
+		return
+	} else if present {
+		r.ue()
+		r.ue()
+	}
+	if present, err := r.flag(); err != nil || !present {
+		return
+	}
+	unitsInTick, _ := r.u(32)
+	timeScale, err := r.u(32)
+	if err != nil || unitsInTick == 0 {
+		return
+	}
+	// A frame is two ticks, one for each field
+	s.FrameRate = float64(timeScale) / float64(2*unitsInTick)
+}
+
+// skipScalingList reads a scaling list (H.264 7.3.2.1.1.1), only its length matters.
+func skipScalingList(r *bitReader, size int) error {
+	last, next := int32(8), int32(8)
+	for i := 0; i < size; i++ {
+		if next != 0 {
+			delta, err := r.se()
+			if err != nil {
+				return err
+			}
+			next = (last + delta + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+	return nil
+}
+
+// ProfileName returns the name of the profile, like "High".
+func (s *SPS) ProfileName() string {
+	switch s.ProfileIDC {
+	case 66:
+		if s.ConstraintFlags&0x40 != 0 {
+			return "Constrained Baseline"
+		}
+		return "Baseline"
+	case 77:
+		return "Main"
+	case 88:
+		return "Extended"
+	case 100:
+		return "High"
+	case 110:
+		return "High 10"
+	case 122:
+		return "High 4:2:2"
+	case 244:
+		return "High 4:4:4 Predictive"
+	}
+	return fmt.Sprintf("Profile %d", s.ProfileIDC)
+}
+
+// Level returns the level as it is written, like "3.1". Level 1b is signaled with level 11 and constraint_set3_flag
+// in the Baseline, Main and Extended profiles.
+func (s *SPS) Level() string {
+	if s.LevelIDC == 11 && s.ConstraintFlags&0x10 != 0 && (s.ProfileIDC == 66 || s.ProfileIDC == 77 || s.ProfileIDC == 88) {
+		return "1b"
+	}
+	return fmt.Sprintf("%d.%d", s.LevelIDC/10, s.LevelIDC%10)
+}
+
+// ChromaFormat returns the chroma subsampling, like "4:2:0".
+func (s *SPS) ChromaFormat() string {
+	switch s.ChromaFormatIDC {
+	case 0:
+		return "monochrome"
+	case 1:
+		return "4:2:0"
+	case 2:
+		return "4:2:2"
+	case 3:
+		return "4:4:4"
+	}
+	return fmt.Sprintf("chroma format %d", s.ChromaFormatIDC)
+}
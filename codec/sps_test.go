@@ -0,0 +1,87 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSPS(t *testing.T) {
+	tests := []struct {
+		name string
+		nalu []byte
+		want *SPS
+	}{
+		{
+			name: "Constrained Baseline 640x480",
+			nalu: []byte{0x67, 0x42, 0xC0, 0x1E, 0xDA, 0x02, 0x80, 0xF6, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xC8, 0x3C, 0x58, 0xBA, 0x80},
+			want: &SPS{ProfileIDC: 66, ConstraintFlags: 0xC0, LevelIDC: 30, ChromaFormatIDC: 1, BitDepthLuma: 8, BitDepthChroma: 8,
+				FrameMBsOnly: true, Width: 640, Height: 480, FrameRate: 25, SARWidth: 1, SARHeight: 1},
+		},
+		{
+			name: "High 1280x720",
+			nalu: []byte{0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x6A, 0x02, 0x02, 0x02, 0x80, 0x00, 0x00, 0x03, 0x00, 0x80, 0x00, 0x00, 0x1E, 0x07, 0x8C, 0x18, 0xCB},
+			want: &SPS{ProfileIDC: 100, LevelIDC: 31, ChromaFormatIDC: 1, BitDepthLuma: 8, BitDepthChroma: 8,
+				FrameMBsOnly: true, Width: 1280, Height: 720, FrameRate: 30, SARWidth: 1, SARHeight: 1},
+		},
+		{
+			// 1920x1088 coded, cropped to 1080
+			name: "High 1920x1080",
+			nalu: []byte{0x67, 0x64, 0x00, 0x28, 0xAC, 0xD9, 0x40, 0x78, 0x02, 0x27, 0xE5, 0xC0, 0x44, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xF0, 0x3C, 0x60, 0xC6, 0x58},
+			want: &SPS{ProfileIDC: 100, LevelIDC: 40, ChromaFormatIDC: 1, BitDepthLuma: 8, BitDepthChroma: 8,
+				FrameMBsOnly: true, Width: 1920, Height: 1080, FrameRate: 30, SARWidth: 1, SARHeight: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSPS(tt.nalu)
+			if err != nil {
+				t.Fatalf("ParseSPS() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSPS() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSPSErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		nalu []byte
+		want error
+	}{
+		{"too short", []byte{0x67, 0x42, 0xC0}, ErrBitstreamTooShort},
+		{"PPS", []byte{0x68, 0xCE, 0x3C, 0x80}, ErrNotSPS},
+		{"truncated", []byte{0x67, 0x64, 0x00, 0x28, 0xAC}, ErrBitstreamTooShort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSPS(tt.nalu); err != tt.want {
+				t.Errorf("ParseSPS() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSPSNames(t *testing.T) {
+	tests := []struct {
+		sps     SPS
+		profile string
+		level   string
+	}{
+		{SPS{ProfileIDC: 66, LevelIDC: 30}, "Baseline", "3.0"},
+		{SPS{ProfileIDC: 66, ConstraintFlags: 0x50, LevelIDC: 11}, "Constrained Baseline", "1b"},
+		{SPS{ProfileIDC: 77, ConstraintFlags: 0x10, LevelIDC: 11}, "Main", "1b"},
+		{SPS{ProfileIDC: 100, ConstraintFlags: 0x10, LevelIDC: 11}, "High", "1.1"},
+		{SPS{ProfileIDC: 244, LevelIDC: 52}, "High 4:4:4 Predictive", "5.2"},
+		{SPS{ProfileIDC: 118, LevelIDC: 41}, "Profile 118", "4.1"},
+	}
+	for _, tt := range tests {
+		if got := tt.sps.ProfileName(); got != tt.profile {
+			t.Errorf("ProfileName() of %+v = %q, want %q", tt.sps, got, tt.profile)
+		}
+		if got := tt.sps.Level(); got != tt.level {
+			t.Errorf("Level() of %+v = %q, want %q", tt.sps, got, tt.level)
+		}
+	}
+}
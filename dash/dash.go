@@ -0,0 +1,208 @@
+// Package dash remuxes the live streams of a server into fMP4 segments and serves them with a dynamic MPEG-DASH
+// manifest.
+package dash
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/internal/output"
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+// Defaults of Config
+const (
+	DefaultSegmentDuration      = 2 * time.Second
+	DefaultTimeShiftBufferDepth = 30 * time.Second
+)
+
+// Segment template modes of the MPD
+const (
+	// TemplateTimeline lists the segments of the time shift buffer in a SegmentTimeline, with their real durations
+	TemplateTimeline = "timeline"
+	// TemplateNumber only gives the segment duration, the players calculate the segment numbers from the wall clock.
+	// It needs a key frame interval dividing the segment duration, so every segment is cut at the same length.
+	TemplateNumber = "number"
+)
+
+// Config of the DASH output, the zero values are replaced with the defaults.
+type Config struct {
+	// SegmentDuration is the target duration, the segments are cut on the first key frame after it
+	SegmentDuration time.Duration
+	// TimeShiftBufferDepth is how far back the players could seek, the MPD lists the segments in it
+	TimeShiftBufferDepth time.Duration
+	// AvailabilityStartTime of the MPD, the period starts when the first segment of the stream started after it.
+	// If zero, it is the start of the first segment of each stream.
+	AvailabilityStartTime time.Time
+	// Template is TemplateTimeline or TemplateNumber, TemplateTimeline if empty
+	Template string
+	// Logger logs the errors, the standard logger if nil
+	Logger *log.Logger
+}
+
+func (c Config) withDefaults() Config {
+	if c.SegmentDuration <= 0 {
+		c.SegmentDuration = DefaultSegmentDuration
+	}
+	if c.TimeShiftBufferDepth <= 0 {
+		c.TimeShiftBufferDepth = DefaultTimeShiftBufferDepth
+	}
+	if c.Template == "" {
+		c.Template = TemplateTimeline
+	}
+	if c.Logger == nil {
+		c.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return c
+}
+
+// DASH segments every stream published to the registry and serves them:
+//
+//	GET /{app}/{stream}/manifest.mpd
+//	GET /{app}/{stream}/{video|audio}/init.mp4
+//	GET /{app}/{stream}/{video|audio}/{number}.m4s
+type DASH struct {
+	config Config
+
+	mu sync.Mutex
+	// Segmenters by app/stream, kept for a while after the stream ends
+	segmenters map[string]*Segmenter
+	// Closed when the last segmenter of the app/stream is closed, a new publish starts after it
+	closed map[string]chan struct{}
+	// Next segment number of the streams published before, so the segment URLs of a new publish are new too
+	numbers map[string]int
+}
+
+// New creates the DASH output of the streams published to the registry from now on.
+func New(registry *server.Registry, config Config) *DASH {
+	d := &DASH{
+		config:     config.withDefaults(),
+		segmenters: make(map[string]*Segmenter),
+		closed:     make(map[string]chan struct{}),
+		numbers:    make(map[string]int),
+	}
+	registry.OnPublish(d.publish)
+	return d
+}
+
+// publish starts segmenting a new stream.
+func (d *DASH) publish(st *server.Stream) {
+	if !output.ValidName(st.App()) || !output.ValidName(st.Key()) {
+		return
+	}
+	name := st.App() + "/" + st.Key()
+	sub := st.Subscribe()
+
+	// The segmenter of the previous publish could still write its last segment, the new one continues
+	// its numbers after it. The packets are queued meanwhile.
+	closed := make(chan struct{})
+	d.mu.Lock()
+	previous := d.closed[name]
+	d.closed[name] = closed
+	d.mu.Unlock()
+
+	go d.run(name, st, sub, previous, closed)
+}
+
+// run feeds the packets to a new segmenter until the stream ends, then forgets it after a while.
+func (d *DASH) run(name string, st *server.Stream, sub *server.Subscriber, previous, closed chan struct{}) {
+	if previous != nil {
+		<-previous
+	}
+	d.mu.Lock()
+	seg := NewSegmenter(d.config, st.Metadata(), d.numbers[name])
+	d.segmenters[name] = seg
+	d.mu.Unlock()
+
+	var err error
+	for p := range sub.Packets() {
+		if err != nil {
+			continue
+		}
+		if err = seg.WritePacket(p); err != nil {
+			d.config.Logger.Println("DASH error of", name, err)
+			st.Unsubscribe(sub)
+		}
+	}
+	seg.Close()
+
+	d.mu.Lock()
+	d.numbers[name] = seg.Number()
+	if d.closed[name] == closed {
+		delete(d.closed, name)
+	}
+	d.mu.Unlock()
+	close(closed)
+
+	// Players could still finish the ended presentation
+	time.AfterFunc(d.config.TimeShiftBufferDepth, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.segmenters[name] == seg {
+			delete(d.segmenters, name)
+		}
+	})
+}
+
+func (d *DASH) segmenter(name string) *Segmenter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.segmenters[name]
+}
+
+func (d *DASH) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) < 3 {
+		http.NotFound(w, r)
+		return
+	}
+	seg := d.segmenter(parts[0] + "/" + parts[1])
+	if seg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var data []byte
+	var ok bool
+	switch {
+	case len(parts) == 3 && parts[2] == "manifest.mpd":
+		var err error
+		data, err = seg.MPD(time.Now())
+		if err != nil && err != ErrNotStarted {
+			d.config.Logger.Println("DASH manifest error of", parts[0]+"/"+parts[1], err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if ok = err == nil; ok {
+			w.Header().Set("Content-Type", "application/dash+xml")
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+	case len(parts) == 4 && parts[3] == "init.mp4":
+		data, ok = seg.Init(parts[2])
+	case len(parts) == 4 && strings.HasSuffix(parts[3], ".m4s"):
+		number, err := strconv.Atoi(strings.TrimSuffix(parts[3], ".m4s"))
+		if err == nil {
+			data, ok = seg.Segment(parts[2], number)
+		}
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 4 {
+		// The representation ID is the content type, video or audio
+		w.Header().Set("Content-Type", parts[2]+"/mp4")
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,22 @@
+package dash
+
+import (
+	"testing"
+
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+func TestPublishInvalidName(t *testing.T) {
+	registry := server.NewRegistry()
+	d := New(registry, Config{})
+	for _, key := range []string{"..", "a/b", `a\b`, "a?b", "a#b"} {
+		st, err := registry.Publish("live", key, nil)
+		if err != nil {
+			t.Fatalf("Publish(%q) error = %v", key, err)
+		}
+		d.publish(st)
+		if st.Subscribers() != 0 {
+			t.Errorf("stream key %q is segmented", key)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package dash
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var ErrNotStarted = errors.New("dash: the first segment hasn't started yet")
+
+// The elements of the MPD (ISO/IEC 23009-1), only the attributes used by the live profile
+type mpd struct {
+	XMLName                    xml.Name     `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                   string       `xml:"profiles,attr"`
+	Type                       string       `xml:"type,attr"`
+	AvailabilityStartTime      string       `xml:"availabilityStartTime,attr"`
+	PublishTime                string       `xml:"publishTime,attr"`
+	MinimumUpdatePeriod        string       `xml:"minimumUpdatePeriod,attr,omitempty"`
+	MediaPresentationDuration  string       `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinBufferTime              string       `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       string       `xml:"timeShiftBufferDepth,attr"`
+	SuggestedPresentationDelay string       `xml:"suggestedPresentationDelay,attr,omitempty"`
+	Period                     mpdPeriod    `xml:"Period"`
+	UTCTiming                  mpdUTCTiming `xml:"UTCTiming"`
+}
+
+type mpdPeriod struct {
+	ID             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ID               int               `xml:"id,attr"`
+	ContentType      string            `xml:"contentType,attr"`
+	MimeType         string            `xml:"mimeType,attr"`
+	SegmentAlignment bool              `xml:"segmentAlignment,attr"`
+	StartWithSAP     int               `xml:"startWithSAP,attr"`
+	Representation   mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID                        string             `xml:"id,attr"`
+	Codecs                    string             `xml:"codecs,attr"`
+	Bandwidth                 int                `xml:"bandwidth,attr"`
+	Width                     int                `xml:"width,attr,omitempty"`
+	Height                    int                `xml:"height,attr,omitempty"`
+	FrameRate                 string             `xml:"frameRate,attr,omitempty"`
+	Sar                       string             `xml:"sar,attr,omitempty"`
+	AudioSamplingRate         int                `xml:"audioSamplingRate,attr,omitempty"`
+	AudioChannelConfiguration *mpdDescriptor     `xml:"AudioChannelConfiguration"`
+	SegmentTemplate           mpdSegmentTemplate `xml:"SegmentTemplate"`
+}
+
+type mpdDescriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type mpdSegmentTemplate struct {
+	Timescale              uint32              `xml:"timescale,attr"`
+	PresentationTimeOffset uint64              `xml:"presentationTimeOffset,attr"`
+	Duration               uint64              `xml:"duration,attr,omitempty"`
+	StartNumber            int                 `xml:"startNumber,attr"`
+	Initialization         string              `xml:"initialization,attr"`
+	Media                  string              `xml:"media,attr"`
+	SegmentTimeline        *mpdSegmentTimeline `xml:"SegmentTimeline"`
+}
+
+type mpdSegmentTimeline struct {
+	S []mpdS `xml:"S"`
+}
+
+// mpdS is r+1 segments of d duration from t
+type mpdS struct {
+	T uint64 `xml:"t,attr"`
+	D uint64 `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+type mpdUTCTiming struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+// duration formats a duration as an xs:duration.
+func duration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', 3, 64) + "S"
+}
+
+// MPD renders the manifest at the time now. It returns ErrNotStarted if the first segment hasn't started yet.
+func (s *Segmenter) MPD(now time.Time) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.startedAt.IsZero() {
+		return nil, ErrNotStarted
+	}
+
+	availabilityStartTime := s.config.AvailabilityStartTime
+	if availabilityStartTime.IsZero() {
+		availabilityStartTime = s.startedAt
+	}
+	periodStart := s.startedAt.Sub(availabilityStartTime)
+	if periodStart < 0 {
+		periodStart = 0
+	}
+
+	m := mpd{
+		Profiles:                   "urn:mpeg:dash:profile:isoff-live:2011",
+		Type:                       "dynamic",
+		AvailabilityStartTime:      availabilityStartTime.UTC().Format(time.RFC3339Nano),
+		PublishTime:                now.UTC().Format(time.RFC3339Nano),
+		MinBufferTime:              duration(s.config.SegmentDuration),
+		TimeShiftBufferDepth:       duration(s.config.TimeShiftBufferDepth),
+		SuggestedPresentationDelay: duration(3 * s.config.SegmentDuration),
+		Period: mpdPeriod{
+			ID:    "0",
+			Start: duration(periodStart),
+		},
+		UTCTiming: mpdUTCTiming{
+			SchemeIDURI: "urn:mpeg:dash:utc:direct:2014",
+			Value:       now.UTC().Format(time.RFC3339Nano),
+		},
+	}
+	if s.ended {
+		m.MediaPresentationDuration = duration(periodStart + s.contentDuration())
+	} else {
+		m.MinimumUpdatePeriod = duration(s.config.SegmentDuration)
+	}
+
+	for i, r := range []*representation{s.video, s.audio} {
+		if r == nil {
+			continue
+		}
+		set := mpdAdaptationSet{
+			ID:               i,
+			ContentType:      r.id,
+			MimeType:         r.id + "/mp4",
+			SegmentAlignment: true,
+			StartWithSAP:     1,
+			Representation:   s.renderRepresentation(r),
+		}
+		m.Period.AdaptationSets = append(m.Period.AdaptationSets, set)
+	}
+
+	out, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+// renderRepresentation renders the representation with the segments of the time shift buffer. s.mu must be held.
+func (s *Segmenter) renderRepresentation(r *representation) mpdRepresentation {
+	segments := r.segments
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		depth := uint64(s.config.TimeShiftBufferDepth.Seconds() * float64(r.track.TimeScale))
+		for len(segments) > 1 && segments[0].time+segments[0].duration+depth < last.time+last.duration {
+			segments = segments[1:]
+		}
+	}
+
+	rep := mpdRepresentation{
+		ID:        r.id,
+		Codecs:    r.codecs,
+		Bandwidth: bandwidth(segments, r.track.TimeScale),
+		SegmentTemplate: mpdSegmentTemplate{
+			Timescale:              r.track.TimeScale,
+			PresentationTimeOffset: r.presentationTimeOffset,
+			Initialization:         r.id + "/init.mp4",
+			Media:                  r.id + "/$Number$.m4s",
+		},
+	}
+	if r.id == representationVideo {
+		rep.Width = r.track.Width
+		rep.Height = r.track.Height
+		if r.sps != nil {
+			rep.Sar = fmt.Sprintf("%d:%d", r.sps.SARWidth, r.sps.SARHeight)
+			rep.FrameRate = frameRate(r.sps.FrameRate)
+		}
+	} else {
+		// The output of the decoder, the SBR rate and the stereo of PS for HE-AAC
+		rep.AudioSamplingRate = r.asc.OutputSampleRate()
+		rep.AudioChannelConfiguration = &mpdDescriptor{
+			SchemeIDURI: "urn:mpeg:dash:23003:3:audio_channel_configuration:2011",
+			Value:       strconv.Itoa(r.asc.Channels()),
+		}
+	}
+
+	if s.config.Template == TemplateNumber {
+		// The players calculate the segment numbers from the wall clock
+		rep.SegmentTemplate.StartNumber = r.firstNumber
+		rep.SegmentTemplate.Duration = uint64(s.config.SegmentDuration.Seconds() * float64(r.track.TimeScale))
+		return rep
+	}
+
+	timeline := &mpdSegmentTimeline{}
+	for i, seg := range segments {
+		if i == 0 {
+			rep.SegmentTemplate.StartNumber = seg.number
+		}
+		if n := len(timeline.S); n > 0 {
+			last := &timeline.S[n-1]
+			if last.D == seg.duration && last.T+uint64(last.R+1)*last.D == seg.time {
+				last.R++
+				continue
+			}
+		}
+		timeline.S = append(timeline.S, mpdS{T: seg.time, D: seg.duration})
+	}
+	rep.SegmentTemplate.SegmentTimeline = timeline
+	return rep
+}
+
+// frameRate formats a frame rate as a FrameRateType, like "25" or "30000/1001". It returns "" for 0.
+func frameRate(fps float64) string {
+	if fps <= 0 {
+		return ""
+	}
+	if fps == math.Trunc(fps) {
+		return strconv.Itoa(int(fps))
+	}
+	// NTSC rates like 29.97 are 30000/1001
+	if n := math.Round(fps * 1001); math.Abs(n/1001-fps) < 1e-6 {
+		return fmt.Sprintf("%d/1001", int(n))
+	}
+	return fmt.Sprintf("%d/1000", int(math.Round(fps*1000)))
+}
+
+// contentDuration returns the duration from the start of the first segment to the end of the last one.
+// s.mu must be held.
+func (s *Segmenter) contentDuration() time.Duration {
+	r := s.video
+	if r == nil {
+		r = s.audio
+	}
+	if r == nil || len(r.segments) == 0 {
+		return 0
+	}
+	last := r.segments[len(r.segments)-1]
+	end := last.time + last.duration - r.presentationTimeOffset
+	return time.Duration(float64(end) / float64(r.track.TimeScale) * float64(time.Second))
+}
+
+// bandwidth returns the average bitrate of the segments in bits per second.
+func bandwidth(segments []*segment, timeScale uint32) int {
+	var size, d uint64
+	for _, seg := range segments {
+		size += uint64(len(seg.data))
+		d += seg.duration
+	}
+	if d == 0 {
+		// The attribute is mandatory, some value is needed before the first segment
+		return 1
+	}
+	return int(size * 8 * uint64(timeScale) / d)
+}
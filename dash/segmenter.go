@@ -0,0 +1,381 @@
+package dash
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/codec"
+	"github.com/gerifield/mini-stream-test/fmp4"
+	"github.com/gerifield/mini-stream-test/internal/output"
+	"github.com/gerifield/mini-stream-test/server"
+	"github.com/torresjeff/rtmp/audio"
+	"github.com/torresjeff/rtmp/video"
+)
+
+// Representation IDs, they are also the directories of the init and media segments
+const (
+	representationVideo = "video"
+	representationAudio = "audio"
+)
+
+// segment is a media segment of a representation, a single fMP4 fragment.
+type segment struct {
+	number int
+	// time and duration in the time scale of the track
+	time     uint64
+	duration uint64
+	data     []byte
+}
+
+// representation is the init segment and the media segments of a track.
+type representation struct {
+	id     string
+	track  *fmp4.Track
+	codecs string
+	// sps of the video, nil for audio or if it couldn't be decoded
+	sps *codec.SPS
+	// asc of the audio, nil for video
+	asc  *codec.AudioSpecificConfig
+	init []byte
+	// presentationTimeOffset is the decode time of the first sample
+	presentationTimeOffset uint64
+	segments               []*segment
+	// firstNumber is the number of the first segment, number is the next one. With TemplateTimeline every
+	// representation counts its own segments, there are no holes in the numbers when only the other one had
+	// samples for a segment. With TemplateNumber the numbers follow the start time of the segments.
+	firstNumber int
+	number      int
+
+	// Samples of the segment being written
+	fragment   fmp4.TrackFragment
+	decodeTime uint64
+}
+
+func (r *representation) add(sample fmp4.Sample, decodeTime uint64) {
+	if len(r.fragment.Samples) == 0 {
+		r.fragment.BaseMediaDecodeTime = decodeTime
+	}
+	r.fragment.Samples = append(r.fragment.Samples, sample)
+}
+
+// segment returns the media segment with the number, or nil. Segmenter.mu must be held.
+func (r *representation) segment(number int) *segment {
+	for _, seg := range r.segments {
+		if seg.number == number {
+			return seg
+		}
+	}
+	return nil
+}
+
+// Segmenter remuxes the H.264 and AAC packets of a stream into fMP4 segments, a video and an audio
+// representation, and keeps them in memory with the MPD. Segments are cut on the video key frames
+// (or on any audio frame without video) once they reach the target duration.
+type Segmenter struct {
+	config          Config
+	segmentDuration uint64
+
+	// The fields below are only used by the goroutine writing the packets
+	avcConfig []byte
+	avc       *codec.AVCDecoderConfigurationRecord
+	asc       *codec.AudioSpecificConfig
+	ascData   []byte
+	// first decides whether the first segment starts without video
+	first   output.FirstSegment
+	started bool
+	// The last video frame, its duration is only known when the next one arrives
+	pending      *fmp4.Sample
+	pendingTime  uint64
+	lastDuration uint32
+	// Start of the first and the current segment in milliseconds
+	startTimestamp uint64
+	segmentStart   uint64
+	fragmentNumber uint32
+
+	mu    sync.Mutex
+	video *representation
+	audio *representation
+	// firstNumber is the number of the first segment of the representations
+	firstNumber int
+	// startedAt is the wall clock time of the start of the first segment
+	startedAt time.Time
+	ended     bool
+	endedAt   time.Time
+}
+
+// NewSegmenter creates a Segmenter whose first segment has the given number. The metadata (which could be nil)
+// tells whether the stream has video, without it video is expected.
+func NewSegmenter(config Config, metadata *server.StreamMetadata, number int) *Segmenter {
+	config = config.withDefaults()
+	return &Segmenter{
+		config:          config,
+		segmentDuration: uint64(config.SegmentDuration.Milliseconds()),
+		first:           output.NewFirstSegment(metadata, uint64(config.SegmentDuration.Milliseconds())),
+		firstNumber:     number,
+	}
+}
+
+// WritePacket remuxes an audio or video packet, the other packets are ignored.
+func (s *Segmenter) WritePacket(p *server.Packet) error {
+	switch p.Type {
+	case server.TypeVideo:
+		return s.writeVideo(p)
+	case server.TypeAudio:
+		return s.writeAudio(p)
+	}
+	return nil
+}
+
+func (s *Segmenter) writeVideo(p *server.Packet) error {
+	// Frame type and codec, AVC packet type, then the 24 bit composition time
+	if len(p.Payload) < 5 || video.Codec(p.Payload[0]&0x0F) != video.H264 {
+		return nil
+	}
+	switch video.AVCPacketType(p.Payload[1]) {
+	case video.AVCSequenceHeader:
+		avc, err := codec.ParseAVCDecoderConfigurationRecord(p.Payload[5:])
+		if err != nil {
+			return err
+		}
+		s.avc = avc
+		s.avcConfig = p.Payload[5:]
+		return nil
+	case video.AVCNALU:
+	default:
+		return nil
+	}
+	if s.avcConfig == nil {
+		return nil
+	}
+
+	keyFrame := p.IsKeyFrame()
+	if !s.started {
+		if !keyFrame {
+			return nil
+		}
+		s.start(true, p.Timestamp)
+	}
+	if s.video == nil {
+		return nil
+	}
+
+	decodeTime := p.Timestamp * 90
+	if s.pending != nil {
+		s.pending.Duration = uint32(decodeTime - s.pendingTime)
+		s.lastDuration = s.pending.Duration
+		s.video.add(*s.pending, s.pendingTime)
+	}
+	if keyFrame && p.Timestamp >= s.segmentStart+s.segmentDuration {
+		s.finishSegment()
+		s.segmentStart = p.Timestamp
+	}
+	compositionTime := int32(binary.BigEndian.Uint32(p.Payload[1:5])<<8) >> 8
+	s.pending = &fmp4.Sample{
+		CompositionOffset: compositionTime * 90,
+		KeyFrame:          keyFrame,
+		Data:              p.Payload[5:],
+	}
+	s.pendingTime = decodeTime
+	return nil
+}
+
+func (s *Segmenter) writeAudio(p *server.Packet) error {
+	if len(p.Payload) < 2 || audio.Format(p.Payload[0]>>4) != audio.AAC {
+		return nil
+	}
+	if audio.AACPacketType(p.Payload[1]) == audio.AACSequenceHeader {
+		asc, err := codec.ParseAudioSpecificConfig(p.Payload[2:])
+		if err != nil {
+			return err
+		}
+		s.asc = asc
+		s.ascData = p.Payload[2:]
+		return nil
+	}
+	if s.asc == nil {
+		return nil
+	}
+
+	if !s.started {
+		if !s.first.StartsWithAudio(p.Timestamp, s.avcConfig != nil) {
+			return nil
+		}
+		s.start(false, p.Timestamp)
+	}
+	if s.audio == nil {
+		return nil
+	}
+
+	if s.video == nil && p.Timestamp >= s.segmentStart+s.segmentDuration {
+		s.finishSegment()
+		s.segmentStart = p.Timestamp
+	}
+	// The decode time counts the samples, so the frames follow each other without gaps in the segments
+	s.audio.add(fmp4.Sample{Duration: uint32(s.asc.FrameLength), KeyFrame: true, Data: p.Payload[2:]}, s.audio.decodeTime)
+	s.audio.decodeTime += uint64(s.asc.FrameLength)
+	return nil
+}
+
+// start creates the representations and their init segments when the first segment starts.
+// The streams are fixed by then: audio starting after the first key frame is left out.
+func (s *Segmenter) start(hasVideo bool, timestamp uint64) {
+	s.started = true
+	s.startTimestamp = timestamp
+	s.segmentStart = timestamp
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startedAt = time.Now()
+	if hasVideo {
+		track := &fmp4.Track{
+			ID:        1,
+			Type:      fmp4.TrackVideo,
+			TimeScale: fmp4.VideoTimeScale,
+			AVCConfig: s.avcConfig,
+		}
+		sps, err := s.avc.DecodeSPS()
+		if err == nil {
+			track.Width = sps.Width
+			track.Height = sps.Height
+		}
+		s.video = &representation{
+			id:                     representationVideo,
+			track:                  track,
+			sps:                    sps,
+			codecs:                 s.avc.Codec(),
+			init:                   fmp4.InitSegment([]*fmp4.Track{track}),
+			presentationTimeOffset: timestamp * 90,
+			firstNumber:            s.firstNumber,
+			number:                 s.firstNumber,
+		}
+	}
+	if s.asc != nil {
+		track := &fmp4.Track{
+			ID:          1,
+			Type:        fmp4.TrackAudio,
+			TimeScale:   uint32(s.asc.SampleRate),
+			SampleRate:  s.asc.SampleRate,
+			Channels:    s.asc.Channels(),
+			AudioConfig: s.ascData,
+		}
+		decodeTime := timestamp * uint64(s.asc.SampleRate) / 1000
+		s.audio = &representation{
+			id:                     representationAudio,
+			track:                  track,
+			asc:                    s.asc,
+			codecs:                 s.asc.Codec(),
+			init:                   fmp4.InitSegment([]*fmp4.Track{track}),
+			presentationTimeOffset: decodeTime,
+			decodeTime:             decodeTime,
+			firstNumber:            s.firstNumber,
+			number:                 s.firstNumber,
+		}
+	}
+}
+
+// finishSegment adds the samples collected since the last segment as a new segment of every representation
+// which has any.
+func (s *Segmenter) finishSegment() {
+	// The players calculate the start time of the segments from the numbers, (number-startNumber)*duration
+	timeNumber := s.firstNumber + int((s.segmentStart-s.startTimestamp+s.segmentDuration/2)/s.segmentDuration)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range []*representation{s.video, s.audio} {
+		if r == nil || len(r.fragment.Samples) == 0 {
+			continue
+		}
+		s.fragmentNumber++
+		seg := &segment{
+			number: r.number,
+			time:   r.fragment.BaseMediaDecodeTime,
+			data:   fmp4.Fragment(s.fragmentNumber, []fmp4.TrackFragment{r.fragment}),
+		}
+		if s.config.Template == TemplateNumber && timeNumber > seg.number {
+			seg.number = timeNumber
+		}
+		for _, sample := range r.fragment.Samples {
+			seg.duration += uint64(sample.Duration)
+		}
+		r.fragment.Samples = nil
+		r.segments = append(r.segments, seg)
+		r.number = seg.number + 1
+
+		// Segments out of the time shift buffer are kept a bit longer, the slower players could still download them
+		keep := uint64((s.config.TimeShiftBufferDepth + 2*s.config.SegmentDuration).Seconds() * float64(r.track.TimeScale))
+		end := seg.time + seg.duration
+		for len(r.segments) > 1 && r.segments[0].time+r.segments[0].duration+keep < end {
+			r.segments = r.segments[1:]
+		}
+	}
+}
+
+// Close adds the last frame and segment, and ends the presentation.
+func (s *Segmenter) Close() {
+	if s.pending != nil {
+		// The last frame is as long as the one before it
+		s.pending.Duration = s.lastDuration
+		s.video.add(*s.pending, s.pendingTime)
+		s.pending = nil
+	}
+	if s.started {
+		s.finishSegment()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+	s.endedAt = time.Now()
+}
+
+// Number returns a number after every segment of the representations, the first number of a next Segmenter
+// of the same stream.
+func (s *Segmenter) Number() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	number := s.firstNumber
+	for _, r := range []*representation{s.video, s.audio} {
+		if r != nil && r.number > number {
+			number = r.number
+		}
+	}
+	return number
+}
+
+// representation returns the representation with the ID, or nil. s.mu must be held.
+func (s *Segmenter) representation(id string) *representation {
+	switch {
+	case id == representationVideo && s.video != nil:
+		return s.video
+	case id == representationAudio && s.audio != nil:
+		return s.audio
+	}
+	return nil
+}
+
+// Init returns the init segment of the representation.
+func (s *Segmenter) Init(id string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.representation(id)
+	if r == nil {
+		return nil, false
+	}
+	return r.init, true
+}
+
+// Segment returns a media segment of the representation.
+func (s *Segmenter) Segment(id string, number int) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.representation(id)
+	if r == nil {
+		return nil, false
+	}
+	seg := r.segment(number)
+	if seg == nil {
+		return nil, false
+	}
+	return seg.data, true
+}
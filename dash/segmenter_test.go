@@ -0,0 +1,120 @@
+package dash
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/internal/streamtest"
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+// writeStream writes seconds of 25 fps video with a key frame every second, and the audio frames
+// (one in every 40 ms) where hasAudio reports true for the second.
+func writeStream(t *testing.T, s *Segmenter, seconds int, hasAudio func(second int) bool) {
+	t.Helper()
+	packets := []*server.Packet{streamtest.AVCSequenceHeader(), streamtest.AACSequenceHeader(0)}
+	for ts := uint64(0); ts < uint64(seconds)*1000; ts += 40 {
+		packets = append(packets, streamtest.VideoFrame(ts, ts%1000 == 0))
+		if hasAudio(int(ts / 1000)) {
+			packets = append(packets, streamtest.AACFrame(ts))
+		}
+	}
+	for _, p := range packets {
+		if err := s.WritePacket(p); err != nil {
+			t.Fatalf("WritePacket() error = %v", err)
+		}
+	}
+}
+
+func segmentNumbers(r *representation) []int {
+	var numbers []int
+	for _, seg := range r.segments {
+		numbers = append(numbers, seg.number)
+	}
+	return numbers
+}
+
+func TestSegmenterNumbers(t *testing.T) {
+	tests := []struct {
+		template  string
+		wantVideo []int
+		wantAudio []int
+	}{
+		// Every representation counts its own segments
+		{TemplateTimeline, []int{10, 11, 12, 13, 14, 15}, []int{10, 11, 12, 13}},
+		// The numbers follow the time, the segments without audio are holes
+		{TemplateNumber, []int{10, 11, 12, 13, 14, 15}, []int{10, 11, 14, 15}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			s := NewSegmenter(Config{SegmentDuration: time.Second, Template: tt.template}, nil, 10)
+			// The audio stops for the segments 12 and 13
+			writeStream(t, s, 6, func(second int) bool { return second < 2 || second > 3 })
+			s.Close()
+
+			if got := segmentNumbers(s.video); !reflect.DeepEqual(got, tt.wantVideo) {
+				t.Errorf("video segments %v, want %v", got, tt.wantVideo)
+			}
+			if got := segmentNumbers(s.audio); !reflect.DeepEqual(got, tt.wantAudio) {
+				t.Errorf("audio segments %v, want %v", got, tt.wantAudio)
+			}
+			if n := s.Number(); n != 16 {
+				t.Errorf("Number() = %d, want 16", n)
+			}
+		})
+	}
+}
+
+func TestSegmenterMPD(t *testing.T) {
+	s := NewSegmenter(Config{SegmentDuration: time.Second}, nil, 0)
+	if _, err := s.MPD(time.Now()); err != ErrNotStarted {
+		t.Fatalf("MPD() error = %v, want %v", err, ErrNotStarted)
+	}
+
+	writeStream(t, s, 3, func(int) bool { return true })
+	data, err := s.MPD(time.Now())
+	if err != nil {
+		t.Fatalf("MPD() error = %v", err)
+	}
+	var m mpd
+	if err := xml.Unmarshal(data, &m); err != nil {
+		t.Fatalf("invalid MPD: %v\n%s", err, data)
+	}
+	if m.Type != "dynamic" {
+		t.Errorf("MPD type = %q, want dynamic", m.Type)
+	}
+	if len(m.Period.AdaptationSets) != 2 {
+		t.Fatalf("%d adaptation sets, want 2", len(m.Period.AdaptationSets))
+	}
+	video, audio := m.Period.AdaptationSets[0].Representation, m.Period.AdaptationSets[1].Representation
+	if video.Width != 640 || video.Height != 480 || video.Codecs != "avc1.42c01e" {
+		t.Errorf("video representation %dx%d %s, want 640x480 avc1.42c01e", video.Width, video.Height, video.Codecs)
+	}
+	if audio.AudioSamplingRate != 44100 || audio.Codecs != "mp4a.40.2" || audio.AudioChannelConfiguration == nil ||
+		audio.AudioChannelConfiguration.Value != "2" {
+		t.Errorf("audio representation %d Hz %s %+v, want 44100 Hz mp4a.40.2 with 2 channels",
+			audio.AudioSamplingRate, audio.Codecs, audio.AudioChannelConfiguration)
+	}
+	// The two complete segments of the video, the third is still being written
+	timeline := video.SegmentTemplate.SegmentTimeline
+	if timeline == nil || len(timeline.S) != 1 || timeline.S[0] != (mpdS{T: 0, D: 90000, R: 1}) {
+		t.Errorf("video segment timeline %+v, want 2 segments of 90000", timeline)
+	}
+
+	s.Close()
+	data, err = s.MPD(time.Now())
+	if err != nil {
+		t.Fatalf("MPD() error = %v", err)
+	}
+	m = mpd{}
+	if err := xml.Unmarshal(data, &m); err != nil {
+		t.Fatalf("invalid MPD: %v\n%s", err, data)
+	}
+	// The ended presentation has a duration, and the players stop updating the MPD
+	if m.MediaPresentationDuration != "PT3.000S" || m.MinimumUpdatePeriod != "" {
+		t.Errorf("MPD duration %q, update period %q after Close, want PT3.000S without updates",
+			m.MediaPresentationDuration, m.MinimumUpdatePeriod)
+	}
+}
@@ -0,0 +1,175 @@
+// Package flv reads and writes FLV files, the container RTMP audio, video and data messages map to directly.
+package flv
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// Tag types, they are the same as the RTMP message type IDs
+const (
+	TagAudio  uint8 = 8
+	TagVideo  uint8 = 9
+	TagScript uint8 = 18
+)
+
+const (
+	// HeaderSize is the size of the file header including the first PreviousTagSize field
+	HeaderSize = 9 + 4
+	// TagHeaderSize is the size of the header before the data of every tag
+	TagHeaderSize = 11
+)
+
+var (
+	ErrInvalidHeader = errors.New("flv: invalid file header")
+	ErrTagTooLong    = errors.New("flv: tag data is longer than 16 MiB")
+)
+
+// Tag is a single audio, video or script data tag.
+type Tag struct {
+	Type uint8
+	// Timestamp in milliseconds
+	Timestamp uint32
+	Data      []byte
+}
+
+// TagSize returns the number of bytes a tag with data of n bytes takes in the file, including its PreviousTagSize field.
+func TagSize(n int) int {
+	return TagHeaderSize + n + 4
+}
+
+// IsKeyFrame reports whether the tag is a video key frame. AVC sequence headers are not counted as key frames.
+func (t *Tag) IsKeyFrame() bool {
+	if t.Type != TagVideo || len(t.Data) == 0 || t.Data[0]>>4 != 1 {
+		return false
+	}
+	// Codec 7 is AVC, its packet type 0 is the sequence header
+	return t.Data[0]&0x0F != 7 || len(t.Data) < 2 || t.Data[1] != 0
+}
+
+// Header returns the file header followed by the first PreviousTagSize field (which is always 0).
+func Header(hasAudio bool, hasVideo bool) []byte {
+	h := []byte{'F', 'L', 'V', 1, 0, 0, 0, 0, 9, 0, 0, 0, 0}
+	if hasAudio {
+		h[4] |= 0x04
+	}
+	if hasVideo {
+		h[4] |= 0x01
+	}
+	return h
+}
+
+// Writer writes FLV tags.
+type Writer struct {
+	w      io.Writer
+	header [TagHeaderSize]byte
+}
+
+// NewWriter creates a Writer on w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteHeader writes the file header, it should be called before the first tag.
+func (w *Writer) WriteHeader(hasAudio bool, hasVideo bool) error {
+	_, err := w.w.Write(Header(hasAudio, hasVideo))
+	return err
+}
+
+// WriteTag writes the tag and its PreviousTagSize field.
+func (w *Writer) WriteTag(t *Tag) error {
+	if len(t.Data) > 0xFFFFFF {
+		return ErrTagTooLong
+	}
+	h := w.header[:]
+	h[0] = t.Type
+	putUint24(h[1:], uint32(len(t.Data)))
+	// Lower 24 bits of the timestamp first, then the upper 8 bits
+	putUint24(h[4:], t.Timestamp)
+	h[7] = byte(t.Timestamp >> 24)
+	// Stream ID, always 0
+	h[8], h[9], h[10] = 0, 0, 0
+	if _, err := w.w.Write(h); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(t.Data); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(TagHeaderSize+len(t.Data)))
+	_, err := w.w.Write(size[:])
+	return err
+}
+
+// Reader reads FLV tags.
+type Reader struct {
+	r      io.Reader
+	header [TagHeaderSize + 4]byte
+}
+
+// NewReader creates a Reader on r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadHeader reads the file header and the first PreviousTagSize field.
+func (r *Reader) ReadHeader() (hasAudio bool, hasVideo bool, err error) {
+	var h [HeaderSize]byte
+	if _, err := io.ReadFull(r.r, h[:]); err != nil {
+		return false, false, err
+	}
+	if h[0] != 'F' || h[1] != 'L' || h[2] != 'V' {
+		return false, false, ErrInvalidHeader
+	}
+	dataOffset := binary.BigEndian.Uint32(h[5:])
+	if dataOffset < 9 {
+		return false, false, ErrInvalidHeader
+	}
+	if dataOffset > 9 {
+		// Skip the rest of a longer header, and the PreviousTagSize read as its part
+		if _, err := io.CopyN(ioutil.Discard, r.r, int64(dataOffset-9)); err != nil {
+			return false, false, err
+		}
+	}
+	return h[4]&0x04 != 0, h[4]&0x01 != 0, nil
+}
+
+// ReadTag reads the next tag and the PreviousTagSize field after it.
+// It returns io.EOF at the end of the file, and io.ErrUnexpectedEOF for a truncated tag.
+func (r *Reader) ReadTag() (*Tag, error) {
+	h := r.header[:TagHeaderSize]
+	if _, err := io.ReadFull(r.r, h); err != nil {
+		return nil, err
+	}
+	t := &Tag{
+		Type:      h[0] & 0x1F,
+		Timestamp: uint24(h[4:]) | uint32(h[7])<<24,
+		Data:      make([]byte, uint24(h[1:])),
+	}
+	if _, err := io.ReadFull(r.r, t.Data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if _, err := io.ReadFull(r.r, r.header[TagHeaderSize:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return t, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
@@ -0,0 +1,292 @@
+package flv
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// Recorder writes the tags of a stream into an FLV file. Close finalizes the file: the onMetaData at
+// the beginning of the file is updated with the duration, the file size and the key frame index,
+// so the players could seek in the recording.
+type Recorder struct {
+	path string
+	f    *os.File
+	bw   *bufio.Writer
+	w    *Writer
+
+	// offset is added to the timestamps, it is the end of the previous recording when appending
+	offset        uint32
+	lastTimestamp uint32
+	hasAudio      bool
+	hasVideo      bool
+	metadata      map[string]interface{}
+}
+
+// Create creates the file, or truncates it if it already exists.
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := newRecorder(path, f)
+	if err := r.w.WriteHeader(true, true); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Append opens the file to continue the recording in it. The timestamps of the new tags continue
+// from the last timestamp of the file. A missing file is created.
+func Append(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return Create(path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Find the last timestamp and the end of the last complete tag, an interrupted recording could end in the middle of a tag
+	hasAudio, hasVideo, lastTimestamp, end, err := scan(f)
+	if err == ErrInvalidHeader || err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Not an FLV file (or not even a complete header), start it over
+		_ = f.Close()
+		return Create(path)
+	} else if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(end); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	r := newRecorder(path, f)
+	r.hasAudio = hasAudio
+	r.hasVideo = hasVideo
+	r.lastTimestamp = lastTimestamp
+	if end > HeaderSize {
+		// Leave a small gap after the last tag of the previous recording
+		r.offset = lastTimestamp + 1
+	}
+	return r, nil
+}
+
+func newRecorder(path string, f *os.File) *Recorder {
+	bw := bufio.NewWriterSize(f, 64*1024)
+	return &Recorder{
+		path: path,
+		f:    f,
+		bw:   bw,
+		w:    NewWriter(bw),
+	}
+}
+
+// scan reads the file from the beginning. It returns the flags of the header, the last timestamp and
+// the end of the last complete tag.
+func scan(f *os.File) (hasAudio bool, hasVideo bool, lastTimestamp uint32, end int64, err error) {
+	cr := &countingReader{r: bufio.NewReader(f)}
+	r := NewReader(cr)
+	hasAudio, hasVideo, err = r.ReadHeader()
+	if err != nil {
+		return false, false, 0, 0, err
+	}
+	end = cr.n
+	for {
+		t, err := r.ReadTag()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return hasAudio, hasVideo, lastTimestamp, end, nil
+		} else if err != nil {
+			return false, false, 0, 0, err
+		}
+		if t.Timestamp > lastTimestamp {
+			lastTimestamp = t.Timestamp
+		}
+		switch t.Type {
+		case TagAudio:
+			hasAudio = true
+		case TagVideo:
+			hasVideo = true
+		}
+		end = cr.n
+	}
+}
+
+// Path returns the path of the recorded file.
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// WriteMetadata writes an onMetaData tag, the last properties are used for the final onMetaData too.
+func (r *Recorder) WriteMetadata(timestamp uint32, properties map[string]interface{}) error {
+	r.metadata = properties
+	return r.WriteTag(&Tag{Type: TagScript, Timestamp: timestamp, Data: EncodeScriptData("onMetaData", properties)})
+}
+
+// WriteTag writes an audio, video or script data tag. The timestamp is relative to the start of the recording.
+func (r *Recorder) WriteTag(t *Tag) error {
+	timestamp := t.Timestamp + r.offset
+	if timestamp > r.lastTimestamp {
+		r.lastTimestamp = timestamp
+	}
+	switch t.Type {
+	case TagAudio:
+		r.hasAudio = true
+	case TagVideo:
+		r.hasVideo = true
+	}
+	return r.w.WriteTag(&Tag{Type: t.Type, Timestamp: timestamp, Data: t.Data})
+}
+
+// Close finalizes and closes the file.
+func (r *Recorder) Close() error {
+	if err := r.bw.Flush(); err != nil {
+		_ = r.f.Close()
+		return err
+	}
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	return r.finalize()
+}
+
+type keyframe struct {
+	time float64
+	// Position of the tag in the source file, and in the finalized one
+	position int64
+}
+
+// finalize rewrites the file with a new onMetaData at its beginning. The onMetaData tags of the
+// recording are dropped, the final one has every property of the last one plus the duration,
+// the file size and the key frame index.
+func (r *Recorder) finalize() error {
+	src, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// First pass: find the key frames and the size of the tags which are kept
+	cr := &countingReader{r: bufio.NewReader(src)}
+	reader := NewReader(cr)
+	if _, _, err := reader.ReadHeader(); err != nil {
+		return err
+	}
+	var keyframes []keyframe
+	var kept int64
+	for {
+		t, err := reader.ReadTag()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if t.Type == TagScript && IsMetadata(t.Data) {
+			continue
+		}
+		if t.IsKeyFrame() {
+			keyframes = append(keyframes, keyframe{time: float64(t.Timestamp) / 1000, position: kept})
+		}
+		kept += int64(TagSize(len(t.Data)))
+	}
+
+	// Every value of the index is a number, so the size of the onMetaData is known before the positions are
+	metadataSize := int64(TagSize(len(r.finalMetadata(keyframes, 0))))
+	start := HeaderSize + metadataSize
+	for i := range keyframes {
+		keyframes[i].position += start
+	}
+	metadata := r.finalMetadata(keyframes, start+kept)
+
+	// Second pass: copy the tags into a temporary file, then replace the recording with it
+	tmpPath := r.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(tmp, 64*1024)
+	w := NewWriter(bw)
+	err = w.WriteHeader(r.hasAudio, r.hasVideo)
+	if err == nil {
+		err = w.WriteTag(&Tag{Type: TagScript, Data: metadata})
+	}
+	if err == nil {
+		err = copyTags(src, w)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, r.path)
+}
+
+// finalMetadata encodes the final onMetaData of the file.
+func (r *Recorder) finalMetadata(keyframes []keyframe, fileSize int64) []byte {
+	properties := make(map[string]interface{}, len(r.metadata)+4)
+	for k, v := range r.metadata {
+		properties[k] = v
+	}
+	times := make([]float64, len(keyframes))
+	positions := make([]float64, len(keyframes))
+	for i, kf := range keyframes {
+		times[i] = kf.time
+		positions[i] = float64(kf.position)
+	}
+	properties["duration"] = float64(r.lastTimestamp) / 1000
+	properties["filesize"] = float64(fileSize)
+	properties["lasttimestamp"] = float64(r.lastTimestamp) / 1000
+	properties["hasKeyframes"] = len(keyframes) > 0
+	properties["keyframes"] = map[string]interface{}{
+		"times":         times,
+		"filepositions": positions,
+	}
+	return EncodeScriptData("onMetaData", properties)
+}
+
+// copyTags copies every tag of the source file except the onMetaData ones.
+func copyTags(src *os.File, w *Writer) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := NewReader(bufio.NewReader(src))
+	if _, _, err := reader.ReadHeader(); err != nil {
+		return err
+	}
+	for {
+		t, err := reader.ReadTag()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if t.Type == TagScript && IsMetadata(t.Data) {
+			continue
+		}
+		if err := w.WriteTag(t); err != nil {
+			return err
+		}
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,181 @@
+package flv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func keyFrameTag(timestamp uint32) *Tag {
+	return &Tag{Type: TagVideo, Timestamp: timestamp, Data: []byte{0x17, 1, 0, 0, 0, 0xAA}}
+}
+
+func interFrameTag(timestamp uint32) *Tag {
+	return &Tag{Type: TagVideo, Timestamp: timestamp, Data: []byte{0x27, 1, 0, 0, 0, 0xBB}}
+}
+
+func audioTag(timestamp uint32) *Tag {
+	return &Tag{Type: TagAudio, Timestamp: timestamp, Data: []byte{0xAF, 1, 0xCC}}
+}
+
+func writeTags(t *testing.T, r *Recorder, tags ...*Tag) {
+	t.Helper()
+	for _, tag := range tags {
+		if err := r.WriteTag(tag); err != nil {
+			t.Fatalf("WriteTag() error = %v", err)
+		}
+	}
+}
+
+// recordedFile reads a finalized recording: its onMetaData tag, the other tags and the positions of the key frames.
+func recordedFile(t *testing.T, path string) (metadata *Tag, tags []*Tag, keyframePositions []float64) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cr := &countingReader{r: f}
+	r := NewReader(cr)
+	if _, _, err := r.ReadHeader(); err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+	for {
+		position := cr.n
+		tag, err := r.ReadTag()
+		if err != nil {
+			break
+		}
+		if tag.Type == TagScript && IsMetadata(tag.Data) {
+			if metadata != nil || len(tags) > 0 {
+				t.Errorf("onMetaData tag at %d, only the first tag should be one", position)
+			}
+			metadata = tag
+			continue
+		}
+		if tag.IsKeyFrame() {
+			keyframePositions = append(keyframePositions, float64(position))
+		}
+		tags = append(tags, tag)
+	}
+	if metadata == nil {
+		t.Fatal("no onMetaData in the recording")
+	}
+	return metadata, tags, keyframePositions
+}
+
+func timestamps(tags []*Tag) []uint32 {
+	var ts []uint32
+	for _, tag := range tags {
+		ts = append(ts, tag.Timestamp)
+	}
+	return ts
+}
+
+func TestRecorderFinalize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.flv")
+	r, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := r.WriteMetadata(0, map[string]interface{}{"width": 640.0}); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+	writeTags(t, r, keyFrameTag(0), audioTag(10), interFrameTag(40), keyFrameTag(1000))
+	if err := r.WriteMetadata(1000, map[string]interface{}{"width": 1280.0}); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+	writeTags(t, r, audioTag(1010), interFrameTag(1040))
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	metadata, tags, positions := recordedFile(t, path)
+	if got, want := timestamps(tags), []uint32{0, 10, 40, 1000, 1010, 1040}; !reflect.DeepEqual(got, want) {
+		t.Errorf("timestamps %v, want %v", got, want)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last properties, with the key frame index pointing at the key frame tags
+	want := EncodeScriptData("onMetaData", map[string]interface{}{
+		"width":         1280.0,
+		"duration":      1.04,
+		"lasttimestamp": 1.04,
+		"filesize":      float64(info.Size()),
+		"hasKeyframes":  true,
+		"keyframes": map[string]interface{}{
+			"times":         []float64{0, 1},
+			"filepositions": positions,
+		},
+	})
+	if !bytes.Equal(metadata.Data, want) {
+		t.Errorf("onMetaData = %x, want %x", metadata.Data, want)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left: %v", err)
+	}
+}
+
+func TestRecorderAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.flv")
+	r, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	writeTags(t, r, keyFrameTag(0), audioTag(500), interFrameTag(2000))
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// An interrupted recording ends in the middle of a tag, it is dropped
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := []byte{TagVideo, 0, 0, 100, 0, 0x10, 0, 0, 0, 0, 0, 0x17}
+	if _, err := f.Write(partial); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r, err = Append(path)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	// The timestamps continue after the last one of the file
+	writeTags(t, r, keyFrameTag(0), audioTag(40))
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	metadata, tags, _ := recordedFile(t, path)
+	if got, want := timestamps(tags), []uint32{0, 500, 2000, 2001, 2041}; !reflect.DeepEqual(got, want) {
+		t.Errorf("timestamps %v, want %v", got, want)
+	}
+	if !bytes.Contains(metadata.Data, append([]byte{0, 8}, "duration"...)) {
+		t.Errorf("onMetaData without duration: %x", metadata.Data)
+	}
+}
+
+func TestAppendMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.flv")
+	r, err := Append(path)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	writeTags(t, r, keyFrameTag(100))
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	_, tags, positions := recordedFile(t, path)
+	if got, want := timestamps(tags), []uint32{100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("timestamps %v, want %v", got, want)
+	}
+	if len(positions) != 1 {
+		t.Errorf("%d key frames, want 1", len(positions))
+	}
+}
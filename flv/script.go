@@ -0,0 +1,170 @@
+package flv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// AMF0 markers used by the script data tags
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0Null        = 0x05
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0A
+	amf0LongString  = 0x0C
+)
+
+// maxScriptDataDepth is the deepest nesting of objects and arrays encoded, the ones nested deeper are written
+// as null. The properties come from the publishers, a map containing itself would never end otherwise.
+const maxScriptDataDepth = 64
+
+// EncodeScriptData encodes a script data tag body: the name (like "onMetaData") and the properties as an ECMA array.
+// Supported values are numbers, bools, strings, nil, map[string]interface{} (as an object) and
+// []interface{} or []float64 (as a strict array). Properties of other types are left out, the objects and arrays
+// nested deeper than 64 levels are written as null.
+// The keys are sorted, so the same properties are always encoded the same way.
+func EncodeScriptData(name string, properties map[string]interface{}) []byte {
+	var b bytes.Buffer
+	writeString(&b, name)
+	b.WriteByte(amf0ECMAArray)
+	n := 0
+	for _, v := range properties {
+		if encodable(v) {
+			n++
+		}
+	}
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], uint32(n))
+	b.Write(count[:])
+	writeProperties(&b, properties, 1)
+	return b.Bytes()
+}
+
+// IsMetadata reports whether the script data tag body is an onMetaData.
+func IsMetadata(data []byte) bool {
+	const name = "onMetaData"
+	return len(data) >= 3+len(name) && data[0] == amf0String &&
+		binary.BigEndian.Uint16(data[1:]) == uint16(len(name)) && string(data[3:3+len(name)]) == name
+}
+
+// writeProperties writes the properties of an object or ECMA array at the depth, and the object end marker.
+func writeProperties(b *bytes.Buffer, properties map[string]interface{}, depth int) {
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := properties[k]
+		if !encodable(v) {
+			continue
+		}
+		writeKey(b, k)
+		writeValue(b, v, depth)
+	}
+	b.Write([]byte{0, 0, amf0ObjectEnd})
+}
+
+func encodable(v interface{}) bool {
+	switch v.(type) {
+	case float64, int, uint32, int64, bool, string, nil, map[string]interface{}, []interface{}, []float64:
+		return true
+	}
+	return false
+}
+
+// writeValue writes a value of an object or array at the depth.
+func writeValue(b *bytes.Buffer, v interface{}, depth int) {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}, []float64:
+		if depth >= maxScriptDataDepth {
+			b.WriteByte(amf0Null)
+			return
+		}
+	}
+	switch v := v.(type) {
+	case float64:
+		writeNumber(b, v)
+	case int:
+		writeNumber(b, float64(v))
+	case uint32:
+		writeNumber(b, float64(v))
+	case int64:
+		writeNumber(b, float64(v))
+	case bool:
+		b.WriteByte(amf0Boolean)
+		if v {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case string:
+		writeString(b, v)
+	case map[string]interface{}:
+		b.WriteByte(amf0Object)
+		writeProperties(b, v, depth+1)
+	case []interface{}:
+		writeArrayHeader(b, len(v))
+		for _, item := range v {
+			if encodable(item) {
+				writeValue(b, item, depth+1)
+			} else {
+				// The count is already written, keep the position of the items
+				b.WriteByte(amf0Null)
+			}
+		}
+	case []float64:
+		writeArrayHeader(b, len(v))
+		for _, item := range v {
+			writeNumber(b, item)
+		}
+	default:
+		b.WriteByte(amf0Null)
+	}
+}
+
+func writeNumber(b *bytes.Buffer, f float64) {
+	var n [9]byte
+	n[0] = amf0Number
+	binary.BigEndian.PutUint64(n[1:], math.Float64bits(f))
+	b.Write(n[:])
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	if len(s) > 0xFFFF {
+		var h [5]byte
+		h[0] = amf0LongString
+		binary.BigEndian.PutUint32(h[1:], uint32(len(s)))
+		b.Write(h[:])
+	} else {
+		b.WriteByte(amf0String)
+		var l [2]byte
+		binary.BigEndian.PutUint16(l[:], uint16(len(s)))
+		b.Write(l[:])
+	}
+	b.WriteString(s)
+}
+
+// writeKey writes a property name, which is a string without the type marker.
+func writeKey(b *bytes.Buffer, k string) {
+	if len(k) > 0xFFFF {
+		k = k[:0xFFFF]
+	}
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:], uint16(len(k)))
+	b.Write(l[:])
+	b.WriteString(k)
+}
+
+func writeArrayHeader(b *bytes.Buffer, n int) {
+	var h [5]byte
+	h[0] = amf0StrictArray
+	binary.BigEndian.PutUint32(h[1:], uint32(n))
+	b.Write(h[:])
+}
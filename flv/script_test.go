@@ -0,0 +1,53 @@
+package flv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeScriptData(t *testing.T) {
+	got := EncodeScriptData("onMetaData", map[string]interface{}{
+		"width":   640,
+		"stereo":  true,
+		"encoder": "x",
+		"array":   []interface{}{1.0, struct{}{}},
+		"object":  map[string]interface{}{"a": nil},
+		"func":    struct{}{},
+	})
+	want := concat(
+		[]byte{amf0String, 0, 10}, []byte("onMetaData"),
+		// 5 encodable properties, sorted
+		[]byte{amf0ECMAArray, 0, 0, 0, 5},
+		[]byte{0, 5}, []byte("array"), []byte{amf0StrictArray, 0, 0, 0, 2, amf0Number, 0x3F, 0xF0, 0, 0, 0, 0, 0, 0, amf0Null},
+		[]byte{0, 7}, []byte("encoder"), []byte{amf0String, 0, 1, 'x'},
+		[]byte{0, 6}, []byte("object"), []byte{amf0Object, 0, 1, 'a', amf0Null, 0, 0, amf0ObjectEnd},
+		[]byte{0, 6}, []byte("stereo"), []byte{amf0Boolean, 1},
+		[]byte{0, 5}, []byte("width"), []byte{amf0Number, 0x40, 0x84, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 0, amf0ObjectEnd},
+	)
+	if !bytes.Equal(got, want) {
+		t.Errorf("EncodeScriptData() = %x, want %x", got, want)
+	}
+}
+
+func TestEncodeScriptDataCyclic(t *testing.T) {
+	cyclic := map[string]interface{}{}
+	cyclic["s"] = cyclic
+	got := EncodeScriptData("onMetaData", map[string]interface{}{"s": cyclic})
+
+	// The objects down to the depth limit, then a null
+	object := []byte{0, 1, 's', amf0Object}
+	if n := bytes.Count(got, object); n != maxScriptDataDepth-1 {
+		t.Errorf("%d nested objects, want %d", n, maxScriptDataDepth-1)
+	}
+	if !bytes.Contains(got, []byte{0, 1, 's', amf0Null, 0, 0, amf0ObjectEnd}) {
+		t.Errorf("no null at the depth limit: %x", got)
+	}
+	if ends := bytes.Count(got, []byte{0, 0, amf0ObjectEnd}); ends != maxScriptDataDepth {
+		t.Errorf("%d object ends, want %d", ends, maxScriptDataDepth)
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
@@ -0,0 +1,75 @@
+package fmp4
+
+import (
+	"encoding/binary"
+)
+
+// boxWriter appends ISO BMFF boxes to a byte slice. The size of a box is filled in when its content is written.
+type boxWriter struct {
+	b []byte
+}
+
+// box appends a box of the type, the content is written by fn.
+func (w *boxWriter) box(typ string, fn func()) {
+	start := len(w.b)
+	w.b = append(w.b, 0, 0, 0, 0)
+	w.b = append(w.b, typ...)
+	fn()
+	binary.BigEndian.PutUint32(w.b[start:], uint32(len(w.b)-start))
+}
+
+// fullBox appends a box with a version and flags, the rest of the content is written by fn.
+func (w *boxWriter) fullBox(typ string, version uint8, flags uint32, fn func()) {
+	w.box(typ, func() {
+		w.u32(uint32(version)<<24 | flags&0xFFFFFF)
+		fn()
+	})
+}
+
+func (w *boxWriter) u8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *boxWriter) u16(v uint16) {
+	w.b = append(w.b, byte(v>>8), byte(v))
+}
+
+func (w *boxWriter) u32(v uint32) {
+	w.b = append(w.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *boxWriter) u64(v uint64) {
+	w.u32(uint32(v >> 32))
+	w.u32(uint32(v))
+}
+
+func (w *boxWriter) bytes(b []byte) {
+	w.b = append(w.b, b...)
+}
+
+func (w *boxWriter) zeros(n int) {
+	for i := 0; i < n; i++ {
+		w.b = append(w.b, 0)
+	}
+}
+
+// matrix appends the identity transformation matrix of the mvhd and tkhd boxes.
+func (w *boxWriter) matrix() {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		w.u32(v)
+	}
+}
+
+// descriptor appends an MPEG-4 descriptor (ISO/IEC 14496-1 7.2.2) of the tag, the content is written by fn.
+// The size is always written on 4 bytes, so it could be filled in afterwards.
+func (w *boxWriter) descriptor(tag uint8, fn func()) {
+	w.u8(tag)
+	start := len(w.b)
+	w.zeros(4)
+	fn()
+	size := len(w.b) - start - 4
+	w.b[start] = 0x80 | byte(size>>21)&0x7F
+	w.b[start+1] = 0x80 | byte(size>>14)&0x7F
+	w.b[start+2] = 0x80 | byte(size>>7)&0x7F
+	w.b[start+3] = byte(size) & 0x7F
+}
@@ -0,0 +1,222 @@
+// Package fmp4 writes fragmented MP4 (CMAF) init segments and media fragments with H.264 and AAC tracks,
+// the format of the LL-HLS parts and the DASH segments.
+package fmp4
+
+// Track handler types
+const (
+	TrackVideo = "vide"
+	TrackAudio = "soun"
+)
+
+// VideoTimeScale is the time scale of the video tracks, the 90 kHz clock of MPEG.
+// Audio tracks use their sample rate.
+const VideoTimeScale = 90000
+
+// Track describes an H.264 or AAC track of the init segment.
+type Track struct {
+	ID uint32
+	// Type is TrackVideo or TrackAudio
+	Type      string
+	TimeScale uint32
+
+	// Width and Height of the video in pixels
+	Width  int
+	Height int
+	// AVCConfig is the AVCDecoderConfigurationRecord of the video, the payload of the AVC sequence header
+	AVCConfig []byte
+
+	SampleRate int
+	Channels   int
+	// AudioConfig is the AudioSpecificConfig of the audio, the payload of the AAC sequence header
+	AudioConfig []byte
+}
+
+// InitSegment returns the init segment of the tracks: the ftyp and the moov box with the sample descriptions.
+func InitSegment(tracks []*Track) []byte {
+	w := &boxWriter{}
+	w.box("ftyp", func() {
+		w.bytes([]byte("iso6"))
+		w.u32(0)
+		w.bytes([]byte("iso6cmfcisommp41"))
+	})
+	w.box("moov", func() {
+		w.fullBox("mvhd", 0, 0, func() {
+			// Creation and modification time
+			w.zeros(8)
+			w.u32(1000)
+			// Duration is unknown
+			w.u32(0)
+			// Rate 1.0, volume 1.0
+			w.u32(0x00010000)
+			w.u16(0x0100)
+			w.zeros(10)
+			w.matrix()
+			w.zeros(24)
+			w.u32(uint32(len(tracks) + 1))
+		})
+		for _, t := range tracks {
+			writeTrack(w, t)
+		}
+		w.box("mvex", func() {
+			for _, t := range tracks {
+				w.fullBox("trex", 0, 0, func() {
+					w.u32(t.ID)
+					// Sample description index, default duration, size and flags
+					w.u32(1)
+					w.zeros(12)
+				})
+			}
+		})
+	})
+	return w.b
+}
+
+func writeTrack(w *boxWriter, t *Track) {
+	w.box("trak", func() {
+		// Enabled and in the movie
+		w.fullBox("tkhd", 0, 0x000003, func() {
+			w.zeros(8)
+			w.u32(t.ID)
+			w.zeros(4)
+			// Duration
+			w.u32(0)
+			w.zeros(8)
+			// Layer and alternate group
+			w.zeros(4)
+			if t.Type == TrackAudio {
+				w.u16(0x0100)
+			} else {
+				w.u16(0)
+			}
+			w.zeros(2)
+			w.matrix()
+			w.u32(uint32(t.Width) << 16)
+			w.u32(uint32(t.Height) << 16)
+		})
+		w.box("mdia", func() {
+			w.fullBox("mdhd", 0, 0, func() {
+				w.zeros(8)
+				w.u32(t.TimeScale)
+				w.u32(0)
+				// Language "und"
+				w.u16(0x55C4)
+				w.u16(0)
+			})
+			w.fullBox("hdlr", 0, 0, func() {
+				w.u32(0)
+				w.bytes([]byte(t.Type))
+				w.zeros(12)
+				if t.Type == TrackAudio {
+					w.bytes([]byte("SoundHandler\x00"))
+				} else {
+					w.bytes([]byte("VideoHandler\x00"))
+				}
+			})
+			w.box("minf", func() {
+				if t.Type == TrackAudio {
+					w.fullBox("smhd", 0, 0, func() {
+						// Balance and reserved
+						w.zeros(4)
+					})
+				} else {
+					w.fullBox("vmhd", 0, 1, func() {
+						// Graphics mode and opcolor
+						w.zeros(8)
+					})
+				}
+				w.box("dinf", func() {
+					w.fullBox("dref", 0, 0, func() {
+						w.u32(1)
+						// The media data is in the same file
+						w.fullBox("url ", 0, 1, func() {})
+					})
+				})
+				w.box("stbl", func() {
+					w.fullBox("stsd", 0, 0, func() {
+						w.u32(1)
+						if t.Type == TrackAudio {
+							writeMP4A(w, t)
+						} else {
+							writeAVC1(w, t)
+						}
+					})
+					// The samples are in the fragments, the sample tables are empty
+					for _, typ := range []string{"stts", "stsc", "stco"} {
+						w.fullBox(typ, 0, 0, func() {
+							w.u32(0)
+						})
+					}
+					w.fullBox("stsz", 0, 0, func() {
+						w.zeros(8)
+					})
+				})
+			})
+		})
+	})
+}
+
+// writeAVC1 writes the visual sample entry of H.264 with the avcC box.
+func writeAVC1(w *boxWriter, t *Track) {
+	w.box("avc1", func() {
+		w.zeros(6)
+		// Data reference index
+		w.u16(1)
+		w.zeros(16)
+		w.u16(uint16(t.Width))
+		w.u16(uint16(t.Height))
+		// 72 dpi
+		w.u32(0x00480000)
+		w.u32(0x00480000)
+		w.zeros(4)
+		// Frame count
+		w.u16(1)
+		// Compressor name
+		w.zeros(32)
+		// Depth and pre-defined -1
+		w.u16(0x0018)
+		w.u16(0xFFFF)
+		w.box("avcC", func() {
+			w.bytes(t.AVCConfig)
+		})
+	})
+}
+
+// writeMP4A writes the audio sample entry of AAC with the esds box.
+func writeMP4A(w *boxWriter, t *Track) {
+	w.box("mp4a", func() {
+		w.zeros(6)
+		w.u16(1)
+		w.zeros(8)
+		channels := t.Channels
+		if channels == 0 {
+			// Channels set by a program config element, the decoders take them from the AudioSpecificConfig
+			channels = 2
+		}
+		w.u16(uint16(channels))
+		// Sample size
+		w.u16(16)
+		w.zeros(4)
+		w.u32(uint32(t.SampleRate) << 16)
+		w.fullBox("esds", 0, 0, func() {
+			w.descriptor(0x03, func() {
+				// ES_ID and flags
+				w.u16(uint16(t.ID))
+				w.u8(0)
+				w.descriptor(0x04, func() {
+					// MPEG-4 audio, audio stream
+					w.u8(0x40)
+					w.u8(0x15)
+					// Buffer size, max and average bitrate
+					w.zeros(11)
+					w.descriptor(0x05, func() {
+						w.bytes(t.AudioConfig)
+					})
+				})
+				// SLConfigDescriptor, predefined for MP4
+				w.descriptor(0x06, func() {
+					w.u8(0x02)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package fmp4
+
+import (
+	"encoding/binary"
+)
+
+// Sample flags of the trun box
+const (
+	sampleFlagsSync    = 0x02000000
+	sampleFlagsNonSync = 0x01010000
+)
+
+// Sample is a single frame of a track fragment.
+type Sample struct {
+	// Duration in the time scale of the track
+	Duration uint32
+	// CompositionOffset is the difference of the presentation and decode time, in the time scale of the track
+	CompositionOffset int32
+	KeyFrame          bool
+	// Data is an H.264 access unit in AVCC format (NAL units with length prefixes) or a raw AAC frame
+	Data []byte
+}
+
+// TrackFragment is the samples of a track in a fragment.
+type TrackFragment struct {
+	TrackID uint32
+	// BaseMediaDecodeTime is the decode time of the first sample, in the time scale of the track
+	BaseMediaDecodeTime uint64
+	Samples             []Sample
+}
+
+// Fragment returns a media fragment: a moof box with a traf for every track fragment, and the mdat box
+// with the sample data. The sequence number should be incremented for every fragment of a track.
+func Fragment(sequence uint32, fragments []TrackFragment) []byte {
+	w := &boxWriter{}
+	// Positions of the data offset fields of the trun boxes, filled in when the size of the moof is known
+	var offsets []int
+	w.box("moof", func() {
+		w.fullBox("mfhd", 0, 0, func() {
+			w.u32(sequence)
+		})
+		for _, f := range fragments {
+			if len(f.Samples) == 0 {
+				continue
+			}
+			w.box("traf", func() {
+				// The data offsets are relative to the moof
+				w.fullBox("tfhd", 0, 0x020000, func() {
+					w.u32(f.TrackID)
+				})
+				w.fullBox("tfdt", 1, 0, func() {
+					w.u64(f.BaseMediaDecodeTime)
+				})
+				// Version 1 has signed composition offsets. Data offset, sample duration, size, flags
+				// and composition offset present.
+				w.fullBox("trun", 1, 0x000F01, func() {
+					w.u32(uint32(len(f.Samples)))
+					offsets = append(offsets, len(w.b))
+					w.u32(0)
+					for _, s := range f.Samples {
+						w.u32(s.Duration)
+						w.u32(uint32(len(s.Data)))
+						if s.KeyFrame {
+							w.u32(sampleFlagsSync)
+						} else {
+							w.u32(sampleFlagsNonSync)
+						}
+						w.u32(uint32(s.CompositionOffset))
+					}
+				})
+			})
+		}
+	})
+
+	// The data of the tracks follows the mdat header in the same order as the trafs
+	offset := len(w.b) + 8
+	i := 0
+	for _, f := range fragments {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		binary.BigEndian.PutUint32(w.b[offsets[i]:], uint32(offset))
+		for _, s := range f.Samples {
+			offset += len(s.Data)
+		}
+		i++
+	}
+	w.box("mdat", func() {
+		for _, f := range fragments {
+			for _, s := range f.Samples {
+				w.bytes(s.Data)
+			}
+		}
+	})
+	return w.b
+}
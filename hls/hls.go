@@ -0,0 +1,175 @@
+// Package hls remuxes the live streams of a server into MPEG-TS segments and serves them with live HLS playlists.
+package hls
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/internal/output"
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+// Defaults of Config
+const (
+	DefaultSegmentDuration = 4 * time.Second
+	DefaultPlaylistSize    = 6
+)
+
+// Config of the HLS output, the zero values are replaced with the defaults.
+type Config struct {
+	// SegmentDuration is the target duration, the segments are cut on the first key frame after it
+	SegmentDuration time.Duration
+	// PlaylistSize is the number of segments in the sliding playlist
+	PlaylistSize int
+	// Storage of the playlists and segments, MemoryStorage if nil
+	Storage Storage
+	// Logger logs the errors, the standard logger if nil
+	Logger *log.Logger
+}
+
+func (c Config) withDefaults() Config {
+	if c.SegmentDuration <= 0 {
+		c.SegmentDuration = DefaultSegmentDuration
+	}
+	if c.PlaylistSize <= 0 {
+		c.PlaylistSize = DefaultPlaylistSize
+	}
+	if c.Storage == nil {
+		c.Storage = NewMemoryStorage()
+	}
+	if c.Logger == nil {
+		c.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return c
+}
+
+// HLS segments every stream published to the registry, and serves the playlists and segments:
+// GET /{app}/{stream}.m3u8 and GET /{app}/{stream}/{sequence}.ts.
+type HLS struct {
+	config Config
+
+	mu sync.Mutex
+	// Running segmenters by app/stream
+	segmenters map[string]*Segmenter
+	// Closed when the last segmenter of the app/stream is closed, a new publish starts after it
+	closed map[string]chan struct{}
+	// Next sequence number of the streams published before, so a new publish doesn't overwrite the old segments
+	sequences map[string]int
+}
+
+// New creates the HLS output of the streams published to the registry from now on.
+func New(registry *server.Registry, config Config) *HLS {
+	h := &HLS{
+		config:     config.withDefaults(),
+		segmenters: make(map[string]*Segmenter),
+		closed:     make(map[string]chan struct{}),
+		sequences:  make(map[string]int),
+	}
+	registry.OnPublish(h.publish)
+	return h
+}
+
+// publish starts segmenting a new stream.
+func (h *HLS) publish(st *server.Stream) {
+	if !output.ValidName(st.App()) || !output.ValidName(st.Key()) {
+		return
+	}
+	name := st.App() + "/" + st.Key()
+	sub := st.Subscribe()
+
+	// The segmenter of the previous publish could still write its last segment and the ended playlist,
+	// the new one continues its sequence after it. The packets are queued meanwhile.
+	closed := make(chan struct{})
+	h.mu.Lock()
+	previous := h.closed[name]
+	h.closed[name] = closed
+	h.mu.Unlock()
+
+	go h.run(name, st, sub, previous, closed)
+}
+
+// run feeds the packets to a new segmenter until the stream ends, then removes the files after a while.
+func (h *HLS) run(name string, st *server.Stream, sub *server.Subscriber, previous, closed chan struct{}) {
+	if previous != nil {
+		<-previous
+	}
+	h.mu.Lock()
+	seg := NewSegmenter(h.config.Storage, name, h.config, st.Metadata(), h.sequences[name])
+	h.segmenters[name] = seg
+	h.mu.Unlock()
+
+	var err error
+	for p := range sub.Packets() {
+		if err != nil {
+			continue
+		}
+		if err = seg.WritePacket(p); err != nil {
+			h.config.Logger.Println("HLS error of", name, err)
+			st.Unsubscribe(sub)
+		}
+	}
+	if err := seg.Close(); err != nil {
+		h.config.Logger.Println("HLS error of", name, err)
+	}
+
+	h.mu.Lock()
+	h.sequences[name] = seg.Sequence()
+	if h.closed[name] == closed {
+		delete(h.closed, name)
+	}
+	h.mu.Unlock()
+	close(closed)
+
+	// Players could still finish the ended playlist
+	time.AfterFunc(time.Duration(h.config.PlaylistSize)*h.config.SegmentDuration, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		remove := seg.Remove
+		if h.segmenters[name] == seg {
+			delete(h.segmenters, name)
+		} else {
+			// Published again, the new segmenter owns the playlist
+			remove = seg.RemoveSegments
+		}
+		if err := remove(); err != nil {
+			h.config.Logger.Println("HLS error removing", name, err)
+		}
+	})
+}
+
+func (h *HLS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	var contentType string
+	switch path.Ext(name) {
+	case ".m3u8":
+		contentType = "application/vnd.apple.mpegurl"
+		w.Header().Set("Cache-Control", "no-cache")
+	case ".ts":
+		contentType = "video/mp2t"
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := h.config.Storage.Read(name)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
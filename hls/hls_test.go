@@ -0,0 +1,85 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+func TestServeHTTP(t *testing.T) {
+	storage := NewMemoryStorage()
+	storage.Write("live/test.m3u8", []byte("#EXTM3U\n"))
+	storage.Write("live/test/0.ts", []byte{0x47, 1, 2, 3})
+	h := New(server.NewRegistry(), Config{Storage: storage})
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		header      http.Header
+		wantStatus  int
+		wantType    string
+		wantBody    string
+		wantNoCache bool
+	}{
+		{name: "playlist", method: http.MethodGet, path: "/live/test.m3u8", wantStatus: http.StatusOK,
+			wantType: "application/vnd.apple.mpegurl", wantBody: "#EXTM3U\n", wantNoCache: true},
+		{name: "segment", method: http.MethodGet, path: "/live/test/0.ts", wantStatus: http.StatusOK,
+			wantType: "video/mp2t", wantBody: "\x47\x01\x02\x03"},
+		{name: "segment range", method: http.MethodGet, path: "/live/test/0.ts", header: http.Header{"Range": {"bytes=1-2"}},
+			wantStatus: http.StatusPartialContent, wantType: "video/mp2t", wantBody: "\x01\x02"},
+		{name: "HEAD", method: http.MethodHead, path: "/live/test.m3u8", wantStatus: http.StatusOK,
+			wantType: "application/vnd.apple.mpegurl", wantNoCache: true},
+		{name: "cleaned path", method: http.MethodGet, path: "/live/../live/test.m3u8", wantStatus: http.StatusOK,
+			wantType: "application/vnd.apple.mpegurl", wantBody: "#EXTM3U\n", wantNoCache: true},
+		{name: "missing segment", method: http.MethodGet, path: "/live/test/1.ts", wantStatus: http.StatusNotFound},
+		{name: "other extension", method: http.MethodGet, path: "/live/test/0.mp4", wantStatus: http.StatusNotFound},
+		{name: "POST", method: http.MethodPost, path: "/live/test.m3u8", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus >= 300 {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type %q, want %q", got, tt.wantType)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+				t.Errorf("Access-Control-Allow-Origin %q, want *", got)
+			}
+			if noCache := w.Header().Get("Cache-Control") == "no-cache"; noCache != tt.wantNoCache {
+				t.Errorf("Cache-Control %q", w.Header().Get("Cache-Control"))
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestPublishInvalidName(t *testing.T) {
+	registry := server.NewRegistry()
+	h := New(registry, Config{})
+	for _, key := range []string{"..", "a/b", `a\b`, "a?b", "a#b"} {
+		st, err := registry.Publish("live", key, nil)
+		if err != nil {
+			t.Fatalf("Publish(%q) error = %v", key, err)
+		}
+		h.publish(st)
+		if st.Subscribers() != 0 {
+			t.Errorf("stream key %q is segmented", key)
+		}
+	}
+}
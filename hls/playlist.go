@@ -0,0 +1,54 @@
+package hls
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// segment is a finished media segment of the playlist.
+type segment struct {
+	sequence int
+	// duration in seconds
+	duration float64
+	name     string
+	// discontinuity is set if the segment has other streams than the one before it
+	discontinuity bool
+}
+
+// renderPlaylist renders a live media playlist of the segments. The URIs are relative to the playlist.
+// discontinuitySequence is the number of discontinuities before the first segment.
+func renderPlaylist(segments []segment, targetDuration int, discontinuitySequence int, ended bool) []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", targetDuration)
+	sequence := 0
+	if len(segments) > 0 {
+		sequence = segments[0].sequence
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	if discontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuitySequence)
+	}
+	for _, s := range segments {
+		if s.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.duration, s.name)
+	}
+	if ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return []byte(b.String())
+}
+
+// targetDuration returns the EXT-X-TARGETDURATION for segments up to max seconds long.
+func targetDuration(max float64) int {
+	// Every EXTINF rounded to the nearest integer must be at most the target duration
+	d := int(math.Floor(max + 0.5))
+	if d < 1 {
+		d = 1
+	}
+	return d
+}
@@ -0,0 +1,273 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gerifield/mini-stream-test/codec"
+	"github.com/gerifield/mini-stream-test/internal/output"
+	"github.com/gerifield/mini-stream-test/mpegts"
+	"github.com/gerifield/mini-stream-test/server"
+	"github.com/torresjeff/rtmp/audio"
+	"github.com/torresjeff/rtmp/video"
+)
+
+// Segmenter remuxes the H.264 and AAC packets of a stream into MPEG-TS segments and keeps a sliding playlist
+// of them. Segments are cut on the video key frames (or on any audio frame without video) once they reach
+// the target duration.
+type Segmenter struct {
+	storage Storage
+	// name is the path of the playlist without the extension, like "app/stream"
+	name            string
+	segmentDuration uint64
+	playlistSize    int
+
+	avc *codec.AVCDecoderConfigurationRecord
+	asc *codec.AudioSpecificConfig
+	// Streams of the segments, decided when the first segment starts. The audio is added later
+	// if its sequence header arrives after that.
+	hasVideo bool
+	hasAudio bool
+	// first decides whether the first segment starts without video
+	first output.FirstSegment
+
+	buf   bytes.Buffer
+	muxer *mpegts.Muxer
+	// started is set once the first segment is started, segmentStart is its first timestamp in milliseconds
+	started      bool
+	segmentStart uint64
+	sequence     int
+	// discontinuity is set if the current segment starts a discontinuity, discontinuitySequence counts
+	// the ones removed from the playlist
+	discontinuity         bool
+	discontinuitySequence int
+	// segments of the playlist, and the older ones not removed yet
+	segments      []segment
+	old           []segment
+	maxDuration   float64
+	lastTimestamp uint64
+	annexB        []byte
+	adts          []byte
+}
+
+// NewSegmenter creates a Segmenter storing the playlist as name+".m3u8" and the segments as name+"/<sequence>.ts".
+// The first segment has the given sequence number. The metadata (which could be nil) tells whether
+// the stream has audio and video, without it both are expected.
+func NewSegmenter(storage Storage, name string, config Config, metadata *server.StreamMetadata, sequence int) *Segmenter {
+	config = config.withDefaults()
+	return &Segmenter{
+		storage:         storage,
+		name:            name,
+		segmentDuration: uint64(config.SegmentDuration.Milliseconds()),
+		playlistSize:    config.PlaylistSize,
+		sequence:        sequence,
+		first:           output.NewFirstSegment(metadata, uint64(config.SegmentDuration.Milliseconds())),
+	}
+}
+
+// Sequence returns the sequence number of the next segment.
+func (s *Segmenter) Sequence() int {
+	return s.sequence
+}
+
+// WritePacket remuxes an audio or video packet, the other packets are ignored.
+func (s *Segmenter) WritePacket(p *server.Packet) error {
+	switch p.Type {
+	case server.TypeVideo:
+		return s.writeVideo(p)
+	case server.TypeAudio:
+		return s.writeAudio(p)
+	}
+	return nil
+}
+
+func (s *Segmenter) writeVideo(p *server.Packet) error {
+	// Frame type and codec, AVC packet type, then the 24 bit composition time
+	if len(p.Payload) < 5 || video.Codec(p.Payload[0]&0x0F) != video.H264 {
+		return nil
+	}
+	switch video.AVCPacketType(p.Payload[1]) {
+	case video.AVCSequenceHeader:
+		avc, err := codec.ParseAVCDecoderConfigurationRecord(p.Payload[5:])
+		if err != nil {
+			return err
+		}
+		s.avc = avc
+		return nil
+	case video.AVCNALU:
+	default:
+		return nil
+	}
+	if s.avc == nil {
+		return nil
+	}
+
+	keyFrame := p.IsKeyFrame()
+	if keyFrame {
+		if err := s.cut(p.Timestamp, true); err != nil {
+			return err
+		}
+	}
+	if !s.started || !s.hasVideo {
+		return nil
+	}
+
+	frame, err := p.VideoFrame(s.avc)
+	if frame == nil {
+		return err
+	}
+	dts := p.Timestamp * 90
+	pts := uint64(int64(dts) + int64(frame.CompositionTime)*90)
+	// A broken NAL unit length drops the rest of the frame only, the NAL units before it are still written
+	s.annexB = frame.AppendAnnexB(s.annexB[:0], s.avc)
+	s.lastTimestamp = p.Timestamp
+	return s.muxer.WriteVideo(s.annexB, pts, dts, keyFrame)
+}
+
+func (s *Segmenter) writeAudio(p *server.Packet) error {
+	if len(p.Payload) < 2 || audio.Format(p.Payload[0]>>4) != audio.AAC {
+		return nil
+	}
+	if audio.AACPacketType(p.Payload[1]) == audio.AACSequenceHeader {
+		asc, err := codec.ParseAudioSpecificConfig(p.Payload[2:])
+		if err != nil {
+			return err
+		}
+		s.asc = asc
+		return nil
+	}
+	if s.asc == nil {
+		return nil
+	}
+
+	if (!s.started && s.first.StartsWithAudio(p.Timestamp, s.avc != nil)) || (s.started && !s.hasVideo) {
+		if err := s.cut(p.Timestamp, false); err != nil {
+			return err
+		}
+	}
+	if !s.started || !s.hasAudio {
+		return nil
+	}
+
+	raw := p.Payload[2:]
+	s.adts = append(append(s.adts[:0], s.asc.ADTSHeader(len(raw))...), raw...)
+	s.lastTimestamp = p.Timestamp
+	return s.muxer.WriteAudio(s.adts, p.Timestamp*90)
+}
+
+// cut starts a new segment at a key frame (or at an audio frame without video) if the current one is long enough.
+func (s *Segmenter) cut(timestamp uint64, keyFrame bool) error {
+	if !s.started {
+		s.hasVideo = keyFrame
+		s.hasAudio = s.asc != nil
+		s.start(timestamp)
+		return nil
+	}
+	if timestamp < s.segmentStart+s.segmentDuration {
+		return nil
+	}
+	if err := s.finishSegment(timestamp); err != nil {
+		return err
+	}
+	// Audio starting after the first key frame is added from the next segment on. The tables change,
+	// so the players have to reset their decoders at a discontinuity.
+	s.discontinuity = !s.hasAudio && s.asc != nil
+	if s.discontinuity {
+		s.hasAudio = true
+	}
+	s.start(timestamp)
+	return nil
+}
+
+// start starts a new segment with the tables.
+func (s *Segmenter) start(timestamp uint64) {
+	s.started = true
+	s.segmentStart = timestamp
+	s.buf.Reset()
+	s.muxer = mpegts.NewMuxer(&s.buf, s.hasVideo, s.hasAudio)
+	// The tables can't fail writing into a buffer
+	_ = s.muxer.WriteTables()
+}
+
+// finishSegment stores the current segment, which lasts until end, and updates the playlist.
+func (s *Segmenter) finishSegment(end uint64) error {
+	seg := segment{
+		sequence: s.sequence,
+		duration: float64(end-s.segmentStart) / 1000,
+		name:     s.segmentName(s.sequence),
+		// The tables of the segment differ from the ones before
+		discontinuity: s.discontinuity,
+	}
+	// The buffer is reused for the next segment, the storage gets a copy
+	data := append([]byte(nil), s.buf.Bytes()...)
+	if err := s.storage.Write(s.name+"/"+strconv.Itoa(seg.sequence)+".ts", data); err != nil {
+		return err
+	}
+	s.sequence++
+	if seg.duration > s.maxDuration {
+		s.maxDuration = seg.duration
+	}
+
+	s.segments = append(s.segments, seg)
+	if len(s.segments) > s.playlistSize {
+		if s.segments[0].discontinuity {
+			s.discontinuitySequence++
+		}
+		s.old = append(s.old, s.segments[0])
+		s.segments = s.segments[1:]
+	}
+	// Segments just removed from the playlist could still be downloaded by the slower players
+	for len(s.old) > 2 {
+		if err := s.storage.Remove(s.name + "/" + strconv.Itoa(s.old[0].sequence) + ".ts"); err != nil {
+			return err
+		}
+		s.old = s.old[1:]
+	}
+	return s.writePlaylist(false)
+}
+
+// segmentName is the URI of a segment relative to the playlist.
+func (s *Segmenter) segmentName(sequence int) string {
+	base := s.name
+	if i := strings.LastIndexByte(base, '/'); i >= 0 {
+		base = base[i+1:]
+	}
+	return fmt.Sprintf("%s/%d.ts", base, sequence)
+}
+
+func (s *Segmenter) writePlaylist(ended bool) error {
+	return s.storage.Write(s.name+".m3u8", renderPlaylist(s.segments, targetDuration(s.maxDuration), s.discontinuitySequence, ended))
+}
+
+// Close finishes the last segment and ends the playlist.
+func (s *Segmenter) Close() error {
+	if !s.started {
+		return nil
+	}
+	if s.buf.Len() > 0 && s.lastTimestamp > s.segmentStart {
+		if err := s.finishSegment(s.lastTimestamp); err != nil {
+			return err
+		}
+	}
+	return s.writePlaylist(true)
+}
+
+// Remove deletes the playlist and the segments still in the storage.
+func (s *Segmenter) Remove() error {
+	if err := s.RemoveSegments(); err != nil {
+		return err
+	}
+	return s.storage.Remove(s.name + ".m3u8")
+}
+
+// RemoveSegments deletes the segments still in the storage, but keeps the playlist.
+func (s *Segmenter) RemoveSegments() error {
+	for _, seg := range append(s.old, s.segments...) {
+		if err := s.storage.Remove(s.name + "/" + strconv.Itoa(seg.sequence) + ".ts"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
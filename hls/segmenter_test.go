@@ -0,0 +1,89 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/internal/streamtest"
+	"github.com/gerifield/mini-stream-test/mpegts"
+)
+
+// writeVideo writes 25 fps video with a key frame every second from start to end in milliseconds,
+// with an audio frame after every video frame from audioStart.
+func writeVideo(t *testing.T, s *Segmenter, start, end, audioStart uint64) {
+	t.Helper()
+	for ts := start; ts < end; ts += 40 {
+		if err := s.WritePacket(streamtest.VideoFrame(ts, ts%1000 == 0)); err != nil {
+			t.Fatalf("WritePacket() error = %v", err)
+		}
+		if ts >= audioStart {
+			if err := s.WritePacket(streamtest.AACFrame(ts)); err != nil {
+				t.Fatalf("WritePacket() error = %v", err)
+			}
+		}
+	}
+}
+
+// hasPID reports whether the transport stream has any packet of the PID.
+func hasPID(ts []byte, pid uint16) bool {
+	for ; len(ts) >= mpegts.PacketSize; ts = ts[mpegts.PacketSize:] {
+		if uint16(ts[1]&0x1F)<<8|uint16(ts[2]) == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSegmenterLateAudio(t *testing.T) {
+	storage := NewMemoryStorage()
+	s := NewSegmenter(storage, "live/test", Config{SegmentDuration: time.Second, PlaylistSize: 3}, nil, 0)
+	if err := s.WritePacket(streamtest.AVCSequenceHeader()); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	// The AAC sequence header arrives in the middle of the second segment
+	writeVideo(t, s, 0, 1520, 1<<63)
+	if err := s.WritePacket(streamtest.AACSequenceHeader(1520)); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	writeVideo(t, s, 1520, 4000, 1520)
+
+	for _, tt := range []struct {
+		name     string
+		hasAudio bool
+	}{
+		{"live/test/0.ts", false},
+		{"live/test/1.ts", false},
+		{"live/test/2.ts", true},
+	} {
+		data, err := storage.Read(tt.name)
+		if err != nil {
+			t.Fatalf("Read(%q) error = %v", tt.name, err)
+		}
+		if !hasPID(data, mpegts.PIDVideo) {
+			t.Errorf("%s has no video", tt.name)
+		}
+		if got := hasPID(data, mpegts.PIDAudio); got != tt.hasAudio {
+			t.Errorf("%s has audio %v, want %v", tt.name, got, tt.hasAudio)
+		}
+	}
+
+	playlist, _ := storage.Read("live/test.m3u8")
+	if !strings.Contains(string(playlist), "test/1.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:1.000,\ntest/2.ts\n") {
+		t.Errorf("no discontinuity before the first segment with audio:\n%s", playlist)
+	}
+	if strings.Contains(string(playlist), "#EXT-X-DISCONTINUITY-SEQUENCE") {
+		t.Errorf("discontinuity sequence before any discontinuity left the playlist:\n%s", playlist)
+	}
+
+	// The segment of the discontinuity leaves the playlist
+	writeVideo(t, s, 4000, 6000, 4000)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	playlist, _ = storage.Read("live/test.m3u8")
+	if !strings.Contains(string(playlist), "#EXT-X-MEDIA-SEQUENCE:3\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n") ||
+		strings.Contains(string(playlist), "#EXT-X-DISCONTINUITY\n") {
+		t.Errorf("playlist after the discontinuity:\n%s", playlist)
+	}
+}
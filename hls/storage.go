@@ -0,0 +1,95 @@
+package hls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage keeps the playlists and the segments. The names are slash separated paths like "app/stream.m3u8",
+// the same as the paths of the HTTP requests. Read returns an error satisfying os.IsNotExist for missing names.
+type Storage interface {
+	Write(name string, data []byte) error
+	Read(name string) ([]byte, error)
+	Remove(name string) error
+}
+
+// MemoryStorage keeps everything in memory.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// NewMemoryStorage creates an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string][]byte)}
+}
+
+// Write stores the data, the slice must not be modified after that.
+func (m *MemoryStorage) Write(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = data
+	return nil
+}
+
+// Read returns the stored data, it must not be modified.
+func (m *MemoryStorage) Read(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+// Remove deletes the data.
+func (m *MemoryStorage) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+// DiskStorage keeps the files in a directory, so they could be served by any web server too.
+type DiskStorage struct {
+	dir string
+}
+
+// NewDiskStorage creates a DiskStorage in dir, the directory is created when the first file is written.
+func NewDiskStorage(dir string) *DiskStorage {
+	return &DiskStorage{dir: dir}
+}
+
+func (d *DiskStorage) path(name string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(name))
+}
+
+// Write writes the file through a temporary file, so the readers never see a partially written playlist.
+func (d *DiskStorage) Write(name string, data []byte) error {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read reads the file.
+func (d *DiskStorage) Read(name string) ([]byte, error) {
+	return ioutil.ReadFile(d.path(name))
+}
+
+// Remove deletes the file, a missing file is not an error.
+func (d *DiskStorage) Remove(name string) error {
+	err := os.Remove(d.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
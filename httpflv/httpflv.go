@@ -0,0 +1,218 @@
+// Package httpflv serves the live streams of a server as HTTP-FLV, the way flv.js and many mobile players consume them.
+package httpflv
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/flv"
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+// Handler serves GET /{app}/{stream}.flv requests with the live stream published as stream in app.
+// The response is an endless FLV file: the header, the metadata, the sequence headers and the cached GOP,
+// followed by the live tags until the publisher stops or the viewer disconnects.
+type Handler struct {
+	registry *server.Registry
+	config   Config
+
+	mu sync.Mutex
+	// Number of viewers by app and stream key
+	viewers map[string]int
+}
+
+// DefaultWriteTimeout is the suggested Config.WriteTimeout.
+const DefaultWriteTimeout = 10 * time.Second
+
+// Config of the Handler.
+type Config struct {
+	// WriteTimeout is how long a write to a viewer could block, a viewer not reading is disconnected after it.
+	// It needs the connection in the request context, see ConnContext. 0 disables it.
+	WriteTimeout time.Duration
+	// Logger logs the viewers and the errors, the standard logger if nil
+	Logger *log.Logger
+}
+
+type connContextKey struct{}
+
+// ConnContext stores the connection in the context of its requests, it should be set as the ConnContext
+// of the http.Server serving the Handler. Without it the writes to the viewers have no deadline.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// deadlineWriter sets the write deadline of the connection before every write and flush of the response.
+type deadlineWriter struct {
+	http.ResponseWriter
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	_ = w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.ResponseWriter.Write(p)
+}
+
+func (w deadlineWriter) Flush() {
+	_ = w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// New creates a Handler serving the streams of the registry.
+func New(registry *server.Registry, config Config) *Handler {
+	if config.Logger == nil {
+		config.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &Handler{
+		registry: registry,
+		config:   config,
+		viewers:  make(map[string]int),
+	}
+}
+
+// Viewers returns the number of viewers of a stream.
+func (h *Handler) Viewers(app string, key string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.viewers[app+"/"+key]
+}
+
+// TotalViewers returns the number of viewers of every stream.
+func (h *Handler) TotalViewers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	total := 0
+	for _, n := range h.viewers {
+		total += n
+	}
+	return total
+}
+
+func (h *Handler) addViewer(id string, delta int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.viewers[id] += delta
+	n := h.viewers[id]
+	if n <= 0 {
+		delete(h.viewers, id)
+	}
+	return n
+}
+
+// ParsePath splits a path like /app/stream.flv into the app and the stream key.
+func ParsePath(path string, extension string) (app string, key string, ok bool) {
+	path = strings.TrimPrefix(path, "/")
+	if !strings.HasSuffix(path, extension) {
+		return "", "", false
+	}
+	path = strings.TrimSuffix(path, extension)
+	i := strings.LastIndexByte(path, '/')
+	if i <= 0 || i == len(path)-1 {
+		return "", "", false
+	}
+	return path[:i], path[i+1:], true
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	app, key, ok := ParsePath(r.URL.Path, ".flv")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	st := h.registry.Get(app, key)
+	if st == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/x-flv")
+	w.Header().Set("Cache-Control", "no-cache")
+	// flv.js loads the stream with fetch or XHR from the page's origin
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	id := app + "/" + key
+	h.config.Logger.Println("HTTP-FLV viewer", r.RemoteAddr, "joined", id, "viewers:", h.addViewer(id, 1))
+	defer func() {
+		h.config.Logger.Println("HTTP-FLV viewer", r.RemoteAddr, "left", id, "viewers:", h.addViewer(id, -1))
+	}()
+
+	var out io.Writer = w
+	if conn, ok := r.Context().Value(connContextKey{}).(net.Conn); ok && h.config.WriteTimeout > 0 {
+		out = deadlineWriter{ResponseWriter: w, conn: conn, timeout: h.config.WriteTimeout}
+		// The connection could serve more requests after this one
+		defer func() { _ = conn.SetWriteDeadline(time.Time{}) }()
+	}
+
+	sub := st.Subscribe()
+	defer st.Unsubscribe(sub)
+	if err := Stream(out, sub, st.CodecInfo(), r.Context().Done()); err != nil {
+		h.config.Logger.Println("HTTP-FLV viewer", r.RemoteAddr, "of", id, "error:", err)
+	}
+}
+
+// Stream writes the packets of the subscriber as an FLV file until the queue is closed or done is closed.
+// If w is an http.Flusher, it is flushed whenever the queue is empty.
+func Stream(w io.Writer, sub *server.Subscriber, codecs server.CodecInfo, done <-chan struct{}) error {
+	bw := bufio.NewWriterSize(w, 32*1024)
+	fw := flv.NewWriter(bw)
+	flusher, _ := w.(http.Flusher)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	// Without any packet so far, the stream could have both
+	hasAudio := codecs.HasAudio || !codecs.HasVideo
+	hasVideo := codecs.HasVideo || !codecs.HasAudio
+	if err := fw.WriteHeader(hasAudio, hasVideo); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	var timestamps server.TimestampRebaser
+	for {
+		select {
+		case <-done:
+			return nil
+		case p, ok := <-sub.Packets():
+			if !ok {
+				if sub.Err() == server.ErrStreamUnpublished {
+					return flush()
+				}
+				return sub.Err()
+			}
+			if err := fw.WriteTag(&flv.Tag{Type: p.Type, Timestamp: timestamps.Rebase(p), Data: p.Payload}); err != nil {
+				return err
+			}
+			if len(sub.Packets()) == 0 {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
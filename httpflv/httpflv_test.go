@@ -0,0 +1,159 @@
+package httpflv
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/flv"
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantApp string
+		wantKey string
+		wantOK  bool
+	}{
+		{"/live/test.flv", "live", "test", true},
+		{"live/test.flv", "live", "test", true},
+		{"/a/b/test.flv", "a/b", "test", true},
+		{"/live/test.mp4", "", "", false},
+		{"/test.flv", "", "", false},
+		{"/live/.flv", "", "", false},
+		{"//test.flv", "", "", false},
+	}
+	for _, tt := range tests {
+		app, key, ok := ParsePath(tt.path, ".flv")
+		if app != tt.wantApp || key != tt.wantKey || ok != tt.wantOK {
+			t.Errorf("ParsePath(%q) = %q, %q, %v, want %q, %q, %v", tt.path, app, key, ok, tt.wantApp, tt.wantKey, tt.wantOK)
+		}
+	}
+}
+
+func newTestHandler(t *testing.T) (*Handler, *server.Registry) {
+	registry := server.NewRegistry()
+	if _, err := registry.Publish("live", "test", nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	return New(registry, Config{WriteTimeout: time.Second, Logger: log.New(ioutil.Discard, "", 0)}), registry
+}
+
+func TestServeHTTPErrors(t *testing.T) {
+	h, _ := newTestHandler(t)
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"missing stream", http.MethodGet, "/live/missing.flv", http.StatusNotFound},
+		{"other extension", http.MethodGet, "/live/test.mp4", http.StatusNotFound},
+		{"POST", http.MethodPost, "/live/test.flv", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServeHTTPHead(t *testing.T) {
+	h, _ := newTestHandler(t)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/live/test.flv", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":                "video/x-flv",
+		"Cache-Control":               "no-cache",
+		"Access-Control-Allow-Origin": "*",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s %q, want %q", header, got, want)
+		}
+	}
+	if w.Body.Len() != 0 || h.TotalViewers() != 0 {
+		t.Errorf("HEAD body of %d bytes, %d viewers", w.Body.Len(), h.TotalViewers())
+	}
+}
+
+func TestServeHTTPViewer(t *testing.T) {
+	h, registry := newTestHandler(t)
+	ts := httptest.NewUnstartedServer(h)
+	ts.Config.ConnContext = ConnContext
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/live/test.flv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is published yet, the header announces both streams
+	header := make([]byte, len(flv.Header(true, true)))
+	if _, err := io.ReadFull(resp.Body, header); err != nil {
+		t.Fatalf("reading the FLV header: %v", err)
+	}
+	if !bytes.Equal(header, flv.Header(true, true)) {
+		t.Errorf("FLV header % X, want % X", header, flv.Header(true, true))
+	}
+	if n := h.Viewers("live", "test"); n != 1 {
+		t.Errorf("Viewers() = %d, want 1", n)
+	}
+	if n := registry.Get("live", "test").Subscribers(); n != 1 {
+		t.Errorf("%d subscribers, want 1", n)
+	}
+
+	// The viewer leaves
+	resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for h.TotalViewers() != 0 || registry.Get("live", "test").Subscribers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d viewers after the viewer left", h.TotalViewers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		codecs server.CodecInfo
+		want   []byte
+	}{
+		{"unknown streams", server.CodecInfo{}, flv.Header(true, true)},
+		{"video only", server.CodecInfo{HasVideo: true}, flv.Header(false, true)},
+		{"audio only", server.CodecInfo{HasAudio: true}, flv.Header(true, false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := server.NewRegistry()
+			st, err := registry.Publish("live", "test", nil)
+			if err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			sub := st.Subscribe()
+			// The queue is closed, Stream returns after the header
+			st.Unsubscribe(sub)
+
+			var b bytes.Buffer
+			if err := Stream(&b, sub, tt.codecs, nil); err != nil {
+				t.Fatalf("Stream() error = %v", err)
+			}
+			if !bytes.Equal(b.Bytes(), tt.want) {
+				t.Errorf("Stream() wrote % X, want % X", b.Bytes(), tt.want)
+			}
+		})
+	}
+}
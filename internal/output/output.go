@@ -0,0 +1,47 @@
+// Package output has the pieces shared by the HLS, LL-HLS and DASH outputs.
+package output
+
+import (
+	"strings"
+
+	"github.com/gerifield/mini-stream-test/server"
+	"github.com/torresjeff/rtmp/video"
+)
+
+// ValidName reports whether the app or stream key could be used as a path element.
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\?#`)
+}
+
+// FirstSegment decides whether the first segment of a stream could start without video. It waits for
+// the first video key frame, unless the metadata says there is no video, or the audio goes on without
+// a video sequence header for a segment.
+type FirstSegment struct {
+	segmentDuration uint64
+	expectVideo     bool
+	firstAudio      *uint64
+}
+
+// NewFirstSegment creates a FirstSegment for segments of segmentDuration milliseconds. The metadata could be nil,
+// without it video is expected.
+func NewFirstSegment(metadata *server.StreamMetadata, segmentDuration uint64) FirstSegment {
+	f := FirstSegment{segmentDuration: segmentDuration, expectVideo: true}
+	if metadata != nil && (metadata.VideoCodecID != 0 || metadata.AudioCodecID != 0) {
+		f.expectVideo = metadata.VideoCodecID == video.H264
+	}
+	return f
+}
+
+// StartsWithAudio is called with the audio frames before the first segment, it reports whether the segment
+// could start with the frame. The timestamp is in milliseconds, hasVideo tells whether the video sequence
+// header arrived already.
+func (f *FirstSegment) StartsWithAudio(timestamp uint64, hasVideo bool) bool {
+	if f.expectVideo && !hasVideo {
+		if f.firstAudio == nil {
+			f.firstAudio = &timestamp
+		} else if timestamp >= *f.firstAudio+f.segmentDuration {
+			f.expectVideo = false
+		}
+	}
+	return !f.expectVideo
+}
@@ -0,0 +1,51 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/gerifield/mini-stream-test/server"
+	"github.com/torresjeff/rtmp/audio"
+	"github.com/torresjeff/rtmp/video"
+)
+
+func TestValidName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"test": true, "a.b": true, "": false, ".": false, "..": false,
+		"a/b": false, `a\b`: false, "a?b": false, "a#b": false,
+	} {
+		if got := ValidName(name); got != want {
+			t.Errorf("ValidName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestFirstSegment(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata *server.StreamMetadata
+		hasVideo bool
+		// The first audio timestamp starting the segment, 0 if none of them does
+		want uint64
+	}{
+		{name: "no metadata", want: 1000},
+		{name: "audio only metadata", metadata: &server.StreamMetadata{AudioCodecID: audio.AAC}, want: 0},
+		{name: "video metadata", metadata: &server.StreamMetadata{VideoCodecID: video.H264, AudioCodecID: audio.AAC}, want: 1000},
+		{name: "empty metadata", metadata: &server.StreamMetadata{}, want: 1000},
+		{name: "video sequence header", hasVideo: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFirstSegment(tt.metadata, 1000)
+			var got uint64
+			for ts := uint64(0); ts < 2000; ts += 20 {
+				if f.StartsWithAudio(ts, tt.hasVideo) {
+					got = ts
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("first segment starts with the audio at %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
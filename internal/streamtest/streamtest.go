@@ -0,0 +1,42 @@
+// Package streamtest has the packets of a small H.264 and AAC stream for the tests of the outputs.
+package streamtest
+
+import "github.com/gerifield/mini-stream-test/server"
+
+var (
+	// Baseline 640x480 SPS and its PPS
+	SPS = []byte{0x67, 0x42, 0xC0, 0x1E, 0xDA, 0x02, 0x80, 0xF6, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xC8, 0x3C, 0x58, 0xBA, 0x80}
+	PPS = []byte{0x68, 0xCE, 0x3C, 0x80}
+	// AAC LC, 44100 Hz, stereo
+	ASC = []byte{0x12, 0x10}
+)
+
+// AVCSequenceHeader returns the video sequence header with SPS and PPS.
+func AVCSequenceHeader() *server.Packet {
+	payload := []byte{0x17, 0, 0, 0, 0, 1, SPS[1], SPS[2], SPS[3], 0xFF, 0xE1, 0, byte(len(SPS))}
+	payload = append(payload, SPS...)
+	payload = append(payload, 1, 0, byte(len(PPS)))
+	payload = append(payload, PPS...)
+	return &server.Packet{Type: server.TypeVideo, Payload: payload}
+}
+
+// VideoFrame returns a video frame with a single IDR or non-IDR slice.
+func VideoFrame(timestamp uint64, keyFrame bool) *server.Packet {
+	frameType := byte(0x27)
+	nalu := []byte{0x41, 0x9A}
+	if keyFrame {
+		frameType = 0x17
+		nalu = []byte{0x65, 0x88}
+	}
+	return &server.Packet{Type: server.TypeVideo, Timestamp: timestamp, Payload: append([]byte{frameType, 1, 0, 0, 0, 0, 0, 0, byte(len(nalu))}, nalu...)}
+}
+
+// AACSequenceHeader returns the audio sequence header with ASC.
+func AACSequenceHeader(timestamp uint64) *server.Packet {
+	return &server.Packet{Type: server.TypeAudio, Timestamp: timestamp, Payload: append([]byte{0xAF, 0}, ASC...)}
+}
+
+// AACFrame returns an AAC frame.
+func AACFrame(timestamp uint64) *server.Packet {
+	return &server.Packet{Type: server.TypeAudio, Timestamp: timestamp, Payload: []byte{0xAF, 1, 0x21, 0x00}}
+}
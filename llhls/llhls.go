@@ -0,0 +1,254 @@
+// Package llhls remuxes the live streams of a server into fMP4 (CMAF) parts and segments, and serves them
+// with Low-Latency HLS playlists supporting blocking reloads and delta updates.
+package llhls
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/internal/output"
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+// Defaults of Config
+const (
+	DefaultSegmentDuration = 2 * time.Second
+	DefaultPartDuration    = 500 * time.Millisecond
+	DefaultPlaylistSize    = 10
+)
+
+// Config of the LL-HLS output, the zero values are replaced with the defaults.
+type Config struct {
+	// SegmentDuration is the target duration, the segments are cut on the first key frame after it
+	SegmentDuration time.Duration
+	// PartDuration is the part target duration, the parts are never longer than this
+	PartDuration time.Duration
+	// PlaylistSize is the number of complete segments in the playlist
+	PlaylistSize int
+	// Logger logs the errors, the standard logger if nil
+	Logger *log.Logger
+}
+
+func (c Config) withDefaults() Config {
+	if c.SegmentDuration <= 0 {
+		c.SegmentDuration = DefaultSegmentDuration
+	}
+	if c.PartDuration <= 0 {
+		c.PartDuration = DefaultPartDuration
+	}
+	if c.PlaylistSize <= 0 {
+		c.PlaylistSize = DefaultPlaylistSize
+	}
+	if c.Logger == nil {
+		c.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return c
+}
+
+// LLHLS segments every stream published to the registry and serves them:
+//
+//	GET /{app}/{stream}/index.m3u8 (with the _HLS_msn, _HLS_part and _HLS_skip parameters)
+//	GET /{app}/{stream}/init.mp4
+//	GET /{app}/{stream}/seg{sequence}.m4s
+//	GET /{app}/{stream}/part{sequence}.{index}.m4s
+type LLHLS struct {
+	config Config
+
+	mu sync.Mutex
+	// Segmenters by app/stream, kept for a while after the stream ends
+	segmenters map[string]*Segmenter
+	// Closed when the last segmenter of the app/stream is closed, a new publish starts after it
+	closed map[string]chan struct{}
+	// Next sequence number and discontinuity sequence number of the streams published before, the players
+	// could continue with the new publish after a discontinuity
+	sequences       map[string]int
+	discontinuities map[string]int
+}
+
+// New creates the LL-HLS output of the streams published to the registry from now on.
+func New(registry *server.Registry, config Config) *LLHLS {
+	l := &LLHLS{
+		config:          config.withDefaults(),
+		segmenters:      make(map[string]*Segmenter),
+		closed:          make(map[string]chan struct{}),
+		sequences:       make(map[string]int),
+		discontinuities: make(map[string]int),
+	}
+	registry.OnPublish(l.publish)
+	return l
+}
+
+// publish starts segmenting a new stream.
+func (l *LLHLS) publish(st *server.Stream) {
+	if !output.ValidName(st.App()) || !output.ValidName(st.Key()) {
+		return
+	}
+	name := st.App() + "/" + st.Key()
+	sub := st.Subscribe()
+
+	// The segmenter of the previous publish could still write its last segment, the new one continues
+	// its sequence after it. The packets are queued meanwhile.
+	closed := make(chan struct{})
+	l.mu.Lock()
+	previous := l.closed[name]
+	l.closed[name] = closed
+	l.mu.Unlock()
+
+	go l.run(name, st, sub, previous, closed)
+}
+
+// run feeds the packets to a new segmenter until the stream ends, then forgets it after a while.
+func (l *LLHLS) run(name string, st *server.Stream, sub *server.Subscriber, previous, closed chan struct{}) {
+	if previous != nil {
+		<-previous
+	}
+	l.mu.Lock()
+	seg := NewSegmenter(l.config, st.Metadata(), l.sequences[name], l.discontinuities[name])
+	l.segmenters[name] = seg
+	l.mu.Unlock()
+
+	var err error
+	for p := range sub.Packets() {
+		if err != nil {
+			continue
+		}
+		if err = seg.WritePacket(p); err != nil {
+			l.config.Logger.Println("LL-HLS error of", name, err)
+			st.Unsubscribe(sub)
+		}
+	}
+	seg.Close()
+
+	l.mu.Lock()
+	l.sequences[name] = seg.Sequence()
+	l.discontinuities[name] = seg.DiscontinuitySequence() + 1
+	if l.closed[name] == closed {
+		delete(l.closed, name)
+	}
+	l.mu.Unlock()
+	close(closed)
+
+	// Players could still finish the ended playlist
+	time.AfterFunc(time.Duration(l.config.PlaylistSize)*l.config.SegmentDuration, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.segmenters[name] == seg {
+			delete(l.segmenters, name)
+		}
+	})
+}
+
+func (l *LLHLS) segmenter(name string) *Segmenter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.segmenters[name]
+}
+
+func (l *LLHLS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	seg := l.segmenter(parts[0] + "/" + parts[1])
+	if seg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	file := parts[2]
+	switch {
+	case file == "index.m3u8":
+		l.servePlaylist(w, r, seg)
+	case file == "init.mp4":
+		serveData(w, r, seg.Init(), seg.Init() != nil)
+	case strings.HasPrefix(file, "seg") && strings.HasSuffix(file, ".m4s"):
+		sequence, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(file, "seg"), ".m4s"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data, ok := seg.Segment(sequence)
+		serveData(w, r, data, ok)
+	case strings.HasPrefix(file, "part") && strings.HasSuffix(file, ".m4s"):
+		numbers := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(file, "part"), ".m4s"), ".", 2)
+		if len(numbers) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		sequence, err1 := strconv.Atoi(numbers[0])
+		index, err2 := strconv.Atoi(numbers[1])
+		if err1 != nil || err2 != nil {
+			http.NotFound(w, r)
+			return
+		}
+		// The preload hint points at the part being written, the request is answered when it is done
+		seg.Wait(sequence, index, l.blockTimeout(), r.Context().Done())
+		data, ok := seg.Part(sequence, index)
+		serveData(w, r, data, ok)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// blockTimeout is the longest a blocking request waits, three target durations.
+func (l *LLHLS) blockTimeout() time.Duration {
+	return 3 * l.config.SegmentDuration
+}
+
+// servePlaylist serves the playlist, after waiting for the segment and part given by _HLS_msn and _HLS_part.
+func (l *LLHLS) servePlaylist(w http.ResponseWriter, r *http.Request, seg *Segmenter) {
+	query := r.URL.Query()
+	if msn := query.Get("_HLS_msn"); msn != "" {
+		sequence, err := strconv.Atoi(msn)
+		if err != nil || sequence < 0 {
+			http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		index := -1
+		if part := query.Get("_HLS_part"); part != "" {
+			if index, err = strconv.Atoi(part); err != nil || index < 0 {
+				http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+		// Requests too far in the future are rejected instead of being held
+		if last := seg.Sequence(); sequence > last+2 {
+			http.Error(w, "_HLS_msn is too far in the future", http.StatusBadRequest)
+			return
+		}
+		if !seg.Wait(sequence, index, l.blockTimeout(), r.Context().Done()) {
+			http.Error(w, "the requested segment is not available yet", http.StatusServiceUnavailable)
+			return
+		}
+	} else if query.Get("_HLS_part") != "" {
+		http.Error(w, "_HLS_part without _HLS_msn", http.StatusBadRequest)
+		return
+	}
+
+	skip := query.Get("_HLS_skip") == "YES"
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(seg.Playlist(skip)))
+}
+
+// serveData serves an init segment, segment or part.
+func serveData(w http.ResponseWriter, r *http.Request, data []byte, ok bool) {
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
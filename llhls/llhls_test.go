@@ -0,0 +1,22 @@
+package llhls
+
+import (
+	"testing"
+
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+func TestPublishInvalidName(t *testing.T) {
+	registry := server.NewRegistry()
+	l := New(registry, Config{})
+	for _, key := range []string{"..", "a/b", `a\b`, "a?b", "a#b"} {
+		st, err := registry.Publish("live", key, nil)
+		if err != nil {
+			t.Fatalf("Publish(%q) error = %v", key, err)
+		}
+		l.publish(st)
+		if l.segmenter("live/"+key) != nil || st.Subscribers() != 0 {
+			t.Errorf("stream key %q is segmented", key)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package llhls
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Playlist renders the media playlist. With skip the segments older than CAN-SKIP-UNTIL are left out
+// (a delta playlist), the players keep them from the previous reloads.
+func (s *Segmenter) Playlist(skip bool) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The complete segments of the playlist, and the one being written
+	segments := s.segments
+	if complete := len(segments) - 1; complete > s.playlistSize {
+		segments = segments[complete-s.playlistSize:]
+	}
+
+	target := s.targetDuration()
+	partTarget := float64(s.partDuration) / 1000
+	skipUntil := float64(6 * target)
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:9\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=%.1f,PART-HOLD-BACK=%.3f\n", skipUntil, 3*partTarget)
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].sequence)
+	// The discontinuity tag of the first segment counts in its own sequence number
+	discontinuitySequence := segments[0].discontinuitySequence
+	if segments[0].discontinuity {
+		discontinuitySequence--
+	}
+	if discontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuitySequence)
+	}
+	if s.init == nil {
+		return []byte(b.String())
+	}
+	b.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+
+	// Durations from the end of every segment to the end of the playlist
+	remaining := make([]float64, len(segments))
+	var total float64
+	for i := len(segments) - 1; i >= 0; i-- {
+		remaining[i] = total
+		total += segments[i].duration()
+	}
+
+	skipped := 0
+	if skip {
+		// Only the segments entirely before the skip boundary
+		for skipped < len(segments) && segments[skipped].complete && remaining[skipped] >= skipUntil {
+			skipped++
+		}
+		if skipped > 0 {
+			fmt.Fprintf(&b, "#EXT-X-SKIP:SKIPPED-SEGMENTS=%d\n", skipped)
+		}
+	}
+
+	for i, seg := range segments[skipped:] {
+		if seg.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		// The parts are only listed in the last three target durations
+		if remaining[skipped+i] < float64(3*target) {
+			for j, p := range seg.parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", p.duration, partName(seg.sequence, j))
+				if p.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if seg.complete {
+			fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", seg.duration(), segmentName(seg.sequence))
+		}
+	}
+
+	if s.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	} else {
+		sequence, index := s.nextPart()
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", partName(sequence, index))
+	}
+	return []byte(b.String())
+}
+
+// targetDuration returns the EXT-X-TARGETDURATION, every EXTINF rounded to the nearest integer must be at most
+// this long. s.mu must be held.
+func (s *Segmenter) targetDuration() int {
+	d := int(math.Ceil(float64(s.segmentDuration) / 1000))
+	if max := int(math.Floor(s.maxDuration + 0.5)); max > d {
+		d = max
+	}
+	return d
+}
+
+func segmentName(sequence int) string {
+	return fmt.Sprintf("seg%d.m4s", sequence)
+}
+
+func partName(sequence int, index int) string {
+	return fmt.Sprintf("part%d.%d.m4s", sequence, index)
+}
@@ -0,0 +1,80 @@
+package llhls
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/internal/streamtest"
+)
+
+// writeVideo writes 25 fps video with a key frame every second from 0 to end in milliseconds.
+func writeVideo(t *testing.T, s *Segmenter, end uint64) {
+	t.Helper()
+	if err := s.WritePacket(streamtest.AVCSequenceHeader()); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	for ts := uint64(0); ts < end; ts += 40 {
+		if err := s.WritePacket(streamtest.VideoFrame(ts, ts%1000 == 0)); err != nil {
+			t.Fatalf("WritePacket() error = %v", err)
+		}
+	}
+}
+
+func TestPlaylistDiscontinuity(t *testing.T) {
+	config := Config{SegmentDuration: time.Second, PlaylistSize: 2}
+	tests := []struct {
+		name                  string
+		discontinuitySequence int
+		end                   uint64
+		want                  []string
+		notWant               []string
+	}{
+		{
+			name:    "first publish",
+			end:     2500,
+			want:    []string{"#EXT-X-MEDIA-SEQUENCE:7\n"},
+			notWant: []string{"#EXT-X-DISCONTINUITY"},
+		},
+		{
+			name:                  "published again",
+			discontinuitySequence: 1,
+			end:                   2500,
+			want:                  []string{"#EXT-X-MEDIA-SEQUENCE:7\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXT-X-DISCONTINUITY\n#EXT-X-PART:DURATION=0.480,URI=\"part7.0.m4s\""},
+			notWant:               []string{"#EXT-X-DISCONTINUITY-SEQUENCE"},
+		},
+		{
+			name:                  "discontinuity out of the playlist",
+			discontinuitySequence: 3,
+			end:                   4500,
+			want:                  []string{"#EXT-X-MEDIA-SEQUENCE:9\n#EXT-X-DISCONTINUITY-SEQUENCE:3\n"},
+			notWant:               []string{"#EXT-X-DISCONTINUITY\n"},
+		},
+		{
+			name:                  "published again before the discontinuity left",
+			discontinuitySequence: 3,
+			end:                   2500,
+			want:                  []string{"#EXT-X-DISCONTINUITY-SEQUENCE:2\n", "#EXT-X-DISCONTINUITY\n#EXT-X-PART:DURATION=0.480,URI=\"part7.0.m4s\""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSegmenter(config, nil, 7, tt.discontinuitySequence)
+			writeVideo(t, s, tt.end)
+			playlist := string(s.Playlist(false))
+			for _, want := range tt.want {
+				if !strings.Contains(playlist, want) {
+					t.Errorf("playlist without %q:\n%s", want, playlist)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(playlist, notWant) {
+					t.Errorf("playlist with %q:\n%s", notWant, playlist)
+				}
+			}
+			if got := s.DiscontinuitySequence(); got != tt.discontinuitySequence {
+				t.Errorf("DiscontinuitySequence() = %d, want %d", got, tt.discontinuitySequence)
+			}
+		})
+	}
+}
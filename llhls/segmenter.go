@@ -0,0 +1,473 @@
+package llhls
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/codec"
+	"github.com/gerifield/mini-stream-test/fmp4"
+	"github.com/gerifield/mini-stream-test/internal/output"
+	"github.com/gerifield/mini-stream-test/server"
+	"github.com/torresjeff/rtmp/audio"
+	"github.com/torresjeff/rtmp/video"
+)
+
+// Track IDs of the init segment
+const (
+	videoTrackID = 1
+	audioTrackID = 2
+)
+
+// part is a partial segment, a single fMP4 fragment.
+type part struct {
+	// duration in seconds
+	duration float64
+	// independent is set if the part starts with a key frame
+	independent bool
+	data        []byte
+}
+
+// segment is a media segment made of parts. The segment being written is not complete yet.
+type segment struct {
+	sequence int
+	parts    []*part
+	complete bool
+	// discontinuity is set for the first segment of a stream published again, discontinuitySequence
+	// counts the discontinuities up to and including this segment
+	discontinuity         bool
+	discontinuitySequence int
+}
+
+func (s *segment) duration() float64 {
+	var d float64
+	for _, p := range s.parts {
+		d += p.duration
+	}
+	return d
+}
+
+// trackFragment collects the samples of a track for the next part.
+type trackFragment struct {
+	// timeScale is the number of ticks in a second
+	timeScale uint64
+	// decodeTime is the decode time of the next sample in the time scale of the track
+	decodeTime uint64
+	fragment   fmp4.TrackFragment
+}
+
+func (t *trackFragment) add(sample fmp4.Sample, decodeTime uint64) {
+	if len(t.fragment.Samples) == 0 {
+		t.fragment.BaseMediaDecodeTime = decodeTime
+	}
+	t.fragment.Samples = append(t.fragment.Samples, sample)
+}
+
+// Segmenter remuxes the H.264 and AAC packets of a stream into fMP4 parts and segments, and keeps them
+// in memory with the playlist. Segments are cut on the video key frames (or on any audio frame without video)
+// once they reach the target duration, parts are cut on any frame before they would exceed the part target.
+type Segmenter struct {
+	segmentDuration uint64
+	partDuration    uint64
+	playlistSize    int
+
+	// The fields below are only used by the goroutine writing the packets
+	avcConfig []byte
+	avc       *codec.AVCDecoderConfigurationRecord
+	asc       *codec.AudioSpecificConfig
+	ascData   []byte
+	hasVideo  bool
+	hasAudio  bool
+	// first decides whether the first segment starts without video
+	first   output.FirstSegment
+	started bool
+	video   trackFragment
+	audio   trackFragment
+	// The last frame of the track the parts are cut on (video if there is any), its duration is only known
+	// when the next one arrives
+	pending          *fmp4.Sample
+	pendingTime      uint64
+	pendingTimestamp uint64
+	lastDuration     uint32
+	// Start of the current segment and part in milliseconds
+	segmentStart   uint64
+	partStart      uint64
+	fragmentNumber uint32
+
+	mu       sync.Mutex
+	init     []byte
+	segments []*segment
+	// maxDuration is the longest segment so far, in seconds
+	maxDuration float64
+	ended       bool
+	// updated is closed and replaced when a part is added or the stream ends
+	updated chan struct{}
+}
+
+// NewSegmenter creates a Segmenter whose first segment has the given sequence number and discontinuity
+// sequence number. A non-zero discontinuity sequence number means the stream was published before,
+// the first segment starts with a discontinuity. The metadata (which could be nil) tells whether the stream
+// has video, without it video is expected.
+func NewSegmenter(config Config, metadata *server.StreamMetadata, sequence int, discontinuitySequence int) *Segmenter {
+	config = config.withDefaults()
+	return &Segmenter{
+		segmentDuration: uint64(config.SegmentDuration.Milliseconds()),
+		partDuration:    uint64(config.PartDuration.Milliseconds()),
+		playlistSize:    config.PlaylistSize,
+		first:           output.NewFirstSegment(metadata, uint64(config.SegmentDuration.Milliseconds())),
+		video:           trackFragment{timeScale: fmp4.VideoTimeScale, fragment: fmp4.TrackFragment{TrackID: videoTrackID}},
+		audio:           trackFragment{fragment: fmp4.TrackFragment{TrackID: audioTrackID}},
+		segments:        []*segment{{sequence: sequence, discontinuity: discontinuitySequence > 0, discontinuitySequence: discontinuitySequence}},
+		updated:         make(chan struct{}),
+	}
+}
+
+// WritePacket remuxes an audio or video packet, the other packets are ignored.
+func (s *Segmenter) WritePacket(p *server.Packet) error {
+	switch p.Type {
+	case server.TypeVideo:
+		return s.writeVideo(p)
+	case server.TypeAudio:
+		return s.writeAudio(p)
+	}
+	return nil
+}
+
+func (s *Segmenter) writeVideo(p *server.Packet) error {
+	// Frame type and codec, AVC packet type, then the 24 bit composition time
+	if len(p.Payload) < 5 || video.Codec(p.Payload[0]&0x0F) != video.H264 {
+		return nil
+	}
+	switch video.AVCPacketType(p.Payload[1]) {
+	case video.AVCSequenceHeader:
+		avc, err := codec.ParseAVCDecoderConfigurationRecord(p.Payload[5:])
+		if err != nil {
+			return err
+		}
+		s.avc = avc
+		s.avcConfig = p.Payload[5:]
+		return nil
+	case video.AVCNALU:
+	default:
+		return nil
+	}
+	if s.avcConfig == nil {
+		return nil
+	}
+
+	keyFrame := p.IsKeyFrame()
+	if !s.started {
+		if !keyFrame {
+			return nil
+		}
+		s.start(true, p.Timestamp)
+	}
+	if !s.hasVideo {
+		return nil
+	}
+
+	compositionTime := int32(binary.BigEndian.Uint32(p.Payload[1:5])<<8) >> 8
+	sample := &fmp4.Sample{
+		CompositionOffset: compositionTime * 90,
+		KeyFrame:          keyFrame,
+		Data:              p.Payload[5:],
+	}
+	s.writeMain(sample, p.Timestamp*90, p.Timestamp, keyFrame)
+	return nil
+}
+
+func (s *Segmenter) writeAudio(p *server.Packet) error {
+	if len(p.Payload) < 2 || audio.Format(p.Payload[0]>>4) != audio.AAC {
+		return nil
+	}
+	if audio.AACPacketType(p.Payload[1]) == audio.AACSequenceHeader {
+		asc, err := codec.ParseAudioSpecificConfig(p.Payload[2:])
+		if err != nil {
+			return err
+		}
+		s.asc = asc
+		s.ascData = p.Payload[2:]
+		return nil
+	}
+	if s.asc == nil {
+		return nil
+	}
+
+	if !s.started {
+		if !s.first.StartsWithAudio(p.Timestamp, s.avcConfig != nil) {
+			return nil
+		}
+		s.start(false, p.Timestamp)
+	}
+	if !s.hasAudio {
+		return nil
+	}
+
+	sample := &fmp4.Sample{
+		Duration: uint32(s.asc.FrameLength),
+		KeyFrame: true,
+		Data:     p.Payload[2:],
+	}
+	// The decode time counts the samples, so the frames follow each other without gaps in the fragments
+	decodeTime := s.audio.decodeTime
+	s.audio.decodeTime += uint64(s.asc.FrameLength)
+	if !s.hasVideo {
+		s.writeMain(sample, decodeTime, p.Timestamp, true)
+		return nil
+	}
+	s.audio.add(*sample, decodeTime)
+	return nil
+}
+
+// start creates the init segment of the tracks when the first segment starts.
+func (s *Segmenter) start(hasVideo bool, timestamp uint64) {
+	s.started = true
+	s.hasVideo = hasVideo
+	s.hasAudio = s.asc != nil
+	s.segmentStart = timestamp
+	s.partStart = timestamp
+
+	var tracks []*fmp4.Track
+	if s.hasVideo {
+		t := &fmp4.Track{
+			ID:        videoTrackID,
+			Type:      fmp4.TrackVideo,
+			TimeScale: fmp4.VideoTimeScale,
+			AVCConfig: s.avcConfig,
+		}
+		if sps, err := s.avc.DecodeSPS(); err == nil {
+			t.Width = sps.Width
+			t.Height = sps.Height
+		}
+		tracks = append(tracks, t)
+	}
+	if s.hasAudio {
+		tracks = append(tracks, &fmp4.Track{
+			ID:          audioTrackID,
+			Type:        fmp4.TrackAudio,
+			TimeScale:   uint32(s.asc.SampleRate),
+			SampleRate:  s.asc.SampleRate,
+			Channels:    s.asc.Channels(),
+			AudioConfig: s.ascData,
+		})
+		s.audio.timeScale = uint64(s.asc.SampleRate)
+		s.audio.decodeTime = timestamp * uint64(s.asc.SampleRate) / 1000
+	}
+
+	s.mu.Lock()
+	s.init = fmp4.InitSegment(tracks)
+	s.mu.Unlock()
+}
+
+// writeMain adds a frame of the track the parts are cut on. The previous frame gets its duration from it,
+// then goes into the current part, or into a new one if the current part would be too long with it.
+func (s *Segmenter) writeMain(sample *fmp4.Sample, decodeTime uint64, timestamp uint64, keyFrame bool) {
+	main := &s.audio
+	if s.hasVideo {
+		main = &s.video
+	}
+	if s.pending != nil {
+		s.pending.Duration = uint32(decodeTime - s.pendingTime)
+		s.lastDuration = s.pending.Duration
+		if timestamp > s.partStart+s.partDuration && len(main.fragment.Samples) > 0 {
+			s.flushPart(s.pendingTimestamp)
+		}
+		main.add(*s.pending, s.pendingTime)
+	}
+	if keyFrame && timestamp >= s.segmentStart+s.segmentDuration {
+		s.flushPart(timestamp)
+		s.finishSegment()
+		s.segmentStart = timestamp
+	}
+	s.pending = sample
+	s.pendingTime = decodeTime
+	s.pendingTimestamp = timestamp
+}
+
+// flushPart adds the samples collected since the last part as a new part ending at end (in milliseconds).
+func (s *Segmenter) flushPart(end uint64) {
+	var fragments []fmp4.TrackFragment
+	independent := true
+	if s.hasVideo {
+		fragments = append(fragments, s.video.fragment)
+		independent = len(s.video.fragment.Samples) > 0 && s.video.fragment.Samples[0].KeyFrame
+	}
+	if s.hasAudio {
+		fragments = append(fragments, s.audio.fragment)
+	}
+	if len(s.video.fragment.Samples) == 0 && len(s.audio.fragment.Samples) == 0 {
+		return
+	}
+	s.fragmentNumber++
+	p := &part{
+		duration:    float64(end-s.partStart) / 1000,
+		independent: independent,
+		data:        fmp4.Fragment(s.fragmentNumber, fragments),
+	}
+	s.video.fragment.Samples = nil
+	s.audio.fragment.Samples = nil
+	s.partStart = end
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := s.segments[len(s.segments)-1]
+	current.parts = append(current.parts, p)
+	s.notify()
+}
+
+// finishSegment completes the current segment and starts the next one. Segments falling out of the playlist
+// are kept for a while, the slower players could still download them.
+func (s *Segmenter) finishSegment() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := s.segments[len(s.segments)-1]
+	if len(current.parts) == 0 {
+		return
+	}
+	current.complete = true
+	if d := current.duration(); d > s.maxDuration {
+		s.maxDuration = d
+	}
+	s.segments = append(s.segments, &segment{sequence: current.sequence + 1, discontinuitySequence: current.discontinuitySequence})
+	// The complete segments of the playlist, the kept ones and the current one
+	if max := s.playlistSize + 2 + 1; len(s.segments) > max {
+		s.segments = s.segments[len(s.segments)-max:]
+	}
+	s.notify()
+}
+
+// notify wakes up the blocked playlist requests. s.mu must be held.
+func (s *Segmenter) notify() {
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// Close adds the last frame and part, and ends the playlist.
+func (s *Segmenter) Close() {
+	if s.pending != nil {
+		// The last frame is as long as the one before it
+		s.pending.Duration = s.lastDuration
+		main := &s.audio
+		if s.hasVideo {
+			main = &s.video
+		}
+		main.add(*s.pending, s.pendingTime)
+		end := s.pendingTimestamp + uint64(s.lastDuration)*1000/main.timeScale
+		s.pending = nil
+		s.flushPart(end)
+		s.finishSegment()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Drop the empty segment started by finishSegment
+	if last := s.segments[len(s.segments)-1]; !last.complete && len(s.segments) > 1 {
+		s.segments = s.segments[:len(s.segments)-1]
+	}
+	s.ended = true
+	s.notify()
+}
+
+// Sequence returns the sequence number of the segment after the last one.
+func (s *Segmenter) Sequence() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.segments[len(s.segments)-1]
+	if last.complete {
+		return last.sequence + 1
+	}
+	return last.sequence
+}
+
+// DiscontinuitySequence returns the discontinuity sequence number of the last segment.
+func (s *Segmenter) DiscontinuitySequence() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.segments[len(s.segments)-1].discontinuitySequence
+}
+
+// Init returns the init segment, or nil if the first segment hasn't started yet.
+func (s *Segmenter) Init() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.init
+}
+
+// findSegment returns the segment with the sequence number, or nil. s.mu must be held.
+func (s *Segmenter) findSegment(sequence int) *segment {
+	if len(s.segments) == 0 {
+		return nil
+	}
+	i := sequence - s.segments[0].sequence
+	if i < 0 || i >= len(s.segments) {
+		return nil
+	}
+	return s.segments[i]
+}
+
+// Segment returns the data of a complete segment, the fragments of its parts one after the other.
+func (s *Segmenter) Segment(sequence int) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seg := s.findSegment(sequence)
+	if seg == nil || !seg.complete {
+		return nil, false
+	}
+	var data []byte
+	for _, p := range seg.parts {
+		data = append(data, p.data...)
+	}
+	return data, true
+}
+
+// Part returns the data of a part of a segment.
+func (s *Segmenter) Part(sequence int, index int) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seg := s.findSegment(sequence)
+	if seg == nil || index < 0 || index >= len(seg.parts) {
+		return nil, false
+	}
+	return seg.parts[index].data, true
+}
+
+// nextPart returns the position of the part being written, the one the preload hint points at. s.mu must be held.
+func (s *Segmenter) nextPart() (sequence int, index int) {
+	last := s.segments[len(s.segments)-1]
+	return last.sequence, len(last.parts)
+}
+
+// hasPart reports whether the part of the segment, or a later one, is available. A negative index means
+// the whole segment. s.mu must be held.
+func (s *Segmenter) hasPart(sequence int, index int) bool {
+	next, nextIndex := s.nextPart()
+	if index < 0 {
+		return sequence < next
+	}
+	return sequence < next || sequence == next && index < nextIndex
+}
+
+// Wait blocks until the part of the segment (or the whole segment with a negative index) is available
+// or the stream ends. It returns false if the timeout passed or done was closed before that.
+func (s *Segmenter) Wait(sequence int, index int, timeout time.Duration, done <-chan struct{}) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		available := s.hasPart(sequence, index)
+		ended := s.ended
+		updated := s.updated
+		s.mu.Unlock()
+		if available || ended {
+			return true
+		}
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return false
+		case <-done:
+			return false
+		}
+	}
+}
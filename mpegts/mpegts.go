@@ -0,0 +1,262 @@
+// Package mpegts writes MPEG transport streams (ISO/IEC 13818-1) with one H.264 and one AAC elementary stream,
+// the format of the HLS media segments.
+package mpegts
+
+import (
+	"io"
+)
+
+// PacketSize is the size of every transport stream packet.
+const PacketSize = 188
+
+// PIDs of the tables and the elementary streams, the same ones ffmpeg uses
+const (
+	PIDPAT   uint16 = 0x0000
+	PIDPMT   uint16 = 0x1000
+	PIDVideo uint16 = 0x0100
+	PIDAudio uint16 = 0x0101
+)
+
+// Stream types of the PMT
+const (
+	StreamTypeAAC  uint8 = 0x0F
+	StreamTypeH264 uint8 = 0x1B
+)
+
+// PES stream IDs
+const (
+	streamIDAudio = 0xC0
+	streamIDVideo = 0xE0
+)
+
+// Muxer writes the PAT, the PMT and the PES packets of the elementary streams.
+// The timestamps are in the 90 kHz clock of MPEG-TS.
+type Muxer struct {
+	w        io.Writer
+	hasVideo bool
+	hasAudio bool
+	// Continuity counters by PID
+	cc  map[uint16]uint8
+	buf [PacketSize]byte
+}
+
+// NewMuxer creates a Muxer for a transport stream with video, audio or both. The video PID carries the PCR
+// if there is video, the audio PID otherwise.
+func NewMuxer(w io.Writer, hasVideo bool, hasAudio bool) *Muxer {
+	return &Muxer{
+		w:        w,
+		hasVideo: hasVideo,
+		hasAudio: hasAudio,
+		cc:       make(map[uint16]uint8),
+	}
+}
+
+func (m *Muxer) pcrPID() uint16 {
+	if m.hasVideo {
+		return PIDVideo
+	}
+	return PIDAudio
+}
+
+// WriteTables writes the PAT and the PMT, every segment should start with them.
+func (m *Muxer) WriteTables() error {
+	// PAT: a single program (1) with its PMT PID
+	pat := []byte{
+		0x00, 0x01, // program number
+		0xE0 | byte(PIDPMT>>8), byte(PIDPMT & 0xFF),
+	}
+	if err := m.writeSection(PIDPAT, 0x00, 0x0001, pat); err != nil {
+		return err
+	}
+
+	pcr := m.pcrPID()
+	pmt := []byte{
+		0xE0 | byte(pcr>>8), byte(pcr),
+		0xF0, 0x00, // no program info
+	}
+	if m.hasVideo {
+		pmt = append(pmt, StreamTypeH264, 0xE0|byte(PIDVideo>>8), byte(PIDVideo&0xFF), 0xF0, 0x00)
+	}
+	if m.hasAudio {
+		pmt = append(pmt, StreamTypeAAC, 0xE0|byte(PIDAudio>>8), byte(PIDAudio&0xFF), 0xF0, 0x00)
+	}
+	return m.writeSection(PIDPMT, 0x02, 0x0001, pmt)
+}
+
+// writeSection writes a PSI section in a single packet: the pointer field, the section header, the data and the CRC.
+func (m *Muxer) writeSection(pid uint16, tableID uint8, tableIDExtension uint16, data []byte) error {
+	// section_length counts from after the length field: 5 bytes of header, the data and the CRC
+	length := 5 + len(data) + 4
+	section := make([]byte, 0, 3+length)
+	section = append(section,
+		tableID,
+		0xB0|byte(length>>8), byte(length), // section syntax indicator, length
+		byte(tableIDExtension>>8), byte(tableIDExtension),
+		0xC1,       // version 0, current
+		0x00, 0x00, // section number, last section number
+	)
+	section = append(section, data...)
+	crc := crc32(section)
+	section = append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	p := m.buf[:]
+	m.header(p, pid, true, false)
+	// Pointer field: the section starts right after it
+	p[4] = 0
+	n := copy(p[5:], section)
+	for i := 5 + n; i < PacketSize; i++ {
+		p[i] = 0xFF
+	}
+	_, err := m.w.Write(p)
+	return err
+}
+
+// header fills the 4 byte packet header and increments the continuity counter of the PID.
+func (m *Muxer) header(p []byte, pid uint16, start bool, adaptation bool) {
+	p[0] = 0x47
+	p[1] = byte(pid>>8) & 0x1F
+	if start {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	cc := m.cc[pid]
+	m.cc[pid] = (cc + 1) & 0x0F
+	// Payload is always present
+	p[3] = 0x10 | cc
+	if adaptation {
+		p[3] |= 0x20
+	}
+}
+
+// WriteVideo writes an H.264 access unit in Annex-B format. pts and dts are in the 90 kHz clock.
+func (m *Muxer) WriteVideo(data []byte, pts uint64, dts uint64, keyFrame bool) error {
+	return m.writePES(PIDVideo, streamIDVideo, data, pts, dts, keyFrame)
+}
+
+// WriteAudio writes ADTS framed AAC audio. pts is in the 90 kHz clock.
+func (m *Muxer) WriteAudio(data []byte, pts uint64) error {
+	return m.writePES(PIDAudio, streamIDAudio, data, pts, pts, !m.hasVideo)
+}
+
+// writePES splits a PES packet into transport stream packets. The first packet of the PCR PID carries the PCR,
+// and the random access indicator is set for the key frames.
+func (m *Muxer) writePES(pid uint16, streamID uint8, data []byte, pts uint64, dts uint64, randomAccess bool) error {
+	pes := pesHeader(streamID, len(data), pts, dts)
+
+	first := true
+	for len(pes) > 0 || len(data) > 0 {
+		p := m.buf[:]
+		var adaptation []byte
+		if first {
+			var flags byte
+			if randomAccess {
+				flags |= 0x40
+			}
+			if pid == m.pcrPID() {
+				flags |= 0x10
+				adaptation = append(adaptation, flags)
+				adaptation = appendPCR(adaptation, dts)
+			} else if randomAccess {
+				adaptation = append(adaptation, flags)
+			}
+		}
+
+		// Space left for the payload with the adaptation field (1 byte of length, then its content)
+		space := PacketSize - 4
+		if adaptation != nil {
+			space -= 1 + len(adaptation)
+		}
+		remaining := len(pes) + len(data)
+		if remaining < space {
+			// Stuff the last packet with the adaptation field
+			stuffing := space - remaining
+			if adaptation == nil {
+				if stuffing == 1 {
+					// An adaptation field of length 0
+					adaptation = []byte{}
+					stuffing = 0
+				} else {
+					adaptation = []byte{0x00}
+					stuffing -= 2
+				}
+			}
+			for i := 0; i < stuffing; i++ {
+				adaptation = append(adaptation, 0xFF)
+			}
+			space = remaining
+		}
+
+		m.header(p, pid, first, adaptation != nil)
+		pos := 4
+		if adaptation != nil {
+			p[4] = byte(len(adaptation))
+			copy(p[5:], adaptation)
+			pos += 1 + len(adaptation)
+		}
+		n := copy(p[pos:pos+space], pes)
+		pes = pes[n:]
+		copy(p[pos+n:pos+space], data[:space-n])
+		data = data[space-n:]
+
+		if _, err := m.w.Write(p); err != nil {
+			return err
+		}
+		first = false
+	}
+	return nil
+}
+
+// pesHeader returns the PES header with the PTS, and the DTS if it differs from the PTS.
+func pesHeader(streamID uint8, dataLength int, pts uint64, dts uint64) []byte {
+	headerDataLength := 5
+	flags := byte(0x80) // PTS only
+	if dts != pts {
+		headerDataLength = 10
+		flags = 0xC0
+	}
+	h := []byte{
+		0x00, 0x00, 0x01, streamID,
+		0x00, 0x00, // packet length, set below
+		0x80, // marker bits
+		flags,
+		byte(headerDataLength),
+	}
+	// The packet length could be 0 (unbounded) for video, audio frames always fit
+	length := 3 + headerDataLength + dataLength
+	if length <= 0xFFFF && streamID != streamIDVideo {
+		h[4] = byte(length >> 8)
+		h[5] = byte(length)
+	}
+	if dts != pts {
+		h = appendTimestamp(h, 0x03, pts)
+		h = appendTimestamp(h, 0x01, dts)
+	} else {
+		h = appendTimestamp(h, 0x02, pts)
+	}
+	return h
+}
+
+// appendTimestamp appends a 33 bit PTS or DTS with its 4 bit prefix and the marker bits.
+func appendTimestamp(b []byte, prefix byte, ts uint64) []byte {
+	ts &= 0x1FFFFFFFF
+	return append(b,
+		prefix<<4|byte(ts>>29)&0x0E|0x01,
+		byte(ts>>22),
+		byte(ts>>14)|0x01,
+		byte(ts>>7),
+		byte(ts<<1)|0x01,
+	)
+}
+
+// appendPCR appends the 6 byte PCR: the 33 bit base in the 90 kHz clock, 6 reserved bits and a 0 extension.
+func appendPCR(b []byte, pcr uint64) []byte {
+	pcr &= 0x1FFFFFFFF
+	return append(b,
+		byte(pcr>>25),
+		byte(pcr>>17),
+		byte(pcr>>9),
+		byte(pcr>>1),
+		byte(pcr<<7)|0x7E,
+		0x00,
+	)
+}
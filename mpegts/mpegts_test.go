@@ -0,0 +1,78 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCRC32(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want uint32
+	}{
+		{"empty", nil, 0xFFFFFFFF},
+		{"check value", []byte("123456789"), 0x0376E6E7},
+		{"PAT of a single program", []byte{0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00, 0x00, 0x01, 0xF0, 0x00}, 0x2AB104B2},
+		// The CRC of a section including its CRC is 0
+		{"PAT with its CRC", []byte{0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00, 0x00, 0x01, 0xF0, 0x00, 0x2A, 0xB1, 0x04, 0xB2}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := crc32(tt.data); got != tt.want {
+				t.Errorf("crc32() = %#08x, want %#08x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteTables(t *testing.T) {
+	tests := []struct {
+		name     string
+		hasVideo bool
+		hasAudio bool
+		// The PMT section after the pointer field, without its CRC
+		pmt []byte
+	}{
+		{
+			name:     "video and audio",
+			hasVideo: true,
+			hasAudio: true,
+			pmt: []byte{0x02, 0xB0, 0x17, 0x00, 0x01, 0xC1, 0x00, 0x00, 0xE1, 0x00, 0xF0, 0x00,
+				0x1B, 0xE1, 0x00, 0xF0, 0x00, 0x0F, 0xE1, 0x01, 0xF0, 0x00},
+		},
+		{
+			name:     "audio only",
+			hasAudio: true,
+			pmt:      []byte{0x02, 0xB0, 0x12, 0x00, 0x01, 0xC1, 0x00, 0x00, 0xE1, 0x01, 0xF0, 0x00, 0x0F, 0xE1, 0x01, 0xF0, 0x00},
+		},
+	}
+	pat := []byte{0x47, 0x40, 0x00, 0x10, 0x00, 0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00, 0x00, 0x01, 0xF0, 0x00, 0x2A, 0xB1, 0x04, 0xB2}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := NewMuxer(&b, tt.hasVideo, tt.hasAudio).WriteTables(); err != nil {
+				t.Fatalf("WriteTables() error = %v", err)
+			}
+			if b.Len() != 2*PacketSize {
+				t.Fatalf("WriteTables() wrote %d bytes, want 2 packets", b.Len())
+			}
+			packets := b.Bytes()
+			if !bytes.Equal(packets[:len(pat)], pat) {
+				t.Errorf("PAT = %x, want %x", packets[:len(pat)], pat)
+			}
+			pmt := packets[PacketSize:]
+			if pid := uint16(pmt[1]&0x1F)<<8 | uint16(pmt[2]); pid != PIDPMT {
+				t.Errorf("PMT PID = %#x, want %#x", pid, PIDPMT)
+			}
+			if section := pmt[5 : 5+len(tt.pmt)]; !bytes.Equal(section, tt.pmt) {
+				t.Errorf("PMT = %x, want %x", section, tt.pmt)
+			}
+			// The section with its CRC, after the pointer field
+			length := 3 + (int(pmt[6]&0x0F)<<8 | int(pmt[7]))
+			if crc := crc32(pmt[5 : 5+length]); crc != 0 {
+				t.Errorf("CRC of the PMT section is %#08x, want 0", crc)
+			}
+		})
+	}
+}
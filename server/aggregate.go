@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Every sub-message of an aggregate is stored like an FLV tag: an 11 byte header, the payload,
+// then the 4 byte size of the whole sub-message (back pointer).
+const (
+	aggregateHeaderSize      = 11
+	aggregateBackPointerSize = 4
+)
+
+// splitAggregate splits the payload of an aggregate message into its sub-messages.
+// The sub-message timestamps are rebased, so the first one gets the timestamp of the aggregate
+// and the others keep their offset from the first one.
+func splitAggregate(m *Message) ([]*Message, error) {
+	var messages []*Message
+	var first uint32
+	payload := m.Payload
+	for len(payload) > 0 {
+		if len(payload) < aggregateHeaderSize {
+			return messages, fmt.Errorf("aggregate sub-message header truncated, %d bytes left", len(payload))
+		}
+		typeID := payload[0]
+		size := uint24(payload[1:])
+		// Lower 24 bits first, then the upper 8 bits, the same way as in FLV tags
+		timestamp := uint24(payload[4:]) | uint32(payload[7])<<24
+		end := aggregateHeaderSize + int(size)
+		if len(payload) < end {
+			return messages, fmt.Errorf("aggregate sub-message of %d bytes truncated, %d bytes left", size, len(payload)-aggregateHeaderSize)
+		}
+		if len(messages) == 0 {
+			first = timestamp
+		}
+		if typeID == TypeAggregate {
+			return messages, fmt.Errorf("nested aggregate message")
+		}
+
+		// The difference is calculated on 32 bits, so a wrap around inside the aggregate works too
+		offset := int64(int32(timestamp - first))
+		absolute := m.AbsoluteTimestamp
+		if offset >= 0 || uint64(-offset) <= absolute {
+			absolute = uint64(int64(absolute) + offset)
+		}
+		messages = append(messages, &Message{
+			ChunkStreamID: m.ChunkStreamID,
+			TypeID:        typeID,
+			// The stream ID of the sub-messages is usually 0, they belong to the stream of the aggregate
+			StreamID:  m.StreamID,
+			Timestamp: m.Timestamp + uint32(offset),
+			Payload:   payload[aggregateHeaderSize:end],
+
+			AbsoluteTimestamp: absolute,
+		})
+
+		// Some encoders leave out the back pointer after the last sub-message
+		if len(payload) >= end+aggregateBackPointerSize {
+			if backPointer := binary.BigEndian.Uint32(payload[end:]); backPointer != uint32(end) {
+				return messages, fmt.Errorf("aggregate back pointer %d doesn't match the sub-message size %d", backPointer, end)
+			}
+			end += aggregateBackPointerSize
+		}
+		payload = payload[end:]
+	}
+	return messages, nil
+}
+
+// handleAggregateMessage dispatches the sub-messages of an aggregate message like they were sent one by one.
+// Aggregates carry media only: commands and protocol control messages are not dispatched from them.
+func (s *Session) handleAggregateMessage(m *Message) {
+	messages, err := splitAggregate(m)
+	if err != nil {
+		// Dispatch what could be parsed, the rest of the aggregate is dropped
+		s.protocolViolation(err)
+	}
+	s.debugln("Aggregate message with", len(messages), "sub-messages")
+	for _, sub := range messages {
+		switch sub.TypeID {
+		case TypeAudio, TypeVideo, TypeDataAMF0, TypeDataAMF3:
+			s.handleMessage(sub)
+		default:
+			s.protocolViolation(fmt.Errorf("message type %d in an aggregate message", sub.TypeID))
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// aggregateTag returns a sub-message of an aggregate, with its back pointer if backPointer is set.
+func aggregateTag(typeID uint8, timestamp uint32, payload []byte, backPointer bool) []byte {
+	b := []byte{
+		typeID,
+		byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)),
+		byte(timestamp >> 16), byte(timestamp >> 8), byte(timestamp), byte(timestamp >> 24),
+		0, 0, 0,
+	}
+	b = append(b, payload...)
+	if backPointer {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(aggregateHeaderSize+len(payload)))
+		b = append(b, size[:]...)
+	}
+	return b
+}
+
+func TestSplitAggregate(t *testing.T) {
+	type sub struct {
+		typeID    uint8
+		timestamp uint32
+		absolute  uint64
+		payload   []byte
+	}
+	tests := []struct {
+		name      string
+		timestamp uint32
+		absolute  uint64
+		payload   []byte
+		want      []sub
+		wantErr   bool
+	}{
+		{
+			name:      "rebased timestamps",
+			timestamp: 1000,
+			absolute:  1000,
+			payload: concat(
+				aggregateTag(TypeVideo, 5000, []byte{1, 2}, true),
+				aggregateTag(TypeAudio, 5023, []byte{3}, true),
+			),
+			want: []sub{
+				{TypeVideo, 1000, 1000, []byte{1, 2}},
+				{TypeAudio, 1023, 1023, []byte{3}},
+			},
+		},
+		{
+			name:      "missing last back pointer",
+			timestamp: 0,
+			absolute:  1 << 32,
+			payload: concat(
+				aggregateTag(TypeAudio, 10, []byte{1}, true),
+				aggregateTag(TypeAudio, 30, []byte{2}, false),
+			),
+			want: []sub{
+				{TypeAudio, 0, 1 << 32, []byte{1}},
+				{TypeAudio, 20, 1<<32 + 20, []byte{2}},
+			},
+		},
+		{
+			name:      "wrap around inside the aggregate",
+			timestamp: 100,
+			absolute:  100,
+			payload: concat(
+				aggregateTag(TypeVideo, 0xFFFFFFF0, []byte{1}, true),
+				aggregateTag(TypeVideo, 0x10, []byte{2}, true),
+			),
+			want: []sub{
+				{TypeVideo, 100, 100, []byte{1}},
+				{TypeVideo, 132, 132, []byte{2}},
+			},
+		},
+		{
+			name:      "earlier sub-message than the first",
+			timestamp: 10,
+			absolute:  10,
+			payload: concat(
+				aggregateTag(TypeVideo, 100, []byte{1}, true),
+				aggregateTag(TypeAudio, 50, []byte{2}, true),
+			),
+			want: []sub{
+				{TypeVideo, 10, 10, []byte{1}},
+				// 10 - 50 wraps around on 32 bits, the absolute timestamp doesn't go below 0
+				{TypeAudio, 0xFFFFFFD8, 10, []byte{2}},
+			},
+		},
+		{
+			name:    "truncated header",
+			payload: concat(aggregateTag(TypeAudio, 0, []byte{1}, true), []byte{TypeAudio, 0}),
+			want:    []sub{{TypeAudio, 0, 0, []byte{1}}},
+			wantErr: true,
+		},
+		{
+			name:    "truncated payload",
+			payload: aggregateTag(TypeAudio, 0, []byte{1, 2, 3}, false)[:aggregateHeaderSize+2],
+			wantErr: true,
+		},
+		{
+			name:    "wrong back pointer",
+			payload: concat(aggregateTag(TypeAudio, 0, []byte{1}, false), []byte{0, 0, 0, 1}),
+			want:    []sub{{TypeAudio, 0, 0, []byte{1}}},
+			wantErr: true,
+		},
+		{
+			name:    "nested aggregate",
+			payload: aggregateTag(TypeAggregate, 0, []byte{1}, true),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Message{ChunkStreamID: 4, TypeID: TypeAggregate, StreamID: 1, Timestamp: tt.timestamp, AbsoluteTimestamp: tt.absolute, Payload: tt.payload}
+			messages, err := splitAggregate(m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitAggregate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(messages) != len(tt.want) {
+				t.Fatalf("splitAggregate() returned %d messages, want %d", len(messages), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := messages[i]
+				if got.TypeID != want.typeID || got.Timestamp != want.timestamp || got.AbsoluteTimestamp != want.absolute ||
+					!bytes.Equal(got.Payload, want.payload) || got.StreamID != m.StreamID || got.ChunkStreamID != m.ChunkStreamID {
+					t.Errorf("message %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
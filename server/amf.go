@@ -0,0 +1,663 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/torresjeff/rtmp/amf/amf0"
+	"github.com/torresjeff/rtmp/amf/amf3"
+)
+
+// AMF0 markers missing from the amf0 package, and the marker switching a value to AMF3
+const (
+	amf0TypeStrictArray   byte = 0x0A
+	amf0TypeAvmPlusObject byte = 0x11
+)
+
+// maxAMFDepth is the deepest nesting of objects and arrays decoded. The decoders are recursive, a command message
+// could have millions of nested objects otherwise, which would run out of stack.
+const maxAMFDepth = 64
+
+var (
+	ErrAMFTruncated         = errors.New("amf: value is truncated")
+	ErrAMFTooDeep           = errors.New("amf: values are nested too deep")
+	ErrAMFCircularReference = errors.New("amf: reference to an object being decoded")
+)
+
+// amfDecoder decodes AMF0 values of command and data messages. Values marked as avmplus-object
+// (sent by clients using objectEncoding 3) are decoded as AMF3.
+//
+// The decoded values use the same types as the amf0 package: float64, bool, string, nil,
+// map[string]interface{}, amf0.ECMAArray and time.Time, plus []interface{} for arrays and []byte for byte arrays.
+// AMF3 integers are returned as float64 too, so the callers don't have to care about the encoding.
+type amfDecoder struct {
+	b   []byte
+	pos int
+	// depth is the number of objects and arrays the current value is nested in
+	depth int
+
+	// AMF0 reference table
+	objects0 referenceTable
+	// AMF3 reference tables, they are only valid inside a single AMF3 value
+	strings3 []string
+	objects3 referenceTable
+	traits3  []amf3Traits
+}
+
+// referenceTable is the table of the objects and arrays which could be referenced by index. An entry is reserved
+// when the value starts, so the later values get the right indexes, but it can only be referenced once the value
+// is complete: a reference from inside the value would make a map containing itself, which can't be formatted
+// or encoded.
+type referenceTable struct {
+	values []interface{}
+	done   []bool
+}
+
+// reserve adds an entry for a value being decoded and returns its index.
+func (t *referenceTable) reserve() int {
+	t.values = append(t.values, nil)
+	t.done = append(t.done, false)
+	return len(t.values) - 1
+}
+
+// complete sets the value of a reserved entry.
+func (t *referenceTable) complete(index int, v interface{}) {
+	t.values[index] = v
+	t.done[index] = true
+}
+
+// add adds a complete value.
+func (t *referenceTable) add(v interface{}) {
+	t.complete(t.reserve(), v)
+}
+
+// get returns the value of an entry, the index must be less than len(t.values).
+func (t *referenceTable) get(index int) (interface{}, error) {
+	if !t.done[index] {
+		return nil, ErrAMFCircularReference
+	}
+	return t.values[index], nil
+}
+
+type amf3Traits struct {
+	className      string
+	externalizable bool
+	dynamic        bool
+	members        []string
+}
+
+// decodeAMF0Values decodes every AMF0 value of the payload.
+func decodeAMF0Values(payload []byte) ([]interface{}, error) {
+	d := &amfDecoder{b: payload}
+	var values []interface{}
+	for d.pos < len(d.b) {
+		v, err := d.decodeAMF0()
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (d *amfDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.b)-d.pos < n {
+		return nil, ErrAMFTruncated
+	}
+	b := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// descend is called before decoding the values of an object or an array, ascend after them.
+func (d *amfDecoder) descend() error {
+	if d.depth >= maxAMFDepth {
+		return ErrAMFTooDeep
+	}
+	d.depth++
+	return nil
+}
+
+func (d *amfDecoder) ascend() {
+	d.depth--
+}
+
+func (d *amfDecoder) readByte() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *amfDecoder) readDouble() (float64, error) {
+	b, err := d.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+}
+
+func (d *amfDecoder) readString0(long bool) (string, error) {
+	var length int
+	if long {
+		b, err := d.next(4)
+		if err != nil {
+			return "", err
+		}
+		length = int(binary.BigEndian.Uint32(b))
+	} else {
+		b, err := d.next(2)
+		if err != nil {
+			return "", err
+		}
+		length = int(binary.BigEndian.Uint16(b))
+	}
+	b, err := d.next(length)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *amfDecoder) decodeAMF0() (interface{}, error) {
+	marker, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amf0.TypeNumber:
+		return d.readDouble()
+	case amf0.TypeBoolean:
+		b, err := d.readByte()
+		return b != 0, err
+	case amf0.TypeString:
+		return d.readString0(false)
+	case amf0.TypeLongString, amf0.TypeXMLDocument:
+		return d.readString0(true)
+	case amf0.TypeNull, amf0.TypeUndefined, amf0.TypeUnsupported:
+		return nil, nil
+	case amf0.TypeObject:
+		obj := make(map[string]interface{})
+		return d.decodeAMF0Object(obj, obj)
+	case amf0.TypeTypedObject:
+		// The class name is dropped, the properties are the same as for an anonymous object
+		if _, err := d.readString0(false); err != nil {
+			return nil, err
+		}
+		obj := make(map[string]interface{})
+		return d.decodeAMF0Object(obj, obj)
+	case amf0.TypeECMAArray:
+		// The associative count is only a hint, the properties are terminated by an object end marker
+		if _, err := d.next(4); err != nil {
+			return nil, err
+		}
+		arr := make(amf0.ECMAArray)
+		return d.decodeAMF0Object(arr, arr)
+	case amf0TypeStrictArray:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		count := int(binary.BigEndian.Uint32(b))
+		if count > len(d.b)-d.pos {
+			return nil, ErrAMFTruncated
+		}
+		if err := d.descend(); err != nil {
+			return nil, err
+		}
+		defer d.ascend()
+		// The array gets its reference index before its elements
+		index := d.objects0.reserve()
+		arr := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			v, err := d.decodeAMF0()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		d.objects0.complete(index, arr)
+		return arr, nil
+	case amf0.TypeDate:
+		ms, err := d.readDouble()
+		if err != nil {
+			return nil, err
+		}
+		// Time zone is reserved and should be 0
+		if _, err := d.next(2); err != nil {
+			return nil, err
+		}
+		return time.Unix(0, int64(ms)*int64(time.Millisecond)), nil
+	case amf0.TypeReference:
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		index := int(binary.BigEndian.Uint16(b))
+		if index >= len(d.objects0.values) {
+			return nil, fmt.Errorf("amf0: invalid reference %d", index)
+		}
+		return d.objects0.get(index)
+	case amf0TypeAvmPlusObject:
+		// Every AMF3 value starts with empty reference tables
+		d.strings3, d.objects3, d.traits3 = nil, referenceTable{}, nil
+		return d.decodeAMF3()
+	default:
+		return nil, fmt.Errorf("amf0: unsupported type 0x%02x", marker)
+	}
+}
+
+// decodeAMF0Object decodes the properties of an object or an ECMA array into obj, and adds v (obj with its own type)
+// to the reference table.
+func (d *amfDecoder) decodeAMF0Object(v interface{}, obj map[string]interface{}) (interface{}, error) {
+	index := d.objects0.reserve()
+	if err := d.decodeAMF0Properties(obj); err != nil {
+		return nil, err
+	}
+	d.objects0.complete(index, v)
+	return v, nil
+}
+
+// decodeAMF0Properties reads key-value pairs until the object end marker.
+func (d *amfDecoder) decodeAMF0Properties(obj map[string]interface{}) error {
+	if err := d.descend(); err != nil {
+		return err
+	}
+	defer d.ascend()
+	for {
+		key, err := d.readString0(false)
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			// An empty key is followed by the object end marker
+			marker, err := d.readByte()
+			if err != nil {
+				return err
+			}
+			if marker != amf0.TypeObjectEnd {
+				return fmt.Errorf("amf0: expected object end, got 0x%02x", marker)
+			}
+			return nil
+		}
+		v, err := d.decodeAMF0()
+		if err != nil {
+			return err
+		}
+		obj[key] = v
+	}
+}
+
+// readU29 reads a variable length unsigned 29 bit integer. The first 3 bytes carry 7 bits each
+// and have their high bit set if more bytes follow, the 4th byte carries 8 bits.
+func (d *amfDecoder) readU29() (uint32, error) {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		b, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if i == 3 {
+			return v<<8 | uint32(b), nil
+		}
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return v, nil
+}
+
+// readString3 reads an AMF3 string, which is either a reference to the string table or an inline string.
+func (d *amfDecoder) readString3() (string, error) {
+	u, err := d.readU29()
+	if err != nil {
+		return "", err
+	}
+	if u&1 == 0 {
+		index := int(u >> 1)
+		if index >= len(d.strings3) {
+			return "", fmt.Errorf("amf3: invalid string reference %d", index)
+		}
+		return d.strings3[index], nil
+	}
+	b, err := d.next(int(u >> 1))
+	if err != nil {
+		return "", err
+	}
+	s := string(b)
+	// Empty strings are never sent by reference
+	if s != "" {
+		d.strings3 = append(d.strings3, s)
+	}
+	return s, nil
+}
+
+// objectReference3 reads the U29 header of a complex AMF3 value. If it is a reference, the referenced value is returned.
+func (d *amfDecoder) objectReference3() (u uint32, ref interface{}, isRef bool, err error) {
+	u, err = d.readU29()
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if u&1 == 0 {
+		index := int(u >> 1)
+		if index >= len(d.objects3.values) {
+			return 0, nil, false, fmt.Errorf("amf3: invalid object reference %d", index)
+		}
+		ref, err = d.objects3.get(index)
+		return 0, ref, true, err
+	}
+	return u >> 1, nil, false, nil
+}
+
+func (d *amfDecoder) decodeAMF3() (interface{}, error) {
+	marker, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amf3.TypeUndefined, amf3.TypeNull:
+		return nil, nil
+	case amf3.TypeFalse:
+		return false, nil
+	case amf3.TypeTrue:
+		return true, nil
+	case amf3.TypeInteger:
+		u, err := d.readU29()
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend the 29 bit integer
+		return float64(int32(u<<3) >> 3), nil
+	case amf3.TypeDouble:
+		return d.readDouble()
+	case amf3.TypeString:
+		return d.readString3()
+	case amf3.TypeXmlDoc, amf3.TypeXml:
+		u, ref, isRef, err := d.objectReference3()
+		if err != nil || isRef {
+			return ref, err
+		}
+		b, err := d.next(int(u))
+		if err != nil {
+			return nil, err
+		}
+		d.objects3.add(string(b))
+		return string(b), nil
+	case amf3.TypeDate:
+		_, ref, isRef, err := d.objectReference3()
+		if err != nil || isRef {
+			return ref, err
+		}
+		ms, err := d.readDouble()
+		if err != nil {
+			return nil, err
+		}
+		t := time.Unix(0, int64(ms)*int64(time.Millisecond))
+		d.objects3.add(t)
+		return t, nil
+	case amf3.TypeArray:
+		return d.decodeArray3()
+	case amf3.TypeObject:
+		return d.decodeObject3()
+	case amf3.TypeByteArray:
+		u, ref, isRef, err := d.objectReference3()
+		if err != nil || isRef {
+			return ref, err
+		}
+		b, err := d.next(int(u))
+		if err != nil {
+			return nil, err
+		}
+		bytes := append([]byte(nil), b...)
+		d.objects3.add(bytes)
+		return bytes, nil
+	case amf3.TypeVectorInt, amf3.TypeVectorUint, amf3.TypeVectorDouble, amf3.TypeVectorObject:
+		return d.decodeVector3(marker)
+	case amf3.TypeDictionary:
+		return d.decodeDictionary3()
+	default:
+		return nil, fmt.Errorf("amf3: unsupported type 0x%02x", marker)
+	}
+}
+
+// decodeArray3 decodes an array. Dense arrays become []interface{}, arrays with associative
+// keys become an amf0.ECMAArray with the dense values under their index.
+func (d *amfDecoder) decodeArray3() (interface{}, error) {
+	count, ref, isRef, err := d.objectReference3()
+	if err != nil || isRef {
+		return ref, err
+	}
+	if int(count) > len(d.b)-d.pos {
+		return nil, ErrAMFTruncated
+	}
+
+	if err := d.descend(); err != nil {
+		return nil, err
+	}
+	defer d.ascend()
+
+	index := d.objects3.reserve()
+	var assoc amf0.ECMAArray
+	for {
+		key, err := d.readString3()
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			break
+		}
+		if assoc == nil {
+			assoc = make(amf0.ECMAArray)
+		}
+		v, err := d.decodeAMF3()
+		if err != nil {
+			return nil, err
+		}
+		assoc[key] = v
+	}
+
+	dense := make([]interface{}, 0, count)
+	for i := uint32(0); i < count; i++ {
+		v, err := d.decodeAMF3()
+		if err != nil {
+			return nil, err
+		}
+		if assoc != nil {
+			assoc[strconv.Itoa(int(i))] = v
+		} else {
+			dense = append(dense, v)
+		}
+	}
+	if assoc != nil {
+		d.objects3.complete(index, assoc)
+		return assoc, nil
+	}
+	d.objects3.complete(index, dense)
+	return dense, nil
+}
+
+func (d *amfDecoder) decodeObject3() (interface{}, error) {
+	u, ref, isRef, err := d.objectReference3()
+	if err != nil || isRef {
+		return ref, err
+	}
+
+	if err := d.descend(); err != nil {
+		return nil, err
+	}
+	defer d.ascend()
+
+	var traits amf3Traits
+	if u&1 == 0 {
+		// Traits reference
+		index := int(u >> 1)
+		if index >= len(d.traits3) {
+			return nil, fmt.Errorf("amf3: invalid traits reference %d", index)
+		}
+		traits = d.traits3[index]
+	} else {
+		traits.externalizable = u&2 != 0
+		traits.dynamic = u&4 != 0
+		memberCount := int(u >> 3)
+		if traits.className, err = d.readString3(); err != nil {
+			return nil, err
+		}
+		if memberCount > len(d.b)-d.pos {
+			return nil, ErrAMFTruncated
+		}
+		for i := 0; i < memberCount; i++ {
+			member, err := d.readString3()
+			if err != nil {
+				return nil, err
+			}
+			traits.members = append(traits.members, member)
+		}
+		d.traits3 = append(d.traits3, traits)
+	}
+
+	if traits.externalizable {
+		// Only the Flex collection wrappers are known, they serialize a single value
+		switch traits.className {
+		case "flex.messaging.io.ArrayCollection", "flex.messaging.io.ObjectProxy":
+			index := d.objects3.reserve()
+			v, err := d.decodeAMF3()
+			if err != nil {
+				return nil, err
+			}
+			d.objects3.complete(index, v)
+			return v, nil
+		default:
+			return nil, fmt.Errorf("amf3: unsupported externalizable class %q", traits.className)
+		}
+	}
+
+	index := d.objects3.reserve()
+	obj := make(map[string]interface{})
+	for _, member := range traits.members {
+		v, err := d.decodeAMF3()
+		if err != nil {
+			return nil, err
+		}
+		obj[member] = v
+	}
+	if traits.dynamic {
+		for {
+			key, err := d.readString3()
+			if err != nil {
+				return nil, err
+			}
+			if key == "" {
+				break
+			}
+			v, err := d.decodeAMF3()
+			if err != nil {
+				return nil, err
+			}
+			obj[key] = v
+		}
+	}
+	d.objects3.complete(index, obj)
+	return obj, nil
+}
+
+func (d *amfDecoder) decodeVector3(marker byte) (interface{}, error) {
+	count, ref, isRef, err := d.objectReference3()
+	if err != nil || isRef {
+		return ref, err
+	}
+	// Fixed length flag
+	if _, err := d.readByte(); err != nil {
+		return nil, err
+	}
+	if int(count) > len(d.b)-d.pos {
+		return nil, ErrAMFTruncated
+	}
+	if marker == amf3.TypeVectorObject {
+		// Object type name
+		if _, err := d.readString3(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.descend(); err != nil {
+		return nil, err
+	}
+	defer d.ascend()
+
+	index := d.objects3.reserve()
+	vector := make([]interface{}, 0, count)
+	for i := uint32(0); i < count; i++ {
+		switch marker {
+		case amf3.TypeVectorInt:
+			b, err := d.next(4)
+			if err != nil {
+				return nil, err
+			}
+			vector = append(vector, float64(int32(binary.BigEndian.Uint32(b))))
+		case amf3.TypeVectorUint:
+			b, err := d.next(4)
+			if err != nil {
+				return nil, err
+			}
+			vector = append(vector, float64(binary.BigEndian.Uint32(b)))
+		case amf3.TypeVectorDouble:
+			f, err := d.readDouble()
+			if err != nil {
+				return nil, err
+			}
+			vector = append(vector, f)
+		default:
+			v, err := d.decodeAMF3()
+			if err != nil {
+				return nil, err
+			}
+			vector = append(vector, v)
+		}
+	}
+	d.objects3.complete(index, vector)
+	return vector, nil
+}
+
+// decodeDictionary3 decodes a dictionary into a map, the keys are formatted as strings.
+func (d *amfDecoder) decodeDictionary3() (interface{}, error) {
+	count, ref, isRef, err := d.objectReference3()
+	if err != nil || isRef {
+		return ref, err
+	}
+	// Weak keys flag
+	if _, err := d.readByte(); err != nil {
+		return nil, err
+	}
+	if int(count) > len(d.b)-d.pos {
+		return nil, ErrAMFTruncated
+	}
+
+	if err := d.descend(); err != nil {
+		return nil, err
+	}
+	defer d.ascend()
+
+	index := d.objects3.reserve()
+	dict := make(map[string]interface{})
+	for i := uint32(0); i < count; i++ {
+		key, err := d.decodeAMF3()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decodeAMF3()
+		if err != nil {
+			return nil, err
+		}
+		dict[fmt.Sprint(key)] = v
+	}
+	d.objects3.complete(index, dict)
+	return dict, nil
+}
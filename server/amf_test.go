@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/torresjeff/rtmp/amf/amf0"
+)
+
+func TestDecodeAMF0Values(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []interface{}
+	}{
+		{
+			name:    "number",
+			payload: []byte{0x00, 0x3F, 0xF0, 0, 0, 0, 0, 0, 0},
+			want:    []interface{}{1.0},
+		},
+		{
+			name:    "string, boolean and null",
+			payload: []byte{0x02, 0, 2, 'h', 'i', 0x01, 0x01, 0x05},
+			want:    []interface{}{"hi", true, nil},
+		},
+		{
+			name:    "object",
+			payload: []byte{0x03, 0, 1, 'a', 0x01, 0x00, 0, 0, 0x09},
+			want:    []interface{}{map[string]interface{}{"a": false}},
+		},
+		{
+			name:    "ECMA array",
+			payload: []byte{0x08, 0, 0, 0, 1, 0, 1, 'a', 0x05, 0, 0, 0x09},
+			want:    []interface{}{amf0.ECMAArray{"a": nil}},
+		},
+		{
+			name:    "strict array",
+			payload: []byte{0x0A, 0, 0, 0, 2, 0x05, 0x01, 0x01},
+			want:    []interface{}{[]interface{}{nil, true}},
+		},
+		{
+			name:    "reference",
+			payload: []byte{0x03, 0, 0, 0x09, 0x07, 0, 0},
+			want:    []interface{}{map[string]interface{}{}, map[string]interface{}{}},
+		},
+		{
+			name:    "AMF3 negative integer",
+			payload: []byte{0x11, 0x04, 0xFF, 0xFF, 0xFF, 0xFF},
+			want:    []interface{}{-1.0},
+		},
+		{
+			// The strict array gets its reference index before the object inside it
+			name:    "references after a strict array",
+			payload: []byte{0x0A, 0, 0, 0, 1, 0x03, 0, 0, 0x09, 0x07, 0, 0, 0x07, 0, 1},
+			want: []interface{}{
+				[]interface{}{map[string]interface{}{}},
+				[]interface{}{map[string]interface{}{}},
+				map[string]interface{}{},
+			},
+		},
+		{
+			name: "AMF3 string reference",
+			// Dense array of an inline string and a reference to it
+			payload: []byte{0x11, 0x09, 0x05, 0x01, 0x06, 0x05, 'h', 'i', 0x06, 0x00},
+			want:    []interface{}{[]interface{}{"hi", "hi"}},
+		},
+		{
+			name: "AMF3 object",
+			// Dynamic anonymous object with one sealed member "a" and a dynamic "b"
+			payload: []byte{0x11, 0x0A, 0x1B, 0x01, 0x03, 'a', 0x03, 0x03, 'b', 0x02, 0x01},
+			want:    []interface{}{map[string]interface{}{"a": true, "b": false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeAMF0Values(tt.payload)
+			if err != nil {
+				t.Fatalf("decodeAMF0Values() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeAMF0Values() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAMF0ValuesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    error
+	}{
+		{"truncated number", []byte{0x00, 0x3F, 0xF0}, ErrAMFTruncated},
+		{"truncated string", []byte{0x02, 0, 5, 'h'}, ErrAMFTruncated},
+		{"strict array longer than the payload", []byte{0x0A, 0xFF, 0xFF, 0xFF, 0xFF}, ErrAMFTruncated},
+		{"object referencing itself", []byte{0x03, 0, 1, 'a', 0x07, 0, 0, 0, 0, 0x09}, ErrAMFCircularReference},
+		{"ECMA array referencing itself", []byte{0x08, 0, 0, 0, 1, 0, 1, 'a', 0x07, 0, 0, 0, 0, 0x09}, ErrAMFCircularReference},
+		{"strict array referencing itself", []byte{0x0A, 0, 0, 0, 1, 0x07, 0, 0}, ErrAMFCircularReference},
+		// Dynamic anonymous object with a dynamic member "a" referencing the object
+		{"AMF3 object referencing itself", []byte{0x11, 0x0A, 0x0B, 0x01, 0x03, 'a', 0x0A, 0x00, 0x01}, ErrAMFCircularReference},
+		{"AMF3 array referencing itself", []byte{0x11, 0x09, 0x03, 0x01, 0x09, 0x00}, ErrAMFCircularReference},
+		// Dictionary with a single entry whose key is the dictionary
+		{"AMF3 dictionary key referencing itself", []byte{0x11, 0x11, 0x03, 0x00, 0x11, 0x00, 0x01}, ErrAMFCircularReference},
+		{"nested objects", nested([]byte{0x03, 0, 1, 'a'}, maxAMFDepth+1), ErrAMFTooDeep},
+		{"nested strict arrays", append(nested([]byte{0x0A, 0, 0, 0, 1}, maxAMFDepth+1), 0x05), ErrAMFTooDeep},
+		{"nested AMF3 arrays", append([]byte{0x11}, nested([]byte{0x09, 0x03, 0x01}, maxAMFDepth+1)...), ErrAMFTooDeep},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeAMF0Values(tt.payload); err != tt.want {
+				t.Errorf("decodeAMF0Values() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAMF0ValuesMaxDepth(t *testing.T) {
+	// Strict arrays of a single element nested to the limit, with a null in the innermost one
+	payload := append(nested([]byte{0x0A, 0, 0, 0, 1}, maxAMFDepth), 0x05)
+	if _, err := decodeAMF0Values(payload); err != nil {
+		t.Errorf("decodeAMF0Values() error = %v", err)
+	}
+}
+
+// nested repeats the header of a container count times.
+func nested(header []byte, count int) []byte {
+	return bytes.Repeat(header, count)
+}
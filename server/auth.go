@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var ErrRejected = errors.New("session: rejected by the authenticator")
+
+// AuthAction is the command an Authenticator is asked about.
+type AuthAction int
+
+const (
+	AuthConnect AuthAction = iota
+	AuthPublish
+)
+
+func (a AuthAction) String() string {
+	switch a {
+	case AuthConnect:
+		return "connect"
+	case AuthPublish:
+		return "publish"
+	default:
+		return "unknown"
+	}
+}
+
+// AuthRequest describes the client asking for an AuthAction.
+type AuthRequest struct {
+	Action AuthAction
+	App    string
+	TcURL  string
+	// StreamKey is empty for AuthConnect
+	StreamKey string
+	// Query contains the query parameters of the tcUrl (or the app) and the stream name.
+	// Tokens are usually passed like rtmp://host/app?token=x or as a stream name like key?token=x.
+	Query      url.Values
+	RemoteAddr net.Addr
+}
+
+// Authenticator decides whether a client could connect to an app or publish a stream.
+// It is called from the goroutine reading the session, like the Handler.
+type Authenticator interface {
+	Authenticate(req *AuthRequest) bool
+}
+
+// AuthenticatorFunc is an adapter to use a function as an Authenticator.
+type AuthenticatorFunc func(req *AuthRequest) bool
+
+// Authenticate calls f(req).
+func (f AuthenticatorFunc) Authenticate(req *AuthRequest) bool {
+	return f(req)
+}
+
+// WithAuthenticator sets the Authenticator of the connect and publish commands.
+// Without it every client is allowed.
+func WithAuthenticator(a Authenticator) Option {
+	return func(s *Server) {
+		s.authenticator = a
+	}
+}
+
+// splitQuery splits a name like "key?token=x" into the name and its query parameters.
+func splitQuery(name string) (string, url.Values) {
+	i := strings.IndexByte(name, '?')
+	if i < 0 {
+		return name, url.Values{}
+	}
+	query, _ := url.ParseQuery(name[i+1:])
+	return name[:i], query
+}
+
+// authenticate asks the authenticator of the server, if there is one.
+func (s *Session) authenticate(action AuthAction, streamKey string, streamQuery url.Values) bool {
+	if s.server.authenticator == nil {
+		return true
+	}
+	query := url.Values{}
+	for k, v := range s.query {
+		query[k] = append(query[k], v...)
+	}
+	for k, v := range streamQuery {
+		query[k] = append(query[k], v...)
+	}
+	allowed := s.server.authenticator.Authenticate(&AuthRequest{
+		Action:     action,
+		App:        s.app,
+		TcURL:      s.tcURL,
+		StreamKey:  streamKey,
+		Query:      query,
+		RemoteAddr: s.RemoteAddr(),
+	})
+	if !allowed {
+		s.logln("closing connection from", s.RemoteAddr(), "reason:", action, "rejected")
+	}
+	return allowed
+}
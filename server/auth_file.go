@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileKeyStore is an Authenticator allowing the stream keys listed in a file.
+// Every line of the file has an app and a stream key separated by whitespace, "*" as the app matches any app.
+// Empty lines and lines starting with # are ignored:
+//
+//	# app  stream key
+//	live   secret-key-1
+//	*      admin-key
+//
+// Clients could connect to the apps present in the file, and publish the keys listed for the app.
+// The file is loaded again when its modification time changes, so keys could be added without a restart.
+type FileKeyStore struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	// Stream keys by app
+	keys map[string]map[string]struct{}
+}
+
+// NewFileKeyStore loads the stream keys from the file.
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	ks := &FileKeyStore{path: path}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Reload reads the file again.
+func (ks *FileKeyStore) Reload() error {
+	f, err := os.Open(ks.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if keys[fields[0]] == nil {
+			keys[fields[0]] = make(map[string]struct{})
+		}
+		keys[fields[0]][fields[1]] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.modTime = info.ModTime()
+	ks.mu.Unlock()
+	return nil
+}
+
+// reloadIfModified reloads the file if it changed since the last load. On errors the old keys are kept.
+func (ks *FileKeyStore) reloadIfModified() {
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return
+	}
+	ks.mu.Lock()
+	modified := !info.ModTime().Equal(ks.modTime)
+	ks.mu.Unlock()
+	if modified {
+		_ = ks.Reload()
+	}
+}
+
+// Authenticate implements Authenticator.
+func (ks *FileKeyStore) Authenticate(req *AuthRequest) bool {
+	ks.reloadIfModified()
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	switch req.Action {
+	case AuthConnect:
+		_, app := ks.keys[req.App]
+		_, wildcard := ks.keys["*"]
+		return app || wildcard
+	case AuthPublish:
+		if _, ok := ks.keys[req.App][req.StreamKey]; ok {
+			return true
+		}
+		_, ok := ks.keys["*"][req.StreamKey]
+		return ok
+	}
+	return false
+}
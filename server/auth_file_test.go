@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeKeyFile(t *testing.T, path string, content string, modTime time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeKeyFile(t, path, "# app  stream key\n\nlive   secret-key-1\n  live\tsecret-key-2  \n*      admin-key\n#live commented\nbroken\n",
+		time.Unix(1000, 0))
+	ks, err := NewFileKeyStore(path)
+	if err != nil {
+		t.Fatalf("NewFileKeyStore() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  AuthRequest
+		want bool
+	}{
+		{"connect to an app", AuthRequest{Action: AuthConnect, App: "live"}, true},
+		{"connect to any app with a wildcard", AuthRequest{Action: AuthConnect, App: "other"}, true},
+		{"publish a key", AuthRequest{Action: AuthPublish, App: "live", StreamKey: "secret-key-1"}, true},
+		{"publish a key with whitespace around", AuthRequest{Action: AuthPublish, App: "live", StreamKey: "secret-key-2"}, true},
+		{"publish a wildcard key", AuthRequest{Action: AuthPublish, App: "other", StreamKey: "admin-key"}, true},
+		{"publish the key of another app", AuthRequest{Action: AuthPublish, App: "other", StreamKey: "secret-key-1"}, false},
+		{"publish a commented key", AuthRequest{Action: AuthPublish, App: "live", StreamKey: "commented"}, false},
+		{"publish an unknown key", AuthRequest{Action: AuthPublish, App: "live", StreamKey: "wrong"}, false},
+		{"publish an empty key", AuthRequest{Action: AuthPublish, App: "live"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ks.Authenticate(&tt.req); got != tt.want {
+				t.Errorf("Authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileKeyStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeKeyFile(t, path, "live key-1\n", time.Unix(1000, 0))
+	ks, err := NewFileKeyStore(path)
+	if err != nil {
+		t.Fatalf("NewFileKeyStore() error = %v", err)
+	}
+	publish := func(key string) bool {
+		return ks.Authenticate(&AuthRequest{Action: AuthPublish, App: "live", StreamKey: key})
+	}
+
+	// The file is loaded again when it is modified
+	writeKeyFile(t, path, "live key-2\n", time.Unix(2000, 0))
+	if publish("key-1") || !publish("key-2") {
+		t.Errorf("the modified file isn't loaded: key-1 %v, key-2 %v", publish("key-1"), publish("key-2"))
+	}
+	if ks.Authenticate(&AuthRequest{Action: AuthConnect, App: "other"}) {
+		t.Error("connect to an app missing from the file is allowed")
+	}
+
+	// The old keys are kept if the file is missing
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if !publish("key-2") {
+		t.Error("the keys are lost with the file")
+	}
+}
+
+func TestNewFileKeyStoreMissing(t *testing.T) {
+	if _, err := NewFileKeyStore(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("NewFileKeyStore() error = %v, want a missing file", err)
+	}
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Limits of the partially received messages of a connection. Messages are buffered as their chunks arrive,
+// the declared lengths (up to 16 MB on every chunk stream) are not trusted for allocating them.
+const (
+	maxPartialChunkStreams = 64
+	maxBufferedBytes       = 2 * maxMessageLength
+)
+
+var (
+	ErrUnknownChunkStream  = errors.New("chunk reader: compressed chunk header on a chunk stream without a previous header")
+	ErrTooManyChunkStreams = errors.New("chunk reader: too many chunk streams with a partial message")
+	ErrChunkBufferFull     = errors.New("chunk reader: too many bytes of partial messages")
+)
+
+// ChunkReader reads chunks and assembles them into messages. Chunks of different chunk streams
+// could be interleaved, every chunk stream assembles its own message.
+type ChunkReader struct {
+	r         *bufio.Reader
+	chunkSize uint32
+	// The key is the chunk stream ID, and the value is the state of the message being received on it
+	streams map[uint32]*chunkStreamState
+	// OnProtocolViolation is called for recoverable protocol errors of the peer, nil ignores them
+	OnProtocolViolation func(err error)
+	// Last unwrapped timestamp on any of the chunk streams, new chunk streams continue from it
+	lastTimestamp uint64
+	// Number of chunk streams with a partial message, and the bytes received of those messages
+	partialStreams int
+	bufferedBytes  int
+
+	header [11]byte
+}
+
+type chunkStreamState struct {
+	// timestamp is the absolute timestamp of the current message as sent on the wire, it wraps around at 32 bits
+	timestamp uint32
+	// timestamp64 is the same timestamp unwrapped into 64 bits
+	timestamp64 uint64
+	delta       uint32
+	length      uint32
+	typeID      uint8
+	streamID    uint32
+	// extended is true if the last type 0, 1 or 2 header had an extended timestamp, type 3 headers carry it too then
+	extended      bool
+	extendedValue uint32
+	// payload of the message being assembled, empty between messages
+	payload []byte
+}
+
+// NewChunkReader creates a ChunkReader with the default chunk size.
+func NewChunkReader(r *bufio.Reader) *ChunkReader {
+	return &ChunkReader{
+		r:         r,
+		chunkSize: DefaultChunkSize,
+		streams:   make(map[uint32]*chunkStreamState),
+	}
+}
+
+// ChunkSize returns the maximum size of the incoming chunk payloads.
+func (cr *ChunkReader) ChunkSize() uint32 {
+	return cr.chunkSize
+}
+
+// SetChunkSize applies a Set Chunk Size message of the peer.
+func (cr *ChunkReader) SetChunkSize(size uint32) {
+	cr.chunkSize = size
+}
+
+// Abort discards the partially received message of the chunk stream.
+// It reports whether there was anything to discard.
+func (cr *ChunkReader) Abort(csID uint32) bool {
+	st, ok := cr.streams[csID]
+	if !ok || len(st.payload) == 0 {
+		return false
+	}
+	cr.drop(st)
+	return true
+}
+
+// drop discards the partial message of the chunk stream.
+func (cr *ChunkReader) drop(st *chunkStreamState) {
+	if len(st.payload) > 0 {
+		cr.partialStreams--
+		cr.bufferedBytes -= len(st.payload)
+	}
+	st.payload = nil
+}
+
+func (cr *ChunkReader) violation(format string, v ...interface{}) {
+	if cr.OnProtocolViolation != nil {
+		cr.OnProtocolViolation(fmt.Errorf("chunk reader: "+format, v...))
+	}
+}
+
+// ReadMessage reads chunks until a complete message is assembled on any of the chunk streams.
+func (cr *ChunkReader) ReadMessage() (*Message, error) {
+	for {
+		chunkType, csID, err := cr.readBasicHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		st, ok := cr.streams[csID]
+		if !ok {
+			if chunkType != chunkType0 {
+				return nil, ErrUnknownChunkStream
+			}
+			st = &chunkStreamState{timestamp64: cr.lastTimestamp}
+			cr.streams[csID] = st
+		}
+
+		if err := cr.readMessageHeader(chunkType, st); err != nil {
+			return nil, err
+		}
+
+		size := st.length - uint32(len(st.payload))
+		if size > cr.chunkSize {
+			size = cr.chunkSize
+		}
+		start := len(st.payload)
+		if start == 0 && size > 0 {
+			if cr.partialStreams >= maxPartialChunkStreams {
+				return nil, ErrTooManyChunkStreams
+			}
+			cr.partialStreams++
+		}
+		if cr.bufferedBytes+int(size) > maxBufferedBytes {
+			return nil, ErrChunkBufferFull
+		}
+		// The buffer grows with the received chunks
+		st.payload = append(st.payload, make([]byte, size)...)
+		cr.bufferedBytes += int(size)
+		if _, err := io.ReadFull(cr.r, st.payload[start:]); err != nil {
+			return nil, err
+		}
+
+		if uint32(len(st.payload)) == st.length {
+			m := &Message{
+				ChunkStreamID: csID,
+				TypeID:        st.typeID,
+				StreamID:      st.streamID,
+				Timestamp:     st.timestamp,
+				Payload:       st.payload,
+
+				AbsoluteTimestamp: st.timestamp64,
+			}
+			cr.drop(st)
+			return m, nil
+		}
+	}
+}
+
+func (cr *ChunkReader) readBasicHeader() (uint8, uint32, error) {
+	b, err := cr.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	// Extract chunk type (FMT field) from the 2 highest bits, the chunk stream ID is in the lowest 6 bits
+	chunkType := b >> 6
+	csID := uint32(b & 0x3F)
+
+	switch csID {
+	case 0:
+		// 2 byte form, chunk stream IDs 64-319
+		id, err := cr.r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		csID = uint32(id) + 64
+	case 1:
+		// 3 byte form, chunk stream IDs 64-65599, the ID is stored in little endian
+		var id [2]byte
+		if _, err := io.ReadFull(cr.r, id[:]); err != nil {
+			return 0, 0, err
+		}
+		csID = uint32(id[1])*256 + uint32(id[0]) + 64
+	}
+	return chunkType, csID, nil
+}
+
+func (cr *ChunkReader) readMessageHeader(chunkType uint8, st *chunkStreamState) error {
+	newMessage := len(st.payload) == 0
+	if !newMessage && chunkType != chunkType3 {
+		cr.violation("new message header while a message of %d/%d bytes was assembled, dropping it", len(st.payload), st.length)
+		cr.drop(st)
+		newMessage = true
+	}
+
+	var headerSize int
+	switch chunkType {
+	case chunkType0:
+		headerSize = 11
+	case chunkType1:
+		headerSize = 7
+	case chunkType2:
+		headerSize = 3
+	}
+	h := cr.header[:headerSize]
+	if _, err := io.ReadFull(cr.r, h); err != nil {
+		return err
+	}
+
+	var timestampField uint32
+	if chunkType != chunkType3 {
+		timestampField = uint24(h)
+		st.extended = timestampField == extendedTimestamp
+	}
+	if chunkType == chunkType0 || chunkType == chunkType1 {
+		st.length = uint24(h[3:])
+		st.typeID = h[6]
+	}
+	if chunkType == chunkType0 {
+		// Message stream ID is the only little endian field of the header
+		st.streamID = binary.LittleEndian.Uint32(h[7:])
+	}
+
+	if st.extended {
+		if chunkType == chunkType3 {
+			// Type 3 chunks should repeat the extended timestamp, but some encoders leave it out.
+			// If the next 4 bytes are not the same value, they belong to the payload already.
+			ext, err := cr.r.Peek(4)
+			if err != nil {
+				return err
+			}
+			if binary.BigEndian.Uint32(ext) == st.extendedValue {
+				_, _ = cr.r.Discard(4)
+			}
+		} else {
+			var ext [4]byte
+			if _, err := io.ReadFull(cr.r, ext[:]); err != nil {
+				return err
+			}
+			timestampField = binary.BigEndian.Uint32(ext[:])
+			st.extendedValue = timestampField
+		}
+	}
+
+	if !newMessage {
+		// Continuation of a message, the header doesn't change anything
+		return nil
+	}
+
+	switch chunkType {
+	case chunkType0:
+		st.timestamp = timestampField
+		// A type 3 header after a type 0 one uses the absolute timestamp as delta
+		st.delta = timestampField
+	case chunkType1, chunkType2:
+		st.delta = timestampField
+		st.timestamp += st.delta
+	case chunkType3:
+		st.timestamp += st.delta
+	}
+	st.timestamp64 = unwrapTimestamp(st.timestamp64, st.timestamp)
+	cr.lastTimestamp = st.timestamp64
+	return nil
+}
+
+// unwrapTimestamp extends a 32 bit timestamp (milliseconds wrap around about every 49.7 days) into 64 bits.
+// It picks the value closest to the previous timestamp, so small jumps backwards are kept as they are,
+// while going from near 0xFFFFFFFF to near 0 moves into the next 32 bit epoch.
+func unwrapTimestamp(prev uint64, timestamp uint32) uint64 {
+	candidate := prev&^0xFFFFFFFF | uint64(timestamp)
+	if candidate < prev && prev-candidate > 1<<31 {
+		return candidate + 1<<32
+	}
+	if candidate > prev && candidate-prev > 1<<31 && candidate >= 1<<32 {
+		return candidate - 1<<32
+	}
+	return candidate
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
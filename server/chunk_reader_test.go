@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// chunkHeader0 returns a basic header and a type 0 message header with a 1 byte chunk stream ID.
+func chunkHeader0(csID byte, timestamp uint32, length uint32, typeID uint8, streamID uint32) []byte {
+	return []byte{
+		chunkType0<<6 | csID,
+		byte(timestamp >> 16), byte(timestamp >> 8), byte(timestamp),
+		byte(length >> 16), byte(length >> 8), byte(length),
+		typeID,
+		byte(streamID), byte(streamID >> 8), byte(streamID >> 16), byte(streamID >> 24),
+	}
+}
+
+func TestChunkReaderInterleaved(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xA}, 200)
+	video := bytes.Repeat([]byte{0xB}, 150)
+
+	var b bytes.Buffer
+	b.Write(chunkHeader0(4, 10, uint32(len(audio)), TypeAudio, 1))
+	b.Write(audio[:DefaultChunkSize])
+	b.Write(chunkHeader0(6, 20, uint32(len(video)), TypeVideo, 1))
+	b.Write(video[:DefaultChunkSize])
+	b.WriteByte(chunkType3<<6 | 4)
+	b.Write(audio[DefaultChunkSize:])
+	b.WriteByte(chunkType3<<6 | 6)
+	b.Write(video[DefaultChunkSize:])
+
+	cr := NewChunkReader(bufio.NewReader(&b))
+	for _, want := range []struct {
+		typeID    uint8
+		timestamp uint32
+		payload   []byte
+	}{
+		{TypeAudio, 10, audio},
+		{TypeVideo, 20, video},
+	} {
+		m, err := cr.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		if m.TypeID != want.typeID || m.Timestamp != want.timestamp || !bytes.Equal(m.Payload, want.payload) {
+			t.Errorf("ReadMessage() = type %d, timestamp %d, %d bytes, want type %d, timestamp %d, %d bytes",
+				m.TypeID, m.Timestamp, len(m.Payload), want.typeID, want.timestamp, len(want.payload))
+		}
+	}
+	if cr.partialStreams != 0 || cr.bufferedBytes != 0 {
+		t.Errorf("partial streams %d, buffered bytes %d after the complete messages", cr.partialStreams, cr.bufferedBytes)
+	}
+}
+
+func TestChunkReaderTooManyPartialMessages(t *testing.T) {
+	// Every chunk stream declares a message of the maximum length, but sends a single chunk of it only
+	var b bytes.Buffer
+	for i := 0; i <= maxPartialChunkStreams; i++ {
+		csID := uint32(64 + i)
+		b.Write([]byte{chunkType0<<6 | 1, byte(csID - 64), byte((csID - 64) >> 8)})
+		b.Write(chunkHeader0(0, 0, maxMessageLength, TypeVideo, 1)[1:])
+		b.Write(make([]byte, DefaultChunkSize))
+	}
+
+	cr := NewChunkReader(bufio.NewReader(&b))
+	if _, err := cr.ReadMessage(); err != ErrTooManyChunkStreams {
+		t.Errorf("ReadMessage() error = %v, want %v", err, ErrTooManyChunkStreams)
+	}
+	if want := maxPartialChunkStreams * DefaultChunkSize; cr.bufferedBytes != want {
+		t.Errorf("buffered bytes = %d, want %d", cr.bufferedBytes, want)
+	}
+}
+
+func TestChunkReaderAbort(t *testing.T) {
+	var b bytes.Buffer
+	b.Write(chunkHeader0(4, 0, 1000, TypeAudio, 1))
+	b.Write(make([]byte, DefaultChunkSize))
+
+	cr := NewChunkReader(bufio.NewReader(&b))
+	if _, err := cr.ReadMessage(); err == nil {
+		t.Fatal("ReadMessage() returned a partial message")
+	}
+	if !cr.Abort(4) {
+		t.Fatal("Abort() found no partial message")
+	}
+	if cr.partialStreams != 0 || cr.bufferedBytes != 0 {
+		t.Errorf("partial streams %d, buffered bytes %d after Abort", cr.partialStreams, cr.bufferedBytes)
+	}
+}
+
+func TestUnwrapTimestamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev      uint64
+		timestamp uint32
+		want      uint64
+	}{
+		{"start", 0, 0, 0},
+		{"forward", 1000, 1040, 1040},
+		{"small jump backwards", 1000, 990, 990},
+		{"jump backwards near 0", 10, 0xFFFFFFF0, 0xFFFFFFF0},
+		{"wrap around", 0xFFFFFFF0, 0x10, 1<<32 + 0x10},
+		{"wrap around in a later epoch", 2<<32 + 0xFFFFFF00, 5, 3<<32 + 5},
+		{"backwards over the epoch boundary", 1<<32 + 5, 0xFFFFFFF0, 0xFFFFFFF0},
+		{"forward in a later epoch", 1<<32 + 5, 100, 1<<32 + 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unwrapTimestamp(tt.prev, tt.timestamp); got != tt.want {
+				t.Errorf("unwrapTimestamp(%#x, %#x) = %#x, want %#x", tt.prev, tt.timestamp, got, tt.want)
+			}
+		})
+	}
+}
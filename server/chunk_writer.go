@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// DefaultChunkSize is the chunk size both sides use until a Set Chunk Size message says otherwise.
+const DefaultChunkSize = 128
+
+// Chunk header types, each one is shorter and reuses more fields of the previous header on the same chunk stream
+const (
+	chunkType0 uint8 = 0
+	chunkType1 uint8 = 1
+	chunkType2 uint8 = 2
+	chunkType3 uint8 = 3
+)
+
+// Limits of the chunk format
+const (
+	maxChunkSize      = 0x7FFFFFFF
+	maxMessageLength  = 0xFFFFFF
+	maxChunkStreamID  = 65599
+	extendedTimestamp = 0xFFFFFF
+)
+
+var (
+	ErrInvalidChunkStreamID = errors.New("chunk writer: chunk stream id must be between 2 and 65599")
+	ErrMessageTooLong       = errors.New("chunk writer: message is longer than 16777215 bytes")
+)
+
+// Message is a complete RTMP message before it is split into chunks (or after the chunks were assembled).
+type Message struct {
+	// ChunkStreamID is the chunk stream the message is sent on
+	ChunkStreamID uint32
+	TypeID        uint8
+	// StreamID is the message stream ID, 0 is the NetConnection, the others are NetStreams
+	StreamID uint32
+	// Timestamp is the absolute timestamp in milliseconds as sent on the wire, it wraps around at 32 bits
+	Timestamp uint32
+	Payload   []byte
+
+	// AbsoluteTimestamp is the timestamp unwrapped into 64 bits, it is only filled by the ChunkReader
+	AbsoluteTimestamp uint64
+}
+
+// ChunkWriter serializes messages into chunks, compressing the chunk headers based on
+// the previous message sent on the same chunk stream.
+// It writes to the underlying writer directly, flushing (if needed) is up to the caller.
+type ChunkWriter struct {
+	w         io.Writer
+	chunkSize uint32
+	// The key is the chunk stream ID, and the value is the header of the last message sent on it
+	prev map[uint32]*chunkStreamHeader
+	// Reused buffer for the chunk headers (3 bytes basic header + 11 bytes message header + 4 bytes extended timestamp)
+	header [18]byte
+}
+
+type chunkStreamHeader struct {
+	timestamp uint32
+	length    uint32
+	typeID    uint8
+	streamID  uint32
+	// delta is only valid if it was sent explicitly (in a type 1 or 2 header), type 3 headers for new messages reuse it
+	delta      uint32
+	deltaValid bool
+	// extended is true if the last type 0, 1 or 2 header needed an extended timestamp, type 3 headers have to repeat it then
+	extended      bool
+	extendedValue uint32
+}
+
+// NewChunkWriter creates a ChunkWriter with the default chunk size.
+func NewChunkWriter(w io.Writer) *ChunkWriter {
+	return &ChunkWriter{
+		w:         w,
+		chunkSize: DefaultChunkSize,
+		prev:      make(map[uint32]*chunkStreamHeader),
+	}
+}
+
+// ChunkSize returns the maximum size of the chunk payloads.
+func (cw *ChunkWriter) ChunkSize() uint32 {
+	return cw.chunkSize
+}
+
+// SetChunkSize changes the maximum size of the chunk payloads.
+// The peer has to be notified with a Set Chunk Size message before it is applied.
+func (cw *ChunkWriter) SetChunkSize(size uint32) {
+	if size < 1 {
+		size = 1
+	} else if size > maxChunkSize {
+		size = maxChunkSize
+	}
+	cw.chunkSize = size
+}
+
+// WriteMessage splits the message into chunks and writes them.
+func (cw *ChunkWriter) WriteMessage(m *Message) error {
+	if m.ChunkStreamID < 2 || m.ChunkStreamID > maxChunkStreamID {
+		return ErrInvalidChunkStreamID
+	}
+	length := uint32(len(m.Payload))
+	if length > maxMessageLength {
+		return ErrMessageTooLong
+	}
+
+	prev, ok := cw.prev[m.ChunkStreamID]
+	if !ok {
+		prev = &chunkStreamHeader{}
+		cw.prev[m.ChunkStreamID] = prev
+	}
+
+	// Pick the most compact header type the receiver could reconstruct the message header from
+	var chunkType uint8
+	var timestampField uint32
+	switch {
+	case !ok || m.StreamID != prev.streamID || m.Timestamp < prev.timestamp:
+		// First message on the chunk stream, the stream ID changed, or time went backwards: absolute timestamp
+		chunkType = chunkType0
+		timestampField = m.Timestamp
+		prev.deltaValid = false
+	case length != prev.length || m.TypeID != prev.typeID:
+		chunkType = chunkType1
+		timestampField = m.Timestamp - prev.timestamp
+		prev.delta, prev.deltaValid = timestampField, true
+	case !prev.deltaValid || m.Timestamp-prev.timestamp != prev.delta:
+		chunkType = chunkType2
+		timestampField = m.Timestamp - prev.timestamp
+		prev.delta, prev.deltaValid = timestampField, true
+	default:
+		chunkType = chunkType3
+	}
+
+	if chunkType != chunkType3 {
+		prev.extended = timestampField >= extendedTimestamp
+		prev.extendedValue = timestampField
+	}
+	prev.timestamp = m.Timestamp
+	prev.length = length
+	prev.typeID = m.TypeID
+	prev.streamID = m.StreamID
+
+	n := cw.putHeader(chunkType, m, timestampField, prev)
+	if _, err := cw.w.Write(cw.header[:n]); err != nil {
+		return err
+	}
+
+	payload := m.Payload
+	for {
+		size := uint32(len(payload))
+		if size > cw.chunkSize {
+			size = cw.chunkSize
+		}
+		if _, err := cw.w.Write(payload[:size]); err != nil {
+			return err
+		}
+		payload = payload[size:]
+		if len(payload) == 0 {
+			return nil
+		}
+
+		// Continuation chunks only have a type 3 header
+		n = cw.putHeader(chunkType3, m, 0, prev)
+		if _, err := cw.w.Write(cw.header[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// putHeader serializes a chunk header into cw.header and returns its length.
+func (cw *ChunkWriter) putHeader(chunkType uint8, m *Message, timestampField uint32, prev *chunkStreamHeader) int {
+	h := cw.header[:]
+	n := putBasicHeader(h, chunkType, m.ChunkStreamID)
+
+	if chunkType != chunkType3 {
+		field := timestampField
+		if prev.extended {
+			field = extendedTimestamp
+		}
+		putUint24(h[n:], field)
+		n += 3
+	}
+	if chunkType == chunkType0 || chunkType == chunkType1 {
+		putUint24(h[n:], uint32(len(m.Payload)))
+		h[n+3] = m.TypeID
+		n += 4
+	}
+	if chunkType == chunkType0 {
+		// Message stream ID is the only little endian field of the header
+		binary.LittleEndian.PutUint32(h[n:], m.StreamID)
+		n += 4
+	}
+
+	// Type 3 headers repeat the extended timestamp of the previous header
+	if prev.extended {
+		binary.BigEndian.PutUint32(h[n:], prev.extendedValue)
+		n += 4
+	}
+	return n
+}
+
+// putBasicHeader writes the 1, 2 or 3 byte basic header, depending on the size of the chunk stream ID.
+func putBasicHeader(b []byte, chunkType uint8, csID uint32) int {
+	switch {
+	case csID < 64:
+		b[0] = chunkType<<6 | byte(csID)
+		return 1
+	case csID < 320:
+		b[0] = chunkType << 6
+		b[1] = byte(csID - 64)
+		return 2
+	default:
+		b[0] = chunkType<<6 | 1
+		b[1] = byte((csID - 64) & 0xFF)
+		b[2] = byte((csID - 64) >> 8)
+		return 3
+	}
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
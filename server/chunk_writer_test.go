@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// concatBytes joins the byte slices.
+func concatBytes(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestChunkWriterHeaders(t *testing.T) {
+	payload := []byte{1, 2, 3}
+	tests := []struct {
+		name     string
+		messages []*Message
+		want     []byte
+	}{
+		{
+			name: "compressed headers",
+			messages: []*Message{
+				{ChunkStreamID: 4, TypeID: TypeAudio, StreamID: 1, Timestamp: 100, Payload: payload},
+				// Another length: type 1
+				{ChunkStreamID: 4, TypeID: TypeAudio, StreamID: 1, Timestamp: 120, Payload: payload[:2]},
+				// Another delta: type 2
+				{ChunkStreamID: 4, TypeID: TypeAudio, StreamID: 1, Timestamp: 150, Payload: payload[:2]},
+				// The same delta: type 3
+				{ChunkStreamID: 4, TypeID: TypeAudio, StreamID: 1, Timestamp: 180, Payload: payload[:2]},
+				// Time going backwards: type 0
+				{ChunkStreamID: 4, TypeID: TypeAudio, StreamID: 1, Timestamp: 10, Payload: payload[:2]},
+				// The delta of a type 0 header isn't reused: type 2
+				{ChunkStreamID: 4, TypeID: TypeAudio, StreamID: 1, Timestamp: 20, Payload: payload[:2]},
+			},
+			want: concatBytes(
+				chunkHeader0(4, 100, 3, TypeAudio, 1), payload,
+				[]byte{chunkType1<<6 | 4, 0, 0, 20, 0, 0, 2, TypeAudio}, payload[:2],
+				[]byte{chunkType2<<6 | 4, 0, 0, 30}, payload[:2],
+				[]byte{chunkType3<<6 | 4}, payload[:2],
+				chunkHeader0(4, 10, 2, TypeAudio, 1), payload[:2],
+				[]byte{chunkType2<<6 | 4, 0, 0, 10}, payload[:2],
+			),
+		},
+		{
+			name: "another message stream",
+			messages: []*Message{
+				{ChunkStreamID: 4, TypeID: TypeVideo, StreamID: 1, Timestamp: 100, Payload: payload},
+				{ChunkStreamID: 4, TypeID: TypeVideo, StreamID: 2, Timestamp: 100, Payload: payload},
+			},
+			want: concatBytes(
+				chunkHeader0(4, 100, 3, TypeVideo, 1), payload,
+				chunkHeader0(4, 100, 3, TypeVideo, 2), payload,
+			),
+		},
+		{
+			name: "2 and 3 byte basic headers",
+			messages: []*Message{
+				{ChunkStreamID: 64, TypeID: TypeVideo, StreamID: 1, Payload: payload},
+				{ChunkStreamID: 320, TypeID: TypeVideo, StreamID: 1, Payload: payload},
+				{ChunkStreamID: 320, TypeID: TypeVideo, StreamID: 1, Timestamp: 40, Payload: payload},
+			},
+			want: concatBytes(
+				[]byte{chunkType0 << 6, 0}, chunkHeader0(0, 0, 3, TypeVideo, 1)[1:], payload,
+				[]byte{chunkType0<<6 | 1, 0, 1}, chunkHeader0(0, 0, 3, TypeVideo, 1)[1:], payload,
+				[]byte{chunkType2<<6 | 1, 0, 1, 0, 0, 40}, payload,
+			),
+		},
+		{
+			name: "extended timestamps",
+			messages: []*Message{
+				{ChunkStreamID: 6, TypeID: TypeVideo, StreamID: 1, Timestamp: 0x1000000, Payload: payload},
+				// The extended delta is repeated by the type 3 header
+				{ChunkStreamID: 6, TypeID: TypeVideo, StreamID: 1, Timestamp: 0x2000000, Payload: payload},
+				{ChunkStreamID: 6, TypeID: TypeVideo, StreamID: 1, Timestamp: 0x3000000, Payload: payload},
+				// A small delta again
+				{ChunkStreamID: 6, TypeID: TypeVideo, StreamID: 1, Timestamp: 0x3000010, Payload: payload},
+			},
+			want: concatBytes(
+				chunkHeader0(6, extendedTimestamp, 3, TypeVideo, 1), []byte{1, 0, 0, 0}, payload,
+				[]byte{chunkType2<<6 | 6, 0xFF, 0xFF, 0xFF, 1, 0, 0, 0}, payload,
+				[]byte{chunkType3<<6 | 6, 1, 0, 0, 0}, payload,
+				[]byte{chunkType2<<6 | 6, 0, 0, 0x10}, payload,
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			cw := NewChunkWriter(&b)
+			for _, m := range tt.messages {
+				if err := cw.WriteMessage(m); err != nil {
+					t.Fatalf("WriteMessage() error = %v", err)
+				}
+			}
+			if !bytes.Equal(b.Bytes(), tt.want) {
+				t.Errorf("chunks\n% X\nwant\n% X", b.Bytes(), tt.want)
+			}
+
+			// The ChunkReader gets the same messages back
+			cr := NewChunkReader(bufio.NewReader(&b))
+			for i, want := range tt.messages {
+				m, err := cr.ReadMessage()
+				if err != nil {
+					t.Fatalf("ReadMessage() %d error = %v", i, err)
+				}
+				if m.ChunkStreamID != want.ChunkStreamID || m.TypeID != want.TypeID || m.StreamID != want.StreamID ||
+					m.Timestamp != want.Timestamp || !bytes.Equal(m.Payload, want.Payload) {
+					t.Errorf("ReadMessage() %d = %+v, want %+v", i, m, want)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkWriterSplit(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xA}, 10)
+	var b bytes.Buffer
+	cw := NewChunkWriter(&b)
+	cw.SetChunkSize(4)
+	if err := cw.WriteMessage(&Message{ChunkStreamID: 6, TypeID: TypeVideo, StreamID: 1, Timestamp: 0x1000000, Payload: payload}); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	// The continuation chunks repeat the extended timestamp
+	want := concatBytes(
+		chunkHeader0(6, extendedTimestamp, 10, TypeVideo, 1), []byte{1, 0, 0, 0}, payload[:4],
+		[]byte{chunkType3<<6 | 6, 1, 0, 0, 0}, payload[4:8],
+		[]byte{chunkType3<<6 | 6, 1, 0, 0, 0}, payload[8:],
+	)
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("chunks\n% X\nwant\n% X", b.Bytes(), want)
+	}
+}
+
+func TestChunkWriterErrors(t *testing.T) {
+	cw := NewChunkWriter(&bytes.Buffer{})
+	for _, tt := range []struct {
+		name    string
+		m       *Message
+		wantErr error
+	}{
+		{"protocol control chunk stream", &Message{ChunkStreamID: 1}, ErrInvalidChunkStreamID},
+		{"too large chunk stream ID", &Message{ChunkStreamID: maxChunkStreamID + 1}, ErrInvalidChunkStreamID},
+		{"too long message", &Message{ChunkStreamID: 3, Payload: make([]byte, maxMessageLength+1)}, ErrMessageTooLong},
+	} {
+		if err := cw.WriteMessage(tt.m); err != tt.wantErr {
+			t.Errorf("%s: WriteMessage() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
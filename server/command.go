@@ -0,0 +1,236 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/torresjeff/rtmp/amf/amf0"
+)
+
+// handleCommandMessage decodes an AMF0 or AMF3 command message and handles the command.
+// Every command has a name, a transaction ID and a command object (which can be null), followed by the arguments.
+func (s *Session) handleCommandMessage(m *Message) {
+	payload := m.Payload
+	if m.TypeID == TypeCommandAMF3 && len(payload) > 0 {
+		// AMF3 command messages start with a format selector byte, the values are AMF0 with AMF3 values embedded
+		payload = payload[1:]
+	}
+	values, err := decodeAMF0Values(payload)
+	if err != nil {
+		s.logln("amf decode error", err)
+		return
+	}
+	if len(values) < 2 {
+		s.protocolViolation(fmt.Errorf("command message with %d values", len(values)))
+		return
+	}
+	commandName, ok := values[0].(string)
+	if !ok {
+		s.protocolViolation(fmt.Errorf("command name is %T", values[0]))
+		return
+	}
+	transactionID, _ := values[1].(float64)
+
+	var commandObject map[string]interface{}
+	var args []interface{}
+	if len(values) > 2 {
+		switch obj := values[2].(type) {
+		case map[string]interface{}:
+			commandObject = obj
+		case amf0.ECMAArray:
+			commandObject = obj
+		}
+		args = values[3:]
+	}
+
+	s.handleCommand(m.ChunkStreamID, m.StreamID, commandName, transactionID, commandObject, args)
+}
+
+func (s *Session) handleCommand(csID uint32, streamID uint32, commandName string, transactionID float64, commandObject map[string]interface{}, args []interface{}) {
+	s.debugln("tID", transactionID)
+	s.debugln("commandObject", commandObject)
+
+	switch commandName {
+	case "connect":
+		s.debugln("CONNECT")
+		// STEP 1
+		app, _ := commandObject["app"].(string)
+		s.app, s.query = splitQuery(app)
+		s.tcURL, _ = commandObject["tcUrl"].(string)
+		if u, err := url.Parse(s.tcURL); err == nil {
+			for k, v := range u.Query() {
+				s.query[k] = append(s.query[k], v...)
+			}
+		}
+		// Clients using AMF3 expect the responses in AMF3 command messages
+		s.objectEncoding, _ = commandObject["objectEncoding"].(float64)
+		if !s.authenticate(AuthConnect, "", nil) {
+			s.writeCommand(connectResponseRejectedMessage(csID, s.objectEncoding))
+			s.flush()
+			s.closeErr = ErrRejected
+			return
+		}
+		s.connected = true
+		s.connectedAt = time.Now()
+		s.server.handler.OnConnect(s, s.app, commandObject)
+		s.notifyWebhook(s.webhookEvent(WebhookConnect, ""))
+
+		// Initiate connect sequence
+		// As per the specification, after the connect command, the server sends the protocol message Window Acknowledgment Size
+		s.writeMessage(windowAckSizeMessage(s.server.windowAckSize))
+
+		// After sending the window ack size message, the server sends the set peer bandwidth message
+		s.writeMessage(setPeerBandwidthMessage(s.server.windowAckSize, 2))
+
+		// Send the User Control Message to begin stream with stream ID = DefaultPublishStream (which is 0)
+		// Subsequent messages sent by the client will have stream ID = DefaultPublishStream, until another sendBeginStream message is sent
+		s.writeMessage(streamBeginMessage(0))
+
+		// Send Set Chunk Size message, every message after it is split according to the new size
+		s.writeMessage(setChunkSizeMessage(4096))
+		s.chunkWriter.SetChunkSize(4096)
+
+		// Send Connect Success response
+		s.writeCommand(connectResponseSuccessMessage(csID, s.objectEncoding))
+		s.flush()
+
+	case "releaseStream":
+		s.debugln("releaseStream", stringArg(args, 0))
+	case "FCPublish":
+		s.debugln("FCPublish", stringArg(args, 0))
+	case "createStream":
+		s.debugln("CREATE STREAM")
+		// STEP 2
+
+		s.writeCommand(createStreamResponseMessage(csID, transactionID))
+		s.writeMessage(streamBeginMessage(1))
+		s.flush()
+
+	case "publish":
+		// name with which the stream is published (basically the streamKey), optionally with query parameters
+		streamKey, query := splitQuery(stringArg(args, 0))
+		// Publishing type: "live", "record", or "append"
+		// - record: The stream is published and the data is recorded to a new file. The file is stored on the server
+		// in a subdirectory within the directory that contains the server application. If the file already exists, it is overwritten.
+		// - append: The stream is published and the data is appended to a file. If no file is found, it is created.
+		// - live: Live data is published without recording it in a file.
+		publishingType := stringArg(args, 1)
+
+		s.debugln("PUBLISH", streamKey, publishingType)
+
+		// STEP 3
+		if s.publishing {
+			s.unpublish()
+		}
+		if !s.authenticate(AuthPublish, streamKey, query) {
+			s.sendStatusMessage(streamID, "error", "NetStream.Publish.BadName", "Publishing "+streamKey+" is not allowed")
+			s.closeErr = ErrRejected
+			return
+		}
+		st, err := s.server.registry.Publish(s.app, streamKey, s)
+		if err != nil {
+			s.logln("publish rejected from", s.RemoteAddr(), streamKey, err)
+			s.sendStatusMessage(streamID, "error", "NetStream.Publish.BadName", "Stream "+streamKey+" is already publishing")
+			return
+		}
+		event := s.webhookEvent(WebhookPublish, streamKey)
+		event.PublishingType = publishingType
+		if !s.sendWebhook(event) {
+			s.server.registry.Remove(st)
+			st.close()
+			s.sendStatusMessage(streamID, "error", "NetStream.Publish.BadName", "Publishing "+streamKey+" is not allowed")
+			s.closeErr = ErrWebhookRejected
+			return
+		}
+		s.stream = st
+		s.streamKey = streamKey
+		s.publishing = true
+		s.enterPhase(phaseMedia)
+		s.server.handler.OnPublish(s, s.streamKey, publishingType)
+		s.startRecording(st, publishingType)
+		s.server.registry.notifyPublish(st)
+
+		s.sendStatusMessage(streamID, "status", "NetStream.Publish.Start", "Publishing live_user_<x>")
+
+	case "play":
+		streamKey, _ := splitQuery(stringArg(args, 0))
+
+		// Start time in seconds
+		startTime, _ := numberArg(args, 1)
+
+		// the spec specifies that, the next values should be duration (number), and reset (bool), but VLC doesn't send them
+		s.debugln("PLAY", streamKey, startTime)
+		s.play(streamID, streamKey)
+	case "FCUnpublish":
+		s.debugln("FCUnpublish", stringArg(args, 0))
+		if s.publishing {
+			s.unpublish()
+		}
+	case "closeStream":
+		s.debugln("closeStream")
+		if s.publishing {
+			s.unpublish()
+		}
+		if s.playing != nil {
+			s.stopPlaying()
+		}
+	case "deleteStream":
+		streamID, _ := numberArg(args, 0)
+		s.debugln("deleteStream", streamID)
+		if s.publishing {
+			s.unpublish()
+		}
+		if s.playing != nil {
+			s.stopPlaying()
+		}
+	case "_result":
+		s.debugln("RESULT", args)
+	case "onStatus":
+		s.debugln("STATUS", args)
+	default:
+		s.logln("message manager: received command " + commandName + ", but couldn't handle it because no implementation is defined")
+	}
+}
+
+func (s *Session) sendStatusMessage(streamID uint32, level string, code string, description string, optionalDetails ...string) {
+	infoObject := map[string]interface{}{
+		"level":       level,
+		"code":        code,
+		"description": description,
+	}
+	if len(optionalDetails) > 0 && optionalDetails[0] != "" {
+		infoObject["details"] = optionalDetails[0]
+	}
+
+	s.writeCommand(statusMessage(0, streamID, infoObject))
+	s.flush()
+}
+
+// writeCommand queues a command message in the encoding the client asked for in the connect command.
+func (s *Session) writeCommand(m *Message) {
+	if s.objectEncoding == 3 {
+		// AMF3 command messages are the same AMF0 values prefixed with a format selector byte
+		m.TypeID = TypeCommandAMF3
+		m.Payload = append([]byte{0}, m.Payload...)
+	}
+	s.writeMessage(m)
+}
+
+// stringArg returns the i-th command argument if it is a string.
+func stringArg(args []interface{}, i int) string {
+	if i >= len(args) {
+		return ""
+	}
+	s, _ := args[i].(string)
+	return s
+}
+
+// numberArg returns the i-th command argument if it is a number.
+func numberArg(args []interface{}, i int) (float64, bool) {
+	if i >= len(args) {
+		return 0, false
+	}
+	f, ok := args[i].(float64)
+	return f, ok
+}
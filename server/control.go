@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// User control message event types sent by clients
+const (
+	EventSetBufferLength uint16 = 3
+	EventPingResponse    uint16 = 7
+)
+
+// handleProtocolControl applies a protocol control message (or user control message) sent by the client.
+// Malformed messages are logged as protocol violations and otherwise ignored.
+func (s *Session) handleProtocolControl(m *Message) {
+	payload := m.Payload
+	if m.ChunkStreamID != chunkStreamProtocol || m.StreamID != 0 {
+		s.protocolViolation(fmt.Errorf("control message type %d on chunk stream %d, message stream %d", m.TypeID, m.ChunkStreamID, m.StreamID))
+	}
+
+	switch m.TypeID {
+	case TypeSetChunkSize:
+		if len(payload) < 4 {
+			s.protocolViolation(fmt.Errorf("set chunk size message of %d bytes", len(payload)))
+			return
+		}
+		size := binary.BigEndian.Uint32(payload)
+		// The highest bit must be 0, and a chunk could not be bigger than a message
+		if size&0x80000000 != 0 || size == 0 {
+			s.protocolViolation(fmt.Errorf("invalid chunk size %d", size))
+			return
+		}
+		if size > maxMessageLength {
+			size = maxMessageLength
+		}
+		s.debugln("Set chunk size", size)
+		s.chunkReader.SetChunkSize(size)
+
+	case TypeAbort:
+		if len(payload) < 4 {
+			s.protocolViolation(fmt.Errorf("abort message of %d bytes", len(payload)))
+			return
+		}
+		csID := binary.BigEndian.Uint32(payload)
+		s.debugln("Abort chunk stream", csID, "discarded:", s.chunkReader.Abort(csID))
+
+	case TypeAcknowledgement:
+		if len(payload) < 4 {
+			s.protocolViolation(fmt.Errorf("acknowledgement message of %d bytes", len(payload)))
+			return
+		}
+		// Sequence number is the number of bytes the peer received so far, only logged
+		s.debugln("Acknowledgement", binary.BigEndian.Uint32(payload))
+
+	case TypeWindowAckSize:
+		if len(payload) < 4 {
+			s.protocolViolation(fmt.Errorf("window acknowledgement size message of %d bytes", len(payload)))
+			return
+		}
+		// The peer expects an acknowledgement from us after every window size bytes
+		s.peerWindowAckSize = binary.BigEndian.Uint32(payload)
+		s.debugln("Window acknowledgement size", s.peerWindowAckSize)
+
+	case TypeSetPeerBandwidth:
+		if len(payload) < 5 {
+			s.protocolViolation(fmt.Errorf("set peer bandwidth message of %d bytes", len(payload)))
+			return
+		}
+		// For now, ignore the limit type: we don't limit our output bandwidth
+		s.debugln("Set peer bandwidth", binary.BigEndian.Uint32(payload), "limit", payload[4])
+
+	case TypeUserControl:
+		if len(payload) < 2 {
+			s.protocolViolation(fmt.Errorf("user control message of %d bytes", len(payload)))
+			return
+		}
+		event := binary.BigEndian.Uint16(payload)
+		switch event {
+		case EventSetBufferLength:
+			if len(payload) < 10 {
+				s.protocolViolation(fmt.Errorf("set buffer length event of %d bytes", len(payload)))
+				return
+			}
+			s.debugln("Set buffer length", binary.BigEndian.Uint32(payload[2:]), binary.BigEndian.Uint32(payload[6:]), "ms")
+		case EventPingResponse:
+			s.debugln("Ping response")
+		default:
+			s.debugln("User control event", event)
+		}
+	}
+}
+
+func (s *Session) protocolViolation(err error) {
+	s.logln("protocol violation from", s.RemoteAddr(), err)
+}
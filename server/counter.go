@@ -0,0 +1,63 @@
+package server
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// countingReader counts the bytes read from the connection, both for the session and the whole server.
+type countingReader struct {
+	r       io.Reader
+	session *uint64
+	server  *uint64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddUint64(c.session, uint64(n))
+	atomic.AddUint64(c.server, uint64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes written to the connection, both for the session and the whole server.
+type countingWriter struct {
+	w       io.Writer
+	session *uint64
+	server  *uint64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddUint64(c.session, uint64(n))
+	atomic.AddUint64(c.server, uint64(n))
+	return n, err
+}
+
+// BytesRead returns the number of bytes received from the client.
+func (s *Session) BytesRead() uint64 {
+	return atomic.LoadUint64(&s.bytesRead)
+}
+
+// BytesWritten returns the number of bytes sent to the client.
+func (s *Session) BytesWritten() uint64 {
+	return atomic.LoadUint64(&s.bytesWritten)
+}
+
+// acknowledge sends an Acknowledgement message when the client sent a window worth of bytes since the last one.
+// Only the window of the client counts: the one we announced tells the client when to acknowledge our bytes,
+// so nothing is acknowledged until the client sends a Window Acknowledgement Size.
+func (s *Session) acknowledge() {
+	window := s.peerWindowAckSize
+	if window == 0 {
+		return
+	}
+
+	received := s.BytesRead()
+	if received-s.lastAcknowledged < uint64(window) {
+		return
+	}
+	s.lastAcknowledged = received
+	// The sequence number is the number of bytes received so far, it wraps around at 32 bits
+	s.writeMessage(acknowledgementMessage(uint32(received)))
+	s.flush()
+}
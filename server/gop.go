@@ -0,0 +1,63 @@
+package server
+
+// Default limits of the GOP cache of every stream, see WithGOPCache.
+const (
+	DefaultGOPCacheFrames = 600
+	DefaultGOPCacheBytes  = 16 * 1024 * 1024
+)
+
+// gopCache keeps the audio and video packets since the last video key frame, so a new subscriber
+// could start decoding right away instead of waiting for the next key frame.
+type gopCache struct {
+	// maxFrames 0 disables the cache, maxBytes 0 means no size limit
+	maxFrames int
+	maxBytes  int
+
+	packets []*Packet
+	frames  int
+	bytes   int
+	// overflow is set when the GOP didn't fit, nothing is cached until the next key frame then
+	overflow bool
+}
+
+// WithGOPCache sets how many video frames and bytes (audio included) could be cached per stream
+// for the new subscribers. Zero frames disables the cache, zero bytes removes the size limit.
+// A GOP exceeding the limits is not cached at all, the subscribers wait for the next key frame then.
+func WithGOPCache(maxFrames int, maxBytes int) Option {
+	return func(s *Server) {
+		s.registry.gopCacheFrames = maxFrames
+		s.registry.gopCacheBytes = maxBytes
+	}
+}
+
+// add caches an audio or video packet. Sequence headers are kept separately by the stream.
+func (c *gopCache) add(p *Packet) {
+	if c.maxFrames <= 0 {
+		return
+	}
+	if p.IsKeyFrame() {
+		c.reset()
+	} else if c.overflow || len(c.packets) == 0 {
+		// Nothing is useful before the first key frame
+		return
+	}
+
+	if p.Type == TypeVideo {
+		c.frames++
+	}
+	c.bytes += len(p.Payload)
+	if c.frames > c.maxFrames || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.reset()
+		c.overflow = true
+		return
+	}
+	c.packets = append(c.packets, p)
+}
+
+func (c *gopCache) reset() {
+	// Subscribers could still read the old slice, so it is not reused
+	c.packets = nil
+	c.frames = 0
+	c.bytes = 0
+	c.overflow = false
+}
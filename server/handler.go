@@ -0,0 +1,89 @@
+package server
+
+import (
+	"github.com/torresjeff/rtmp/audio"
+	"github.com/torresjeff/rtmp/video"
+)
+
+// Handler receives the events of every session of a Server.
+// The methods are called from the goroutine reading the session, so they should return quickly.
+// Payloads passed to the handler are not reused by the server, but they must not be modified.
+type Handler interface {
+	// OnConnect is called when the client sent the connect command for an app.
+	OnConnect(s *Session, app string, commandObject map[string]interface{})
+	// OnPublish is called when the client starts publishing a stream.
+	OnPublish(s *Session, streamKey string, publishingType string)
+	// OnAudio is called for every audio message of a published stream.
+	// The timestamp is in milliseconds, it never decreases and doesn't wrap around like the 32 bit RTMP timestamps.
+	OnAudio(s *Session, streamKey string, header AudioHeader, payload []byte, timestamp uint64)
+	// OnVideo is called for every video message of a published stream, the timestamp is the same as for OnAudio.
+	OnVideo(s *Session, streamKey string, header VideoHeader, payload []byte, timestamp uint64)
+	// OnMetadata is called when the client sends the onMetaData of a published stream.
+	// Encoders could update the metadata mid-stream, every update replaces the previous one.
+	OnMetadata(s *Session, streamKey string, metadata *StreamMetadata)
+	// OnUnpublish is called when the client stops publishing a stream, or disconnects while publishing.
+	OnUnpublish(s *Session, streamKey string)
+	// OnDisconnect is called once the session of a connected client ends. The error is nil on a clean close.
+	OnDisconnect(s *Session, err error)
+}
+
+// NopHandler implements Handler and ignores every event.
+// It could be embedded to implement only the needed methods.
+type NopHandler struct{}
+
+func (NopHandler) OnConnect(*Session, string, map[string]interface{})    {}
+func (NopHandler) OnPublish(*Session, string, string)                    {}
+func (NopHandler) OnAudio(*Session, string, AudioHeader, []byte, uint64) {}
+func (NopHandler) OnVideo(*Session, string, VideoHeader, []byte, uint64) {}
+func (NopHandler) OnMetadata(*Session, string, *StreamMetadata)          {}
+func (NopHandler) OnUnpublish(*Session, string)                          {}
+func (NopHandler) OnDisconnect(*Session, error)                          {}
+
+// AudioHeader is the parsed first byte(s) of an FLV audio tag.
+type AudioHeader struct {
+	Format     audio.Format
+	SampleRate audio.SampleRate
+	SampleSize audio.SampleSize
+	Channels   audio.Channel
+	// AACPacketType is only set when Format is audio.AAC
+	AACPacketType audio.AACPacketType
+}
+
+// VideoHeader is the parsed first byte(s) of an FLV video tag.
+type VideoHeader struct {
+	FrameType video.FrameType
+	Codec     video.Codec
+	// AVCPacketType and CompositionTime are only set when Codec is video.H264
+	AVCPacketType   video.AVCPacketType
+	CompositionTime int32
+}
+
+func parseAudioHeader(payload []byte) AudioHeader {
+	// Header contains sound format, rate, size, type
+	audioHeader := payload[0]
+	h := AudioHeader{
+		Format:     audio.Format((audioHeader >> 4) & 0x0F),
+		SampleRate: audio.SampleRate((audioHeader >> 2) & 0x03),
+		SampleSize: audio.SampleSize((audioHeader >> 1) & 1),
+		Channels:   audio.Channel((audioHeader) & 1),
+	}
+	if h.Format == audio.AAC && len(payload) > 1 {
+		h.AACPacketType = audio.AACPacketType(payload[1])
+	}
+	return h
+}
+
+func parseVideoHeader(payload []byte) VideoHeader {
+	// Header contains frame type (key frame, i-frame, etc.) and format/codec (H264, etc.)
+	videoHeader := payload[0]
+	h := VideoHeader{
+		FrameType: video.FrameType((videoHeader >> 4) & 0x0F),
+		Codec:     video.Codec(videoHeader & 0x0F),
+	}
+	if h.Codec == video.H264 && len(payload) > 4 {
+		h.AVCPacketType = video.AVCPacketType(payload[1])
+		// Composition time is a signed 24 bit integer
+		h.CompositionTime = int32(uint32(payload[2])<<16|uint32(payload[3])<<8|uint32(payload[4])) << 8 >> 8
+	}
+	return h
+}
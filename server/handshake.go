@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// Thanks to github.com/torresjeff/rtmp
+
+var ErrUnsupportedRTMPVersion error = errors.New("the version of RTMP is not supported")
+var ErrWrongC2Message error = errors.New("server handshake: s1 and c2 handshake messages do not match")
+
+//var ErrWrongS2Message error = errors.New("client handshake: c1 and s2 handshake messages do not match")
+
+const RtmpVersion3 = 3
+
+// Handshake performs the server side of the RTMP handshake.
+// If C1 contains a valid digest the complex (digest-based) handshake is used, otherwise the simple one.
+func Handshake(reader *bufio.Reader, writer *bufio.Writer) error {
+	c1, err := readC0C1(reader)
+	if err != nil {
+		return err
+	}
+
+	if base, clientDigest, ok := findClientDigest(c1); ok {
+		if err := sendDigestS0S1S2(writer, base, clientDigest); err != nil {
+			return err
+		}
+		// Clients of the digest handshake answer with their own signed data instead of echoing S1.
+		// Most servers don't check it (and some clients don't even sign it correctly), so neither do we.
+		_, err := readC2(reader)
+		return err
+	}
+
+	s1, err := sendS0S1S2(writer, c1)
+	if err != nil {
+		return err
+	}
+	c2, err := readC2(reader)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Compare(s1, c2) != 0 {
+		return ErrWrongC2Message
+	}
+
+	return nil
+}
+
+func readC0C1(reader *bufio.Reader) ([]byte, error) {
+	var c0c1 [1537]byte
+
+	if _, err := io.ReadFull(reader, c0c1[:]); err != nil {
+		return nil, err
+	}
+
+	if c0c1[0] != RtmpVersion3 {
+		return nil, ErrUnsupportedRTMPVersion
+	}
+
+	// Returns c1 message
+	return c0c1[1:], nil
+}
+
+func sendS0S1S2(writer *bufio.Writer, c1 []byte) ([]byte, error) {
+	var s0s1s2 [1 + 2*1536]byte
+	var err error
+	// s0 message is stored in byte 0
+	s0s1s2[0] = RtmpVersion3
+	// s1 message is stored in bytes 1-1536
+	if err = generateRandomData(s0s1s2[1:1537]); err != nil {
+		return nil, err
+	}
+	// s2 message is stored in bytes 1537-3073
+	copy(s0s1s2[1537:], c1)
+
+	err = send(writer, s0s1s2[:])
+	if err != nil {
+		return nil, err
+	}
+	return s0s1s2[1:1537], nil
+}
+
+// Returns the C2 message
+func readC2(reader *bufio.Reader) ([]byte, error) {
+	var c2 [1536]byte
+	if _, err := io.ReadFull(reader, c2[:]); err != nil {
+		return nil, err
+	}
+	return c2[:], nil
+}
+
+func generateRandomData(s1 []byte) error {
+	// the s1 byte array is zero-initialized, since we didn't modify it, we're sending our time as 0
+	err := GenerateRandomDataFromBuffer(s1[8:])
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func GenerateRandomDataFromBuffer(b []byte) error {
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func send(writer *bufio.Writer, bytes []byte) error {
+	if _, err := writer.Write(bytes); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// The complex (digest-based) handshake is not part of the published spec, it was reverse engineered from the
+// Flash Player and Flash Media Server. C1 and S1 are split into a 764 byte key block and a 764 byte digest block
+// after the time and version fields. In scheme 0 the key block comes first, in scheme 1 the digest block.
+
+const (
+	handshakeMessageSize = 1536
+	digestLength         = sha256.Size
+
+	// Position of the digest block inside the C1/S1 message in the two schemes
+	digestBlockScheme0 = 8 + 764
+	digestBlockScheme1 = 8
+)
+
+// serverVersion is sent in the version field of S1, a non-zero value tells the client we support the digest handshake
+var serverVersion = [4]byte{0x0d, 0x0e, 0x0a, 0x0d}
+
+var genuineFPKey = []byte{
+	'G', 'e', 'n', 'u', 'i', 'n', 'e', ' ', 'A', 'd', 'o', 'b', 'e', ' ',
+	'F', 'l', 'a', 's', 'h', ' ', 'P', 'l', 'a', 'y', 'e', 'r', ' ', '0', '0', '1', // Genuine Adobe Flash Player 001
+	0xF0, 0xEE, 0xC2, 0x4A, 0x80, 0x68, 0xBE, 0xE8, 0x2E, 0x00, 0xD0, 0xD1,
+	0x02, 0x9E, 0x7E, 0x57, 0x6E, 0xEC, 0x5D, 0x2D, 0x29, 0x80, 0x6F, 0xAB,
+	0x93, 0xB8, 0xE6, 0x36, 0xCF, 0xEB, 0x31, 0xAE,
+}
+
+var genuineFMSKey = []byte{
+	'G', 'e', 'n', 'u', 'i', 'n', 'e', ' ', 'A', 'd', 'o', 'b', 'e', ' ',
+	'F', 'l', 'a', 's', 'h', ' ', 'M', 'e', 'd', 'i', 'a', ' ',
+	'S', 'e', 'r', 'v', 'e', 'r', ' ', '0', '0', '1', // Genuine Adobe Flash Media Server 001
+	0xF0, 0xEE, 0xC2, 0x4A, 0x80, 0x68, 0xBE, 0xE8, 0x2E, 0x00, 0xD0, 0xD1,
+	0x02, 0x9E, 0x7E, 0x57, 0x6E, 0xEC, 0x5D, 0x2D, 0x29, 0x80, 0x6F, 0xAB,
+	0x93, 0xB8, 0xE6, 0x36, 0xCF, 0xEB, 0x31, 0xAE,
+}
+
+// C1 digests are signed with the textual part of the player key, S1 digests with the textual part of the server key
+var (
+	clientDigestKey = genuineFPKey[:30]
+	serverDigestKey = genuineFMSKey[:36]
+)
+
+// digestOffset returns the position of the digest inside a C1/S1 message for the digest block starting at base.
+// The first 4 bytes of the block determine where the digest is placed.
+func digestOffset(message []byte, base int) int {
+	sum := int(message[base]) + int(message[base+1]) + int(message[base+2]) + int(message[base+3])
+	return base + 4 + sum%728
+}
+
+// messageDigest calculates the HMAC-SHA256 of the message without the 32 bytes of the digest at offset.
+func messageDigest(key []byte, message []byte, offset int) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message[:offset])
+	mac.Write(message[offset+digestLength:])
+	return mac.Sum(nil)
+}
+
+func hmacSHA256(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// findClientDigest looks for a valid digest in C1 in both schemes.
+// It returns the digest block position of the matching scheme and the digest itself, or ok = false if C1 has no digest.
+func findClientDigest(c1 []byte) (base int, digest []byte, ok bool) {
+	// A zero version means the client only knows the simple handshake
+	if c1[4] == 0 && c1[5] == 0 && c1[6] == 0 && c1[7] == 0 {
+		return 0, nil, false
+	}
+
+	for _, base := range []int{digestBlockScheme0, digestBlockScheme1} {
+		offset := digestOffset(c1, base)
+		expected := messageDigest(clientDigestKey, c1, offset)
+		if hmac.Equal(c1[offset:offset+digestLength], expected) {
+			return base, c1[offset : offset+digestLength], true
+		}
+	}
+	return 0, nil, false
+}
+
+// sendDigestS0S1S2 answers a C1 containing a digest. S1 carries our own digest in the same scheme the client used,
+// S2 is random data signed with a key derived from the client's digest.
+func sendDigestS0S1S2(writer *bufio.Writer, base int, clientDigest []byte) error {
+	var s0s1s2 [1 + 2*handshakeMessageSize]byte
+	s0s1s2[0] = RtmpVersion3
+
+	s1 := s0s1s2[1 : 1+handshakeMessageSize]
+	// Time is left at 0, then comes our version
+	copy(s1[4:8], serverVersion[:])
+	if err := GenerateRandomDataFromBuffer(s1[8:]); err != nil {
+		return err
+	}
+	offset := digestOffset(s1, base)
+	copy(s1[offset:], messageDigest(serverDigestKey, s1, offset))
+
+	s2 := s0s1s2[1+handshakeMessageSize:]
+	if err := GenerateRandomDataFromBuffer(s2); err != nil {
+		return err
+	}
+	signature := s2[handshakeMessageSize-digestLength:]
+	tempKey := hmacSHA256(genuineFMSKey, clientDigest)
+	copy(signature, hmacSHA256(tempKey, s2[:handshakeMessageSize-digestLength]))
+
+	return send(writer, s0s1s2[:])
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"testing"
+)
+
+// digestC1 returns a C1 of a client supporting the digest handshake, signed in the scheme of the digest block at base.
+func digestC1(base int) []byte {
+	c1 := make([]byte, handshakeMessageSize)
+	copy(c1[4:8], []byte{0x80, 0x00, 0x07, 0x02})
+	for i := 8; i < len(c1); i++ {
+		c1[i] = byte(i * 7)
+	}
+	offset := digestOffset(c1, base)
+	copy(c1[offset:], messageDigest(clientDigestKey, c1, offset))
+	return c1
+}
+
+// handshake runs the server side of the handshake with C0, C1 and C2, and returns the S0, S1 and S2 sent.
+func handshake(c1, c2 []byte) ([]byte, error) {
+	in := append(append([]byte{RtmpVersion3}, c1...), c2...)
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	err := Handshake(bufio.NewReader(bytes.NewReader(in)), w)
+	return out.Bytes(), err
+}
+
+func TestHandshakeDigest(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		base int
+	}{
+		{"scheme 0", digestBlockScheme0},
+		{"scheme 1", digestBlockScheme1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c1 := digestC1(tt.base)
+			base, clientDigest, ok := findClientDigest(c1)
+			if !ok || base != tt.base {
+				t.Fatalf("findClientDigest() = %d, %v, want %d, true", base, ok, tt.base)
+			}
+
+			// The client's C2 isn't checked
+			out, err := handshake(c1, make([]byte, handshakeMessageSize))
+			if err != nil {
+				t.Fatalf("Handshake() error = %v", err)
+			}
+			if len(out) != 1+2*handshakeMessageSize || out[0] != RtmpVersion3 {
+				t.Fatalf("S0S1S2 of %d bytes, version %d", len(out), out[0])
+			}
+			s1, s2 := out[1:1+handshakeMessageSize], out[1+handshakeMessageSize:]
+			if !bytes.Equal(s1[4:8], serverVersion[:]) {
+				t.Errorf("S1 version % X, want % X", s1[4:8], serverVersion)
+			}
+			// S1 is signed in the scheme of the client
+			offset := digestOffset(s1, tt.base)
+			if !hmac.Equal(s1[offset:offset+digestLength], messageDigest(serverDigestKey, s1, offset)) {
+				t.Error("S1 has no valid digest")
+			}
+			// S2 is signed with the key derived from the digest of C1
+			signature := s2[handshakeMessageSize-digestLength:]
+			key := hmacSHA256(genuineFMSKey, clientDigest)
+			if !hmac.Equal(signature, hmacSHA256(key, s2[:handshakeMessageSize-digestLength])) {
+				t.Error("S2 has no valid signature")
+			}
+		})
+	}
+}
+
+func TestHandshakeSimple(t *testing.T) {
+	// A zero version, and a version with a broken digest
+	brokenDigest := digestC1(digestBlockScheme0)
+	brokenDigest[digestOffset(brokenDigest, digestBlockScheme0)] ^= 0xFF
+	for name, c1 := range map[string][]byte{
+		"zero version":  bytes.Repeat([]byte{0, 0, 0, 0, 0, 0, 0, 0, 1}, handshakeMessageSize/9+1)[:handshakeMessageSize],
+		"broken digest": brokenDigest,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, _, ok := findClientDigest(c1); ok {
+				t.Fatal("findClientDigest() found a digest")
+			}
+			// C2 should echo S1
+			out, err := handshake(c1, make([]byte, handshakeMessageSize))
+			if err != ErrWrongC2Message {
+				t.Errorf("Handshake() error = %v, want %v", err, ErrWrongC2Message)
+			}
+			if s2 := out[1+handshakeMessageSize:]; !bytes.Equal(s2, c1) {
+				t.Error("S2 doesn't echo C1")
+			}
+		})
+	}
+}
+
+func TestHandshakeUnsupportedVersion(t *testing.T) {
+	in := append([]byte{6}, make([]byte, 2*handshakeMessageSize)...)
+	err := Handshake(bufio.NewReader(bytes.NewReader(in)), bufio.NewWriter(&bytes.Buffer{}))
+	if err != ErrUnsupportedRTMPVersion {
+		t.Errorf("Handshake() error = %v, want %v", err, ErrUnsupportedRTMPVersion)
+	}
+}
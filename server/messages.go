@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/binary"
+
+	"github.com/torresjeff/rtmp/amf/amf0"
+)
+
+// Message type IDs
+const (
+	// Protocol control messages
+	TypeSetChunkSize     uint8 = 1
+	TypeAbort            uint8 = 2
+	TypeAcknowledgement  uint8 = 3
+	TypeUserControl      uint8 = 4
+	TypeWindowAckSize    uint8 = 5
+	TypeSetPeerBandwidth uint8 = 6
+
+	TypeAudio uint8 = 8
+	TypeVideo uint8 = 9
+
+	TypeDataAMF3         uint8 = 15
+	TypeSharedObjectAMF3 uint8 = 16
+	TypeCommandAMF3      uint8 = 17
+	TypeDataAMF0         uint8 = 18
+	TypeSharedObjectAMF0 uint8 = 19
+	TypeCommandAMF0      uint8 = 20
+
+	TypeAggregate uint8 = 22
+)
+
+// User control message event types
+const (
+	EventStreamBegin uint16 = 0
+	EventStreamEOF   uint16 = 1
+)
+
+// Chunk stream IDs used for the messages we send. Only the protocol channel is defined in the spec,
+// the others are just kept consistent to send the same kind of data through the same chunk stream.
+const (
+	// Chunk Stream ID with value 2 is reserved for low-level protocol control messages and commands.
+	chunkStreamProtocol uint32 = 2
+	// Twitch sends the command responses on chunk stream 3
+	chunkStreamCommand uint32 = 3
+	// The media of the played streams, the same IDs as nginx-rtmp uses
+	chunkStreamData  uint32 = 5
+	chunkStreamAudio uint32 = 6
+	chunkStreamVideo uint32 = 7
+)
+
+// Protocol Control Messages always use the message stream ID 0 and chunk stream ID 2.
+// NetConnection is the default communication channel, which has a stream ID 0.
+func protocolControlMessage(typeID uint8, payload []byte) *Message {
+	return &Message{
+		ChunkStreamID: chunkStreamProtocol,
+		TypeID:        typeID,
+		StreamID:      0,
+		Payload:       payload,
+	}
+}
+
+// acknowledgementMessage tells the peer how many bytes we received so far.
+func acknowledgementMessage(sequenceNumber uint32) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, sequenceNumber)
+	return protocolControlMessage(TypeAcknowledgement, payload)
+}
+
+// windowAckSizeMessage tells the peer after how many received bytes it should send an acknowledgement.
+func windowAckSizeMessage(size uint32) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, size)
+	return protocolControlMessage(TypeWindowAckSize, payload)
+}
+
+func setPeerBandwidthMessage(size uint32, limitType uint8) *Message {
+	payload := make([]byte, 5)
+	// The peer bandwidth the client should use in the first 4 bytes
+	binary.BigEndian.PutUint32(payload, size)
+
+	// Finally, set the limit type (hard = 0, soft = 1, dynamic = 2). The spec defines each one of these as follows:
+	// 0 - Hard: The peer SHOULD limit its output bandwidth to the indicated window size.
+	// 1 - Soft: The peer SHOULD limit its output bandwidth to the the window indicated in this message or the limit already in effect, whichever is smaller.
+	// 2 - Dynamic: If the previous Limit Type was Hard, treat this message as though it was marked Hard, otherwise ignore this message.
+	payload[4] = limitType
+	return protocolControlMessage(TypeSetPeerBandwidth, payload)
+}
+
+// streamBeginMessage is a User Control Message telling the client that the stream became functional.
+func streamBeginMessage(streamID uint32) *Message {
+	return streamEventMessage(EventStreamBegin, streamID)
+}
+
+// streamEOFMessage is a User Control Message telling the client that the playback of the stream is over.
+func streamEOFMessage(streamID uint32) *Message {
+	return streamEventMessage(EventStreamEOF, streamID)
+}
+
+func streamEventMessage(event uint16, streamID uint32) *Message {
+	payload := make([]byte, 6)
+	// 2 bytes event type, then 4 bytes event data: the stream ID
+	binary.BigEndian.PutUint16(payload, event)
+	binary.BigEndian.PutUint32(payload[2:], streamID)
+	return protocolControlMessage(TypeUserControl, payload)
+}
+
+func setChunkSizeMessage(chunkSize uint32) *Message {
+	payload := make([]byte, 4)
+	// The highest bit must be 0
+	binary.BigEndian.PutUint32(payload, chunkSize&0x7FFFFFFF)
+	return protocolControlMessage(TypeSetChunkSize, payload)
+}
+
+// commandMessage encodes the values into an AMF0 command message on the given message stream.
+func commandMessage(csID uint32, streamID uint32, values ...interface{}) *Message {
+	var payload []byte
+	for _, v := range values {
+		encoded, _ := amf0.Encode(v)
+		payload = append(payload, encoded...)
+	}
+	return &Message{
+		ChunkStreamID: csID,
+		TypeID:        TypeCommandAMF0,
+		StreamID:      streamID,
+		Payload:       payload,
+	}
+}
+
+// dataMessage encodes the values into an AMF0 data message on the given message stream.
+func dataMessage(csID uint32, streamID uint32, values ...interface{}) *Message {
+	m := commandMessage(csID, streamID, values...)
+	m.TypeID = TypeDataAMF0
+	return m
+}
+
+// sampleAccessMessage allows the Flash based players to access the raw audio and video data.
+func sampleAccessMessage(streamID uint32) *Message {
+	return dataMessage(chunkStreamData, streamID, "|RtmpSampleAccess", true, true)
+}
+
+func connectResponseSuccessMessage(csID uint32, objectEncoding float64) *Message {
+	// why does Twitch send csId = 3? is it because it is replying to the connect() request which sent csID = 3?
+	return commandMessage(csID, 0,
+		"_result",
+		// Transaction ID is 1 for connection responses
+		1,
+		map[string]interface{}{
+			"fmsVer":       "FMS/3,5,7,7009",
+			"capabilities": 31,
+			"mode":         1,
+		},
+		map[string]interface{}{
+			"code":        "NetConnection.Connect.Success",
+			"level":       "status",
+			"description": "Connection accepted.",
+			"data": map[string]interface{}{
+				"string": "3,5,7,7009",
+			},
+			"objectEncoding": objectEncoding, // AMFVersion0 or AMFVersion3, whatever the client asked for
+		},
+	)
+}
+
+func connectResponseRejectedMessage(csID uint32, objectEncoding float64) *Message {
+	return commandMessage(csID, 0,
+		"_error",
+		// Transaction ID is 1 for connection responses
+		1,
+		nil,
+		map[string]interface{}{
+			"code":           "NetConnection.Connect.Rejected",
+			"level":          "error",
+			"description":    "Connection rejected.",
+			"objectEncoding": objectEncoding,
+		},
+	)
+}
+
+func createStreamResponseMessage(csID uint32, transactionID float64) *Message {
+	// ID of the stream that was opened. We could also send an object with additional information if an error occurred, instead of a number.
+	// Subsequent chunks will be sent by the client on the stream ID specified here.
+	// TODO: is this a fixed value?
+	return commandMessage(csID, 0, "_result", transactionID, nil, 1)
+}
+
+func statusMessage(transactionID float64, streamID uint32, infoObject map[string]interface{}) *Message {
+	// Status messages don't have a command object, so encode nil
+	return commandMessage(chunkStreamCommand, streamID, "onStatus", transactionID, nil, infoObject)
+}
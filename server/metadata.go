@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/torresjeff/rtmp/amf/amf0"
+	"github.com/torresjeff/rtmp/audio"
+	"github.com/torresjeff/rtmp/video"
+)
+
+// StreamMetadata is the onMetaData sent by the encoder, usually right after publish.
+// Encoders only send the fields they know about, the missing ones are left at zero.
+type StreamMetadata struct {
+	Width     int
+	Height    int
+	FrameRate float64
+	// Data rates are in kbit/s
+	VideoDataRate float64
+	AudioDataRate float64
+	// AudioSampleRate is in Hz, AudioSampleSize in bits
+	AudioSampleRate float64
+	AudioSampleSize float64
+	AudioChannels   int
+	Stereo          bool
+	VideoCodecID    video.Codec
+	AudioCodecID    audio.Format
+	// Duration (in seconds) and FileSize (in bytes) are usually 0 for live streams
+	Duration float64
+	FileSize float64
+	Encoder  string
+
+	// Raw contains every property as it was sent, including the ones not listed above
+	Raw map[string]interface{}
+
+	payload []byte
+}
+
+// ParseStreamMetadata converts the properties of an onMetaData message into a StreamMetadata.
+func ParseStreamMetadata(properties map[string]interface{}) *StreamMetadata {
+	m := &StreamMetadata{
+		Width:           int(number(properties["width"])),
+		Height:          int(number(properties["height"])),
+		FrameRate:       number(properties["framerate"]),
+		VideoDataRate:   number(properties["videodatarate"]),
+		AudioDataRate:   number(properties["audiodatarate"]),
+		AudioSampleRate: number(properties["audiosamplerate"]),
+		AudioSampleSize: number(properties["audiosamplesize"]),
+		AudioChannels:   int(number(properties["audiochannels"])),
+		Duration:        number(properties["duration"]),
+		FileSize:        number(properties["filesize"]),
+		Raw:             properties,
+	}
+	if m.FrameRate == 0 {
+		// Some encoders (Flash Media Live Encoder for example) use fps instead
+		m.FrameRate = number(properties["fps"])
+	}
+	m.Stereo, _ = properties["stereo"].(bool)
+	if m.AudioChannels == 0 && m.Stereo {
+		m.AudioChannels = 2
+	}
+	m.Encoder, _ = properties["encoder"].(string)
+
+	// Codec IDs are either the FLV codec numbers or a FourCC string
+	switch id := properties["videocodecid"].(type) {
+	case float64:
+		m.VideoCodecID = video.Codec(id)
+	case string:
+		if id == "avc1" {
+			m.VideoCodecID = video.H264
+		}
+	}
+	switch id := properties["audiocodecid"].(type) {
+	case float64:
+		m.AudioCodecID = audio.Format(id)
+	case string:
+		if id == "mp4a" {
+			m.AudioCodecID = audio.AAC
+		} else if id == ".mp3" {
+			m.AudioCodecID = audio.MP3
+		}
+	}
+
+	m.payload = encodeMetadata(properties)
+	return m
+}
+
+// Payload returns the body of an AMF0 data message carrying the metadata ("onMetaData" and the properties),
+// the way it should be sent to the consumers of the stream.
+func (m *StreamMetadata) Payload() []byte {
+	return m.payload
+}
+
+func number(v interface{}) float64 {
+	f, _ := v.(float64)
+	return f
+}
+
+// encodeMetadata encodes the onMetaData data message body. The amf0 package can't encode every decoded
+// type (arrays for example), those properties are left out instead of breaking the whole message.
+func encodeMetadata(properties map[string]interface{}) []byte {
+	payload, _ := amf0.Encode("onMetaData")
+	encodable := make(amf0.ECMAArray, len(properties))
+	for k, v := range properties {
+		if isAMF0Encodable(v, 1) {
+			encodable[k] = v
+		}
+	}
+	return append(payload, encodeECMAArray(encodable)...)
+}
+
+// encodeECMAArray encodes an ECMA array with the object end marker, which is left out by the amf0 package.
+func encodeECMAArray(arr amf0.ECMAArray) []byte {
+	// The properties and the end marker are the same as for an anonymous object, only the header differs
+	obj, _ := amf0.Encode(map[string]interface{}(arr))
+	b := make([]byte, 5, 4+len(obj))
+	b[0] = amf0.TypeECMAArray
+	binary.BigEndian.PutUint32(b[1:], uint32(len(arr)))
+	return append(b, obj[1:]...)
+}
+
+// isAMF0Encodable reports whether the amf0 package could encode the value at the depth. Objects nested deeper than
+// maxAMFDepth are not: the stored metadata is encoded for every subscriber, a map containing itself would never end.
+func isAMF0Encodable(v interface{}, depth int) bool {
+	switch v := v.(type) {
+	case float64, int, bool, string, nil, time.Time:
+		return true
+	case map[string]interface{}:
+		if depth >= maxAMFDepth {
+			return false
+		}
+		for _, value := range v {
+			if !isAMF0Encodable(value, depth+1) {
+				return false
+			}
+		}
+		return true
+	default:
+		// Nested ECMA arrays are broken by the amf0 package too
+		return false
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/torresjeff/rtmp/amf/amf0"
+)
+
+func TestEncodeMetadata(t *testing.T) {
+	cyclic := map[string]interface{}{}
+	cyclic["self"] = cyclic
+	deep := map[string]interface{}{}
+	for i := 0; i < maxAMFDepth; i++ {
+		deep = map[string]interface{}{"a": deep}
+	}
+
+	payload := encodeMetadata(map[string]interface{}{
+		"width":   640.0,
+		"encoder": "test",
+		"nested":  map[string]interface{}{"a": true},
+		"array":   []interface{}{1.0},
+		"cyclic":  cyclic,
+		"deep":    deep,
+	})
+	values, err := decodeAMF0Values(payload)
+	if err != nil {
+		t.Fatalf("decodeAMF0Values() error = %v", err)
+	}
+	want := []interface{}{
+		"onMetaData",
+		amf0.ECMAArray{"width": 640.0, "encoder": "test", "nested": map[string]interface{}{"a": true}},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("encodeMetadata() = %#v, want %#v", values, want)
+	}
+}
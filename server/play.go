@@ -0,0 +1,91 @@
+package server
+
+// play subscribes the session to the live stream and starts sending its packets on the message stream.
+func (s *Session) play(streamID uint32, streamKey string) {
+	if s.playing != nil {
+		s.stopPlaying()
+	}
+	if !s.sendWebhook(s.webhookEvent(WebhookPlay, streamKey)) {
+		s.sendStatusMessage(streamID, "error", "NetStream.Play.Failed", "Playing "+streamKey+" is not allowed")
+		s.closeErr = ErrWebhookRejected
+		return
+	}
+	st := s.server.registry.Get(s.app, streamKey)
+	if st == nil {
+		s.sendStatusMessage(streamID, "error", "NetStream.Play.StreamNotFound", "No such stream: "+streamKey)
+		return
+	}
+
+	s.writeMessage(streamBeginMessage(streamID))
+	s.sendStatusMessage(streamID, "status", "NetStream.Play.Reset", "Playing and resetting "+streamKey)
+	s.sendStatusMessage(streamID, "status", "NetStream.Play.Start", "Started playing "+streamKey)
+	s.writeMessage(sampleAccessMessage(streamID))
+	s.flush()
+
+	s.playing = st
+	s.playStreamID = streamID
+	s.subscriber = st.Subscribe()
+	s.playDone = make(chan struct{})
+	s.enterPhase(phasePlay)
+	go s.playLoop(st, s.subscriber, streamID, s.playDone)
+}
+
+// stopPlaying unsubscribes the session from the played stream and waits for the player goroutine to return.
+func (s *Session) stopPlaying() {
+	s.playing.Unsubscribe(s.subscriber)
+	<-s.playDone
+	s.playing = nil
+	s.subscriber = nil
+	s.enterPhase(phaseConnect)
+}
+
+// playLoop sends the packets of the subscriber to the client until the queue is closed.
+func (s *Session) playLoop(st *Stream, sub *Subscriber, streamID uint32, done chan struct{}) {
+	defer close(done)
+
+	var timestamps TimestampRebaser
+	for p := range sub.Packets() {
+		m := &Message{
+			TypeID:    p.Type,
+			StreamID:  streamID,
+			Timestamp: timestamps.Rebase(p),
+			Payload:   p.Payload,
+		}
+		switch p.Type {
+		case TypeAudio:
+			m.ChunkStreamID = chunkStreamAudio
+		case TypeVideo:
+			m.ChunkStreamID = chunkStreamVideo
+		default:
+			m.ChunkStreamID = chunkStreamData
+		}
+
+		s.writeMu.Lock()
+		// A player not reading can't block the session, the write fails after the write timeout
+		s.extendWriteDeadline()
+		err := s.chunkWriter.WriteMessage(m)
+		if err == nil && len(sub.Packets()) == 0 {
+			// Flush once the player caught up with the queue, fewer syscalls while sending a burst
+			err = s.connWriter.Flush()
+		}
+		s.writeMu.Unlock()
+		if err != nil {
+			s.logln("closing connection from", s.RemoteAddr(), "reason: error sending packet to player:", err)
+			st.Unsubscribe(sub)
+			for range sub.Packets() {
+			}
+			// The buffered writer is broken after a failed write, the session reading goroutine stops too
+			_ = s.Close()
+			return
+		}
+	}
+
+	switch sub.Err() {
+	case ErrStreamUnpublished:
+		s.writeMessage(streamEOFMessage(streamID))
+		s.sendStatusMessage(streamID, "status", "NetStream.Play.UnpublishNotify", "Stream is now unpublished")
+	case ErrSubscriberTooSlow:
+		s.logln("closing connection from", s.RemoteAddr(), "reason: player is too slow")
+		_ = s.Close()
+	}
+}
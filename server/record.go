@@ -0,0 +1,120 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gerifield/mini-stream-test/flv"
+)
+
+// WithRecordDir enables the recording of the streams published with the "record" or "append" type.
+// The recordings are saved as <dir>/<app>/<stream key>.flv, "record" overwrites and "append" continues
+// the existing file. Without it every stream is handled as "live".
+func WithRecordDir(dir string) Option {
+	return func(s *Server) {
+		s.recordDir = dir
+	}
+}
+
+// recordPath returns where the stream should be recorded, or an empty string if the app or the key
+// can't be used as a file name.
+func (s *Session) recordPath(st *Stream) string {
+	for _, name := range []string{st.app, st.key} {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return ""
+		}
+	}
+	return filepath.Join(s.server.recordDir, st.app, st.key+".flv")
+}
+
+// startRecording records the stream into an FLV file if the publishing type asks for it. The file is opened
+// and finalized by the recording goroutine, the publisher doesn't wait for it.
+func (s *Session) startRecording(st *Stream, publishingType string) {
+	if s.server.recordDir == "" || (publishingType != "record" && publishingType != "append") {
+		return
+	}
+	path := s.recordPath(st)
+	if path == "" {
+		s.logln("can't record stream", st.app, st.key, "invalid file name")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		s.logln("can't record stream", st.app, st.key, err)
+		return
+	}
+	s.debugln("Recording", st.app, st.key, "to", path)
+
+	// The previous recording of the same file could still be finalized, the new one starts after it.
+	// The packets are queued meanwhile.
+	done := make(chan struct{})
+	s.server.mu.Lock()
+	previous := s.server.recordings[path]
+	s.server.recordings[path] = done
+	s.server.mu.Unlock()
+
+	sub := st.Subscribe()
+	s.server.wg.Add(1)
+	go func() {
+		defer s.server.wg.Done()
+		defer s.server.recordingDone(path, done)
+		if previous != nil {
+			<-previous
+		}
+		s.recordLoop(st, sub, path, publishingType == "append")
+	}()
+}
+
+// recordingDone removes the finalized recording of the path, unless a newer one replaced it already.
+func (s *Server) recordingDone(path string, done chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(done)
+	if s.recordings[path] == done {
+		delete(s.recordings, path)
+	}
+}
+
+// recordLoop writes the packets of the subscriber into the recording until the stream is closed,
+// then finalizes the file.
+func (s *Session) recordLoop(st *Stream, sub *Subscriber, path string, appendFile bool) {
+	var rec *flv.Recorder
+	var err error
+	if appendFile {
+		rec, err = flv.Append(path)
+	} else {
+		rec, err = flv.Create(path)
+	}
+	if err != nil {
+		s.logln("can't record stream", st.app, st.key, err)
+		st.Unsubscribe(sub)
+		return
+	}
+
+	var timestamps TimestampRebaser
+	for p := range sub.Packets() {
+		if err != nil {
+			// Keep draining the queue, the recording stops at the first error
+			continue
+		}
+		timestamp := timestamps.Rebase(p)
+		if p.Type == TypeDataAMF0 {
+			if metadata := st.Metadata(); metadata != nil {
+				err = rec.WriteMetadata(timestamp, metadata.Raw)
+			}
+		} else {
+			err = rec.WriteTag(&flv.Tag{Type: p.Type, Timestamp: timestamp, Data: p.Payload})
+		}
+		if err != nil {
+			s.logln("error recording", rec.Path(), err)
+			st.Unsubscribe(sub)
+		}
+	}
+	if sub.Err() == ErrSubscriberTooSlow {
+		s.logln("recording", rec.Path(), "could not keep up with the stream, it is stopped")
+	}
+
+	if err := rec.Close(); err != nil {
+		s.logln("error finalizing recording", rec.Path(), err)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var ErrStreamExists = errors.New("registry: the stream key is already published")
+
+// Registry keeps track of the live streams by app and stream key. It is safe for concurrent use.
+type Registry struct {
+	// Limits of the GOP cache of the new streams
+	gopCacheFrames int
+	gopCacheBytes  int
+
+	mu      sync.Mutex
+	streams map[streamID]*Stream
+	// Called for every accepted publish
+	publishListeners []func(st *Stream)
+}
+
+// NewRegistry creates an empty Registry with the default GOP cache limits.
+func NewRegistry() *Registry {
+	return &Registry{
+		gopCacheFrames: DefaultGOPCacheFrames,
+		gopCacheBytes:  DefaultGOPCacheBytes,
+		streams:        make(map[streamID]*Stream),
+	}
+}
+
+// streamID identifies a stream in the registry. Both the app and the stream key could contain slashes,
+// so they are kept apart.
+type streamID struct {
+	app string
+	key string
+}
+
+// Publish registers a new stream published by the session.
+// Only one session could publish the same stream key in an app, ErrStreamExists is returned for the others.
+func (r *Registry) Publish(app string, key string, publisher *Session) (*Stream, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := streamID{app: app, key: key}
+	if _, ok := r.streams[id]; ok {
+		return nil, ErrStreamExists
+	}
+	st := newStream(app, key, publisher)
+	st.gop.maxFrames = r.gopCacheFrames
+	st.gop.maxBytes = r.gopCacheBytes
+	r.streams[id] = st
+	return st, nil
+}
+
+// OnPublish registers a function called with every new stream, once the publish is accepted.
+// It is called from the publisher's goroutine, so it should subscribe to the stream and return quickly.
+func (r *Registry) OnPublish(fn func(st *Stream)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.publishListeners = append(r.publishListeners, fn)
+}
+
+// notifyPublish calls the OnPublish listeners with the accepted stream.
+func (r *Registry) notifyPublish(st *Stream) {
+	r.mu.Lock()
+	listeners := r.publishListeners
+	r.mu.Unlock()
+	for _, fn := range listeners {
+		fn(st)
+	}
+}
+
+// Remove unregisters the stream, if it is still the registered one for its key.
+func (r *Registry) Remove(st *Stream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := streamID{app: st.app, key: st.key}
+	if r.streams[id] == st {
+		delete(r.streams, id)
+	}
+}
+
+// Get returns the live stream published with the stream key in the app, or nil if there is none.
+func (r *Registry) Get(app string, key string) *Stream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.streams[streamID{app: app, key: key}]
+}
+
+// Streams returns the live streams ordered by app and stream key.
+func (r *Registry) Streams() []*Stream {
+	r.mu.Lock()
+	streams := make([]*Stream, 0, len(r.streams))
+	for _, st := range r.streams {
+		streams = append(streams, st)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(streams, func(i, j int) bool {
+		if streams[i].app != streams[j].app {
+			return streams[i].app < streams[j].app
+		}
+		return streams[i].key < streams[j].key
+	})
+	return streams
+}
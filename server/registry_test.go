@@ -0,0 +1,106 @@
+package server
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRegistryPublish(t *testing.T) {
+	r := NewRegistry()
+	st, err := r.Publish("live", "test", nil)
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if _, err := r.Publish("live", "test", nil); err != ErrStreamExists {
+		t.Errorf("second Publish() error = %v, want %v", err, ErrStreamExists)
+	}
+	// The same stream key in another app is another stream
+	if _, err := r.Publish("other", "test", nil); err != nil {
+		t.Errorf("Publish() in another app error = %v", err)
+	}
+	if got := r.Get("live", "test"); got != st {
+		t.Errorf("Get() = %p, want %p", got, st)
+	}
+	// The slashes in the app or the stream key don't make the streams collide
+	if _, err := r.Publish("a", "b/c", nil); err != nil {
+		t.Errorf("Publish() error = %v", err)
+	}
+	if _, err := r.Publish("a/b", "c", nil); err != nil {
+		t.Errorf("Publish() with the same joined path error = %v", err)
+	}
+	if got := r.Get("live", "missing"); got != nil {
+		t.Errorf("Get() of a missing stream = %p", got)
+	}
+
+	r.Remove(st)
+	if got := r.Get("live", "test"); got != nil {
+		t.Errorf("Get() after Remove = %p", got)
+	}
+	republished, err := r.Publish("live", "test", nil)
+	if err != nil {
+		t.Fatalf("Publish() after Remove error = %v", err)
+	}
+	// The old stream doesn't remove the new one
+	r.Remove(st)
+	if got := r.Get("live", "test"); got != republished {
+		t.Errorf("Get() after removing the old stream = %p, want %p", got, republished)
+	}
+}
+
+func TestRegistryConcurrentPublish(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	published := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Publish("live", "test", nil); err == nil {
+				mu.Lock()
+				published++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if published != 1 {
+		t.Errorf("%d publishes accepted, want 1", published)
+	}
+}
+
+func TestRegistryStreams(t *testing.T) {
+	r := NewRegistry()
+	for _, id := range [][2]string{{"live", "b"}, {"app", "z"}, {"live", "a"}} {
+		if _, err := r.Publish(id[0], id[1], nil); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	var got []string
+	for _, st := range r.Streams() {
+		got = append(got, st.App()+"/"+st.Key())
+	}
+	if want := []string{"app/z", "live/a", "live/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Streams() = %v, want %v", got, want)
+	}
+}
+
+func TestRegistryOnPublish(t *testing.T) {
+	r := NewRegistry()
+	var got []string
+	r.OnPublish(func(st *Stream) { got = append(got, "first "+st.Key()) })
+	r.OnPublish(func(st *Stream) { got = append(got, "second "+st.Key()) })
+
+	st, err := r.Publish("live", "test", nil)
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("listeners called before the publish is accepted: %v", got)
+	}
+	r.notifyPublish(st)
+	if want := []string{"first test", "second test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listeners called %v, want %v", got, want)
+	}
+}
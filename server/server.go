@@ -0,0 +1,258 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/torresjeff/rtmp/rand"
+)
+
+// DefaultWindowAckSize is the acknowledgement window announced to the clients.
+const DefaultWindowAckSize = 2500000
+
+// DefaultAddr is the address used by ListenAndServe when no address was given.
+const DefaultAddr = ":1935"
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close was called.
+var ErrServerClosed = errors.New("server: closed")
+
+// Server accepts RTMP connections and runs a Session for each of them.
+type Server struct {
+	// stats is accessed atomically, keep it first for 64 bit alignment
+	stats Stats
+
+	addr    string
+	logger  *log.Logger
+	debug   bool
+	handler Handler
+	// registry of the live streams, it has its own lock
+	registry      *Registry
+	authenticator Authenticator
+	webhooks      *Webhooks
+	recordDir     string
+
+	windowAckSize uint32
+
+	handshakeTimeout time.Duration
+	connectTimeout   time.Duration
+	idleTimeout      time.Duration
+	writeTimeout     time.Duration
+
+	mu       sync.Mutex
+	listener net.Listener
+	sessions map[*Session]struct{}
+	closed   bool
+	// wg counts the sessions and the recordings
+	wg sync.WaitGroup
+	// recordings has a channel for every recorded file, closed when its recording is finalized
+	recordings map[string]chan struct{}
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithLogger sets the logger used for connection and protocol messages.
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithHandler sets the Handler receiving the events of every session.
+func WithHandler(handler Handler) Option {
+	return func(s *Server) {
+		s.handler = handler
+	}
+}
+
+// WithWindowAckSize sets the acknowledgement window announced to the clients, they acknowledge the bytes we send
+// after every window. The received bytes are acknowledged in the window of the client.
+func WithWindowAckSize(size uint32) Option {
+	return func(s *Server) {
+		s.windowAckSize = size
+	}
+}
+
+// WithDebug enables verbose logging of every chunk and command received.
+func WithDebug(debug bool) Option {
+	return func(s *Server) {
+		s.debug = debug
+	}
+}
+
+// New creates a Server listening on addr once ListenAndServe is called.
+func New(addr string, opts ...Option) *Server {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	s := &Server{
+		addr:       addr,
+		logger:     log.New(os.Stdout, "", 0),
+		handler:    NopHandler{},
+		registry:   NewRegistry(),
+		sessions:   make(map[*Session]struct{}),
+		recordings: make(map[string]chan struct{}),
+
+		windowAckSize: DefaultWindowAckSize,
+
+		handshakeTimeout: DefaultHandshakeTimeout,
+		connectTimeout:   DefaultConnectTimeout,
+		idleTimeout:      DefaultIdleTimeout,
+		writeTimeout:     DefaultWriteTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ListenAndServe listens on the configured address and serves incoming connections until Close is called.
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on the listener and handles each of them in a new goroutine.
+// The listener is closed when Serve returns.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+	defer listener.Close()
+
+	// Loop infinitely, accepting any incoming connection. Every new connection will create a new session.
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		sess := newSession(s, rand.GenerateSessionId(), conn)
+		if !s.track(sess) {
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(sess)
+			sess.run()
+		}()
+	}
+}
+
+// Addr returns the address the server is listening on, or nil if it is not serving yet.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops accepting new connections, closes all the running sessions and waits for them to finish,
+// and for the recordings to be finalized.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for sess := range s.sessions {
+		_ = sess.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// Stats contains the counters of a Server.
+type Stats struct {
+	// Sessions is the number of currently open connections
+	Sessions uint64
+	// Connections closed because they hit the deadline of the given phase
+	HandshakeTimeouts uint64
+	ConnectTimeouts   uint64
+	IdleTimeouts      uint64
+	// Bytes received from and sent to all the clients since the server started
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// Stats returns a snapshot of the server counters.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	sessions := len(s.sessions)
+	s.mu.Unlock()
+
+	return Stats{
+		Sessions:          uint64(sessions),
+		HandshakeTimeouts: atomic.LoadUint64(&s.stats.HandshakeTimeouts),
+		ConnectTimeouts:   atomic.LoadUint64(&s.stats.ConnectTimeouts),
+		IdleTimeouts:      atomic.LoadUint64(&s.stats.IdleTimeouts),
+		BytesRead:         atomic.LoadUint64(&s.stats.BytesRead),
+		BytesWritten:      atomic.LoadUint64(&s.stats.BytesWritten),
+	}
+}
+
+// Sessions returns the currently open sessions.
+func (s *Server) Sessions() []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// Registry returns the registry of the live streams published to the server.
+func (s *Server) Registry() *Registry {
+	return s.registry
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) track(sess *Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.sessions[sess] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sess)
+}
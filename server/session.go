@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/codec"
+	"github.com/torresjeff/rtmp/amf/amf0"
+	"github.com/torresjeff/rtmp/audio"
+	"github.com/torresjeff/rtmp/video"
+)
+
+// Session is a single RTMP connection accepted by the Server.
+type Session struct {
+	// Byte counters are accessed atomically, keep them first for 64 bit alignment
+	bytesRead    uint64
+	bytesWritten uint64
+
+	server *Server
+	id     uint32
+	conn   net.Conn
+
+	connReader  *bufio.Reader
+	connWriter  *bufio.Writer
+	chunkReader *ChunkReader
+	chunkWriter *ChunkWriter
+
+	// Acknowledgement window the client asked for, 0 until it sends one
+	peerWindowAckSize uint32
+	// Number of received bytes when we sent the last acknowledgement
+	lastAcknowledged uint64
+	// Last timestamp passed to the handler for each message type, to keep them monotonic
+	lastTimestamps map[uint8]uint64
+
+	phase        phase
+	phaseStarted time.Time
+
+	connected   bool
+	connectedAt time.Time
+	app         string
+	tcURL       string
+	// Query parameters of the app and the tcUrl
+	query          url.Values
+	objectEncoding float64
+	publishing     bool
+	streamKey      string
+	// stream is the live stream published by the session
+	stream *Stream
+	// aac is the AudioSpecificConfig of the published AAC audio
+	aac *codec.AudioSpecificConfig
+
+	// Stream played by the session, and the message stream ID the player asked for it on
+	playing      *Stream
+	subscriber   *Subscriber
+	playStreamID uint32
+	// playDone is closed when the goroutine sending the packets to the player returns
+	playDone chan struct{}
+
+	// mu guards the fields read by other goroutines too
+	mu       sync.Mutex
+	metadata *StreamMetadata
+
+	// closeErr is set when the session should be closed after the current message, like on a rejected command
+	closeErr error
+
+	// writeMu serializes the writes of the session and the player goroutine
+	writeMu sync.Mutex
+}
+
+func newSession(server *Server, id uint32, conn net.Conn) *Session {
+	s := &Session{
+		server:         server,
+		id:             id,
+		conn:           conn,
+		lastTimestamps: make(map[uint8]uint64),
+	}
+	s.connReader = bufio.NewReaderSize(countingReader{conn, &s.bytesRead, &server.stats.BytesRead}, 1024*64)
+	s.connWriter = bufio.NewWriterSize(countingWriter{conn, &s.bytesWritten, &server.stats.BytesWritten}, 1024*64)
+	s.chunkReader = NewChunkReader(s.connReader)
+	s.chunkReader.OnProtocolViolation = s.protocolViolation
+	s.chunkWriter = NewChunkWriter(s.connWriter)
+	return s
+}
+
+// ID returns the unique identifier of the session.
+func (s *Session) ID() uint32 {
+	return s.id
+}
+
+// RemoteAddr returns the address of the connected client.
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// App returns the app the client connected to.
+func (s *Session) App() string {
+	return s.app
+}
+
+// StreamKey returns the key of the stream published by the client, or an empty string if it is not publishing.
+func (s *Session) StreamKey() string {
+	return s.streamKey
+}
+
+// Metadata returns the last onMetaData of the published stream, or nil if the client didn't send any.
+func (s *Session) Metadata() *StreamMetadata {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.metadata
+}
+
+// Close closes the underlying connection, which makes the session stop.
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// writeMessage queues a message to the client, it is sent on the next flush.
+func (s *Session) writeMessage(m *Message) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	// The buffered writer writes to the connection too once it is full
+	s.extendWriteDeadline()
+	if err := s.chunkWriter.WriteMessage(m); err != nil {
+		s.logln("error writing message:", err)
+	}
+}
+
+func (s *Session) flush() {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.extendWriteDeadline()
+	if err := s.connWriter.Flush(); err != nil {
+		s.logln("error flushing messages:", err)
+	}
+}
+
+func (s *Session) logln(v ...interface{}) {
+	s.server.logger.Println(v...)
+}
+
+func (s *Session) debugln(v ...interface{}) {
+	if s.server.debug {
+		s.server.logger.Println(v...)
+	}
+}
+
+func (s *Session) run() {
+	err := s.serve()
+	_ = s.conn.Close()
+	if err == io.EOF {
+		err = nil
+	}
+
+	if s.publishing {
+		s.unpublish()
+	}
+	if s.playing != nil {
+		s.stopPlaying()
+	}
+	if s.connected {
+		s.server.handler.OnDisconnect(s, err)
+
+		event := s.webhookEvent(WebhookDisconnect, s.streamKey)
+		event.Duration = time.Since(s.connectedAt).Seconds()
+		event.BytesRead = s.BytesRead()
+		event.BytesWritten = s.BytesWritten()
+		s.notifyWebhook(event)
+	}
+}
+
+func (s *Session) serve() error {
+	s.enterPhase(phaseHandshake)
+	if err := s.extendDeadline(); err != nil {
+		return err
+	}
+	err := Handshake(s.connReader, s.connWriter)
+	if err == io.EOF {
+		return err
+	} else if s.checkTimeout(err) {
+		return err
+	} else if err != nil {
+		s.logln("handshake error:", err)
+		return err
+	}
+
+	s.debugln("Handshake done")
+	s.enterPhase(phaseConnect)
+
+	for {
+		if err := s.extendDeadline(); err != nil {
+			return err
+		}
+		m, err := s.chunkReader.ReadMessage()
+		if s.checkTimeout(err) {
+			return err
+		} else if err == io.EOF {
+			return err
+		} else if err != nil {
+			s.logln("message read error", err)
+			return err
+		}
+		s.acknowledge()
+
+		s.handleMessage(m)
+		if s.closeErr != nil {
+			return s.closeErr
+		}
+	}
+}
+
+// handleMessage dispatches a complete message by its type.
+func (s *Session) handleMessage(m *Message) {
+	s.debugln("Message size", len(m.Payload), "chunk stream", m.ChunkStreamID, "message stream", m.StreamID)
+	s.debugln("Type ID:", m.TypeID)
+	switch m.TypeID {
+	case TypeSetChunkSize, TypeAbort, TypeAcknowledgement, TypeUserControl, TypeWindowAckSize, TypeSetPeerBandwidth:
+		s.handleProtocolControl(m)
+	case TypeCommandAMF0, TypeCommandAMF3:
+		s.handleCommandMessage(m)
+	case TypeDataAMF0, TypeDataAMF3:
+		s.handleDataMessage(m)
+	case TypeAudio:
+		s.handleAudioMessage(m.ChunkStreamID, m.StreamID, m.Payload, s.mediaTimestamp(m))
+	case TypeVideo:
+		s.handleVideoMessage(m.ChunkStreamID, m.StreamID, m.Payload, s.mediaTimestamp(m))
+	case TypeAggregate:
+		s.handleAggregateMessage(m)
+	default:
+		s.debugln("unhandled message type", m.TypeID)
+	}
+	s.debugln() // empty line
+}
+
+// mediaTimestamp returns the unwrapped timestamp of the message, never going backwards for the same message type.
+// Some encoders jump back a few milliseconds (mostly on audio resync), the muxers need monotonic timestamps.
+func (s *Session) mediaTimestamp(m *Message) uint64 {
+	timestamp := m.AbsoluteTimestamp
+	if last, ok := s.lastTimestamps[m.TypeID]; ok && timestamp < last {
+		s.debugln("timestamp went backwards", timestamp, "<", last)
+		timestamp = last
+	}
+	s.lastTimestamps[m.TypeID] = timestamp
+	return timestamp
+}
+
+// unpublish notifies the handler that the current stream is not published anymore.
+func (s *Session) unpublish() {
+	event := s.webhookEvent(WebhookUnpublish, s.streamKey)
+	event.Duration = time.Since(s.stream.StartedAt()).Seconds()
+
+	s.publishing = false
+	s.lastTimestamps = make(map[uint8]uint64)
+	s.aac = nil
+	s.mu.Lock()
+	s.metadata = nil
+	s.mu.Unlock()
+	s.server.registry.Remove(s.stream)
+	s.stream.close()
+	s.stream = nil
+	s.enterPhase(phaseConnect)
+	s.server.handler.OnUnpublish(s, s.streamKey)
+	s.notifyWebhook(event)
+}
+
+func (s *Session) handleAudioMessage(chunkStreamID uint32, messageStreamID uint32, payload []byte, timestamp uint64) {
+	if len(payload) == 0 {
+		return
+	}
+	header := parseAudioHeader(payload)
+
+	if s.publishing && header.Format == audio.AAC && header.AACPacketType == audio.AACSequenceHeader && len(payload) > 2 {
+		s.handleAACSequenceHeader(payload[2:])
+	}
+	if s.publishing && s.aac != nil && header.Format == audio.AAC {
+		// The FLV header of AAC always says 44 kHz stereo, the AudioSpecificConfig has the real values
+		s.debugln("Format", header.Format, "Object type", s.aac.ObjectType, "Sample rate", s.aac.OutputSampleRate(), "Channels", s.aac.Channels())
+	} else {
+		s.debugln("Format", header.Format, "Sample rate", header.SampleRate, "Sample size", header.SampleSize, "Channels", header.Channels)
+	}
+
+	if s.publishing {
+		s.server.handler.OnAudio(s, s.streamKey, header, payload, timestamp)
+		s.stream.writePacket(&Packet{Type: TypeAudio, Timestamp: timestamp, Payload: payload})
+	}
+}
+
+func (s *Session) handleVideoMessage(csID uint32, messageStreamID uint32, payload []byte, timestamp uint64) {
+	if len(payload) == 0 {
+		return
+	}
+	header := parseVideoHeader(payload)
+
+	s.debugln("Frame Type", header.FrameType, "Codec", header.Codec, "Frame size", len(payload), "ts", timestamp)
+
+	if s.publishing {
+		if header.Codec == video.H264 && header.AVCPacketType == video.AVCSequenceHeader && len(payload) > 5 {
+			s.handleAVCSequenceHeader(payload[5:])
+		}
+		s.server.handler.OnVideo(s, s.streamKey, header, payload, timestamp)
+		s.stream.writePacket(&Packet{Type: TypeVideo, Timestamp: timestamp, Payload: payload})
+	}
+}
+
+// handleAVCSequenceHeader decodes the AVCDecoderConfigurationRecord and its SPS for the codec info of the stream.
+func (s *Session) handleAVCSequenceHeader(data []byte) {
+	avc, err := codec.ParseAVCDecoderConfigurationRecord(data)
+	if err != nil {
+		s.logln("invalid AVC sequence header:", err)
+		return
+	}
+	sps, err := avc.DecodeSPS()
+	if err != nil {
+		s.logln("invalid SPS:", err)
+	} else {
+		s.debugln("H.264", sps.ProfileName(), "profile, level", sps.Level(), sps.ChromaFormat(), sps.Width, "x", sps.Height,
+			"fps", sps.FrameRate, "SAR", sps.SARWidth, ":", sps.SARHeight, "NALU length size", avc.NALULengthSize)
+	}
+	s.stream.setAVCConfig(avc, sps)
+}
+
+// handleAACSequenceHeader parses the AudioSpecificConfig of the AAC audio for the codec info of the stream.
+func (s *Session) handleAACSequenceHeader(data []byte) {
+	asc, err := codec.ParseAudioSpecificConfig(data)
+	if err != nil {
+		s.logln("invalid AAC sequence header:", err)
+		return
+	}
+	s.debugln("AAC object type", asc.ObjectType, "sample rate", asc.SampleRate, "channel configuration", asc.ChannelConfiguration,
+		"frame length", asc.FrameLength, "SBR", asc.SBR, "PS", asc.PS, "output sample rate", asc.OutputSampleRate())
+	s.aac = asc
+	s.stream.setAACConfig(asc)
+}
+
+// handleDataMessage handles AMF0 and AMF3 data messages, for now only the stream metadata.
+func (s *Session) handleDataMessage(m *Message) {
+	payload := m.Payload
+	if m.TypeID == TypeDataAMF3 && len(payload) > 0 {
+		// AMF3 data messages start with a format selector byte, like the AMF3 command messages
+		payload = payload[1:]
+	}
+	if !s.publishing || len(payload) == 0 {
+		return
+	}
+	values, err := decodeAMF0Values(payload)
+	if err != nil {
+		s.logln("amf decode error", err)
+		return
+	}
+
+	// Encoders send the metadata either wrapped in a @setDataFrame or as a plain onMetaData
+	if len(values) > 0 && values[0] == "@setDataFrame" {
+		values = values[1:]
+	}
+	if len(values) < 2 || values[0] != "onMetaData" {
+		s.debugln("data message", values)
+		return
+	}
+
+	var properties map[string]interface{}
+	switch v := values[1].(type) {
+	case map[string]interface{}:
+		properties = v
+	case amf0.ECMAArray:
+		properties = v
+	default:
+		return
+	}
+
+	s.debugln("onMetaData", properties)
+	metadata := ParseStreamMetadata(properties)
+	s.mu.Lock()
+	s.metadata = metadata
+	s.mu.Unlock()
+	s.server.handler.OnMetadata(s, s.streamKey, metadata)
+	s.stream.setMetadata(metadata, m.AbsoluteTimestamp)
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gerifield/mini-stream-test/codec"
+	"github.com/torresjeff/rtmp/audio"
+	"github.com/torresjeff/rtmp/video"
+)
+
+// DefaultSubscriberQueueSize is the number of packets buffered for every subscriber of a stream.
+const DefaultSubscriberQueueSize = 1024
+
+var (
+	ErrStreamUnpublished = errors.New("stream: publisher stopped publishing")
+	ErrSubscriberTooSlow = errors.New("stream: subscriber queue is full")
+	ErrNotAVCFrame       = errors.New("stream: packet is not an H.264 frame")
+)
+
+// Packet is an audio, video or data message of a published stream.
+type Packet struct {
+	// TypeAudio, TypeVideo or TypeDataAMF0
+	Type      uint8
+	Timestamp uint64
+	Payload   []byte
+}
+
+// IsSequenceHeader reports whether the packet is an AAC or AVC sequence header, which the decoders need before any frame.
+func (p *Packet) IsSequenceHeader() bool {
+	switch p.Type {
+	case TypeAudio:
+		return len(p.Payload) > 1 && audio.Format(p.Payload[0]>>4) == audio.AAC && p.Payload[1] == 0
+	case TypeVideo:
+		return len(p.Payload) > 1 && video.Codec(p.Payload[0]&0x0F) == video.H264 && p.Payload[1] == 0
+	}
+	return false
+}
+
+// IsKeyFrame reports whether the packet is a video key frame.
+func (p *Packet) IsKeyFrame() bool {
+	return p.Type == TypeVideo && len(p.Payload) > 0 && video.FrameType(p.Payload[0]>>4) == video.KeyFrame
+}
+
+// VideoFrame splits an H.264 frame into its NAL units with the NALU length size of avc, the decoder configuration
+// of the stream (CodecInfo().AVC). ErrNotAVCFrame is returned for the other packets, including the sequence headers.
+// The NAL units point into the payload, which is shared by every subscriber: they should not be modified.
+func (p *Packet) VideoFrame(avc *codec.AVCDecoderConfigurationRecord) (*codec.VideoFrame, error) {
+	if p.Type != TypeVideo || len(p.Payload) < 5 || avc == nil {
+		return nil, ErrNotAVCFrame
+	}
+	h := parseVideoHeader(p.Payload)
+	if h.Codec != video.H264 || h.AVCPacketType != video.AVCNALU {
+		return nil, ErrNotAVCFrame
+	}
+	f, err := codec.ParseVideoFrame(p.Payload[5:], avc.NALULengthSize)
+	if f != nil {
+		f.KeyFrame = h.FrameType == video.KeyFrame
+		f.CompositionTime = h.CompositionTime
+	}
+	return f, err
+}
+
+// CodecInfo describes the codecs of a stream, as seen in its audio and video packets.
+type CodecInfo struct {
+	HasVideo   bool
+	VideoCodec video.Codec
+	// AVC is the decoder configuration of the last H.264 sequence header, SPS is its first SPS decoded.
+	// SPS is nil if it couldn't be decoded.
+	AVC *codec.AVCDecoderConfigurationRecord
+	SPS *codec.SPS
+
+	HasAudio   bool
+	AudioCodec audio.Format
+	// The sample rate, size and channels of the FLV audio header. They are fixed values for AAC
+	// (44 kHz, 16 bit, stereo), the real ones are in AAC, the config of the last AAC sequence header.
+	AudioSampleRate audio.SampleRate
+	AudioSampleSize audio.SampleSize
+	AudioChannels   audio.Channel
+	AAC             *codec.AudioSpecificConfig
+}
+
+// Stream is a live stream published by a session. Every subscriber gets its own copy of the packets
+// through a buffered queue, so a slow subscriber doesn't block the publisher or the other subscribers.
+type Stream struct {
+	app       string
+	key       string
+	publisher *Session
+	startedAt time.Time
+
+	mu        sync.Mutex
+	metadata  *StreamMetadata
+	codecInfo CodecInfo
+	// The last sequence headers, every new subscriber gets them first
+	audioSequenceHeader *Packet
+	videoSequenceHeader *Packet
+	// Packets since the last key frame
+	gop         gopCache
+	subscribers map[*Subscriber]struct{}
+	closed      bool
+}
+
+func newStream(app string, key string, publisher *Session) *Stream {
+	return &Stream{
+		app:         app,
+		key:         key,
+		publisher:   publisher,
+		startedAt:   time.Now(),
+		subscribers: make(map[*Subscriber]struct{}),
+	}
+}
+
+// App returns the app the stream is published in.
+func (st *Stream) App() string {
+	return st.app
+}
+
+// Key returns the stream key.
+func (st *Stream) Key() string {
+	return st.key
+}
+
+// Publisher returns the session publishing the stream.
+func (st *Stream) Publisher() *Session {
+	return st.publisher
+}
+
+// StartedAt returns when the publisher started publishing.
+func (st *Stream) StartedAt() time.Time {
+	return st.startedAt
+}
+
+// CodecInfo returns the codecs of the audio and video packets received so far.
+func (st *Stream) CodecInfo() CodecInfo {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.codecInfo
+}
+
+// Subscribe adds a new subscriber to the stream. The metadata, the sequence headers and the cached GOP
+// are queued right away, followed by the live packets.
+func (st *Stream) Subscribe() *Subscriber {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// The queue has room for the cached packets on top of the usual size, queueing them never blocks
+	sub := &Subscriber{packets: make(chan *Packet, DefaultSubscriberQueueSize+len(st.gop.packets)+3)}
+	if st.closed {
+		sub.close(ErrStreamUnpublished)
+		return sub
+	}
+	if st.metadata != nil {
+		sub.packets <- &Packet{Type: TypeDataAMF0, Payload: st.metadata.Payload()}
+	}
+	if st.videoSequenceHeader != nil {
+		sub.packets <- st.videoSequenceHeader
+	}
+	if st.audioSequenceHeader != nil {
+		sub.packets <- st.audioSequenceHeader
+	}
+	for _, p := range st.gop.packets {
+		sub.packets <- p
+	}
+	st.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes the subscriber from the stream and closes its queue.
+func (st *Stream) Unsubscribe(sub *Subscriber) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.subscribers[sub]; ok {
+		delete(st.subscribers, sub)
+		sub.close(nil)
+	}
+}
+
+// Subscribers returns the number of subscribers.
+func (st *Stream) Subscribers() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.subscribers)
+}
+
+// Metadata returns the last metadata of the stream, or nil if the publisher didn't send any.
+func (st *Stream) Metadata() *StreamMetadata {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.metadata
+}
+
+// setMetadata replaces the metadata and sends it to the subscribers too.
+func (st *Stream) setMetadata(metadata *StreamMetadata, timestamp uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.metadata = metadata
+	st.broadcast(&Packet{Type: TypeDataAMF0, Timestamp: timestamp, Payload: metadata.Payload()})
+}
+
+// setAVCConfig sets the decoder configuration of the H.264 video.
+func (st *Stream) setAVCConfig(avc *codec.AVCDecoderConfigurationRecord, sps *codec.SPS) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.codecInfo.AVC = avc
+	st.codecInfo.SPS = sps
+}
+
+// setAACConfig sets the AudioSpecificConfig of the AAC audio.
+func (st *Stream) setAACConfig(asc *codec.AudioSpecificConfig) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.codecInfo.AAC = asc
+}
+
+// writePacket sends an audio or video packet to every subscriber.
+func (st *Stream) writePacket(p *Packet) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch p.Type {
+	case TypeAudio:
+		h := parseAudioHeader(p.Payload)
+		st.codecInfo.HasAudio = true
+		st.codecInfo.AudioCodec = h.Format
+		st.codecInfo.AudioSampleRate = h.SampleRate
+		st.codecInfo.AudioSampleSize = h.SampleSize
+		st.codecInfo.AudioChannels = h.Channels
+	case TypeVideo:
+		h := parseVideoHeader(p.Payload)
+		st.codecInfo.HasVideo = true
+		st.codecInfo.VideoCodec = h.Codec
+	}
+	if p.IsSequenceHeader() {
+		if p.Type == TypeAudio {
+			st.audioSequenceHeader = p
+		} else {
+			st.videoSequenceHeader = p
+		}
+	} else {
+		st.gop.add(p)
+	}
+	st.broadcast(p)
+}
+
+// broadcast queues the packet for every subscriber, the ones not keeping up are dropped. st.mu must be held.
+func (st *Stream) broadcast(p *Packet) {
+	for sub := range st.subscribers {
+		select {
+		case sub.packets <- p:
+		default:
+			delete(st.subscribers, sub)
+			sub.close(ErrSubscriberTooSlow)
+		}
+	}
+}
+
+// close closes the queue of every subscriber, called when the publisher stops.
+func (st *Stream) close() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.closed = true
+	for sub := range st.subscribers {
+		delete(st.subscribers, sub)
+		sub.close(ErrStreamUnpublished)
+	}
+}
+
+// TimestampRebaser makes the timestamps of a subscriber start from 0 at the first audio or video frame.
+// The packets before it (metadata and sequence headers) get 0.
+type TimestampRebaser struct {
+	base    uint64
+	started bool
+}
+
+// Rebase returns the timestamp of the packet relative to the first frame.
+func (r *TimestampRebaser) Rebase(p *Packet) uint32 {
+	if !r.started && (p.Type == TypeAudio || p.Type == TypeVideo) && !p.IsSequenceHeader() {
+		r.base = p.Timestamp
+		r.started = true
+	}
+	if !r.started || p.Timestamp < r.base {
+		return 0
+	}
+	return uint32(p.Timestamp - r.base)
+}
+
+// Subscriber receives the packets of a Stream.
+type Subscriber struct {
+	packets chan *Packet
+	err     error
+}
+
+// Packets returns the queue of the subscriber. It is closed when the subscriber is removed from the stream,
+// Err tells the reason after that.
+func (sub *Subscriber) Packets() <-chan *Packet {
+	return sub.packets
+}
+
+// Err returns why the queue was closed: ErrStreamUnpublished, ErrSubscriberTooSlow, or nil after Unsubscribe.
+// It should only be called after the queue is closed.
+func (sub *Subscriber) Err() error {
+	return sub.err
+}
+
+func (sub *Subscriber) close(err error) {
+	sub.err = err
+	close(sub.packets)
+}
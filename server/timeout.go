@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Default deadlines of the connection phases, see WithHandshakeTimeout, WithConnectTimeout and WithIdleTimeout,
+// and of the writes, see WithWriteTimeout.
+const (
+	DefaultHandshakeTimeout = 10 * time.Second
+	DefaultConnectTimeout   = 30 * time.Second
+	DefaultIdleTimeout      = 30 * time.Second
+	DefaultWriteTimeout     = 10 * time.Second
+)
+
+// phase is the part of the connection lifecycle a session is in, each of them has its own deadline.
+type phase int
+
+const (
+	// phaseHandshake lasts until the RTMP handshake is done
+	phaseHandshake phase = iota
+	// phaseConnect lasts until the client starts publishing
+	phaseConnect
+	// phaseMedia is the publishing part, every received message extends the deadline
+	phaseMedia
+	// phasePlay has no deadline, players could stay silent for a long time
+	phasePlay
+)
+
+func (p phase) String() string {
+	switch p {
+	case phaseHandshake:
+		return "handshake"
+	case phaseConnect:
+		return "connect"
+	case phaseMedia:
+		return "idle"
+	case phasePlay:
+		return "play"
+	default:
+		return "unknown"
+	}
+}
+
+// WithHandshakeTimeout sets how long a client has to finish the handshake after the TCP connection was accepted.
+// Zero disables the deadline.
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.handshakeTimeout = d
+	}
+}
+
+// WithConnectTimeout sets how long a client has to start publishing after the handshake.
+// Zero disables the deadline.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.connectTimeout = d
+	}
+}
+
+// WithIdleTimeout sets how long a publishing client could stay without sending anything.
+// Zero disables the deadline.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithWriteTimeout sets how long a write to a client could block, a client not reading (like a stalled player)
+// is disconnected after it. Zero disables the deadline.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.writeTimeout = d
+	}
+}
+
+// enterPhase moves the session to the next phase and starts its deadline.
+func (s *Session) enterPhase(p phase) {
+	s.phase = p
+	s.phaseStarted = time.Now()
+}
+
+// extendDeadline sets the read deadline of the connection according to the current phase.
+// It is called before every read, only the media phase deadline moves with the received data.
+func (s *Session) extendDeadline() error {
+	var timeout time.Duration
+	start := s.phaseStarted
+	switch s.phase {
+	case phaseHandshake:
+		timeout = s.server.handshakeTimeout
+	case phaseConnect:
+		timeout = s.server.connectTimeout
+	case phaseMedia:
+		timeout = s.server.idleTimeout
+		start = time.Now()
+	}
+
+	if timeout <= 0 {
+		return s.conn.SetReadDeadline(time.Time{})
+	}
+	return s.conn.SetReadDeadline(start.Add(timeout))
+}
+
+// extendWriteDeadline sets the write deadline of the connection for the next write. s.writeMu must be held.
+func (s *Session) extendWriteDeadline() {
+	if s.server.writeTimeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.server.writeTimeout))
+	}
+}
+
+// checkTimeout logs and counts the error if it was caused by an expired deadline.
+func (s *Session) checkTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	if !ok || !netErr.Timeout() {
+		return false
+	}
+
+	s.logln("closing connection from", s.RemoteAddr(), "reason:", s.phase, "timeout")
+	switch s.phase {
+	case phaseHandshake:
+		atomic.AddUint64(&s.server.stats.HandshakeTimeouts, 1)
+	case phaseConnect:
+		atomic.AddUint64(&s.server.stats.ConnectTimeouts, 1)
+	case phaseMedia:
+		atomic.AddUint64(&s.server.stats.IdleTimeouts, 1)
+	}
+	return true
+}
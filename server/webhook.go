@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Defaults of WebhookConfig
+const (
+	DefaultWebhookTimeout    = 5 * time.Second
+	DefaultWebhookRetryDelay = time.Second
+)
+
+var ErrWebhookRejected = errors.New("session: rejected by a webhook")
+
+// Webhook event names
+const (
+	WebhookConnect    = "connect"
+	WebhookPublish    = "publish"
+	WebhookUnpublish  = "unpublish"
+	WebhookPlay       = "play"
+	WebhookDisconnect = "disconnect"
+)
+
+// WebhookConfig has the URLs called with a POST request on the session events, an empty URL disables the event.
+// The publish and play calls must answer with a 2xx status code, otherwise the command is rejected.
+type WebhookConfig struct {
+	OnConnect    string
+	OnPublish    string
+	OnUnpublish  string
+	OnPlay       string
+	OnDisconnect string
+
+	// Timeout of a single request, DefaultWebhookTimeout if zero
+	Timeout time.Duration
+	// Retries is the number of extra attempts after a network error or a 5xx status code
+	Retries int
+	// RetryDelay is the wait between the attempts, DefaultWebhookRetryDelay if zero
+	RetryDelay time.Duration
+	// Client sends the requests, a client with Timeout is used if nil
+	Client *http.Client
+}
+
+// WebhookEvent is the JSON body of the webhook requests.
+type WebhookEvent struct {
+	Event      string `json:"event"`
+	SessionID  uint32 `json:"session_id"`
+	ClientAddr string `json:"client_addr"`
+	App        string `json:"app"`
+	TcURL      string `json:"tc_url,omitempty"`
+	// StreamKey is set for publish, unpublish and play, and for disconnect if the client published a stream
+	StreamKey string `json:"stream_key,omitempty"`
+	// PublishingType is "live", "record" or "append" for publish
+	PublishingType string    `json:"publishing_type,omitempty"`
+	Time           time.Time `json:"time"`
+	// Duration is the length of the publishing for unpublish, and of the whole session for disconnect, in seconds
+	Duration     float64 `json:"duration,omitempty"`
+	BytesRead    uint64  `json:"bytes_read,omitempty"`
+	BytesWritten uint64  `json:"bytes_written,omitempty"`
+}
+
+// WebhookStatusError is returned by Post when the webhook answered with a non-2xx status code.
+type WebhookStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *WebhookStatusError) Error() string {
+	return fmt.Sprintf("webhook: %s answered %d", e.URL, e.StatusCode)
+}
+
+// Webhooks sends the session events to HTTP endpoints, like the on_publish and on_done of nginx-rtmp.
+type Webhooks struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// NewWebhooks creates the Webhooks with the config, the zero values are replaced with the defaults.
+func NewWebhooks(config WebhookConfig) *Webhooks {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultWebhookTimeout
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = DefaultWebhookRetryDelay
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+	return &Webhooks{config: config, client: client}
+}
+
+// WithWebhooks sets the webhooks called on the session events.
+func WithWebhooks(w *Webhooks) Option {
+	return func(s *Server) {
+		s.webhooks = w
+	}
+}
+
+// URL returns the configured URL of the event.
+func (w *Webhooks) URL(event string) string {
+	switch event {
+	case WebhookConnect:
+		return w.config.OnConnect
+	case WebhookPublish:
+		return w.config.OnPublish
+	case WebhookUnpublish:
+		return w.config.OnUnpublish
+	case WebhookPlay:
+		return w.config.OnPlay
+	case WebhookDisconnect:
+		return w.config.OnDisconnect
+	}
+	return ""
+}
+
+// Send posts the event to the URL configured for it. It returns nil if the event has no URL.
+func (w *Webhooks) Send(event *WebhookEvent) error {
+	url := w.URL(event.Event)
+	if url == "" {
+		return nil
+	}
+	return w.Post(url, event)
+}
+
+// Post sends the event as JSON to the URL. Network errors and 5xx status codes are retried,
+// the error of the last attempt is returned.
+func (w *Webhooks) Post(url string, event *WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = w.post(url, body)
+		statusErr, isStatus := err.(*WebhookStatusError)
+		retry := err != nil && (!isStatus || statusErr.StatusCode >= 500)
+		if !retry || attempt >= w.config.Retries {
+			return err
+		}
+		time.Sleep(w.config.RetryDelay)
+	}
+}
+
+func (w *Webhooks) post(url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	// Drain the body, so the connection could be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &WebhookStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// webhookEvent creates an event of the session with the common fields filled in.
+func (s *Session) webhookEvent(event string, streamKey string) *WebhookEvent {
+	return &WebhookEvent{
+		Event:      event,
+		SessionID:  s.id,
+		ClientAddr: s.RemoteAddr().String(),
+		App:        s.app,
+		TcURL:      s.tcURL,
+		StreamKey:  streamKey,
+		Time:       time.Now(),
+	}
+}
+
+// sendWebhook sends the event if the server has webhooks. It reports whether the webhook accepted it.
+// It blocks until the webhook answers (with the retries), only the events it could reject (publish and play)
+// should wait for it, see notifyWebhook.
+func (s *Session) sendWebhook(event *WebhookEvent) bool {
+	if s.server.webhooks == nil {
+		return true
+	}
+	if err := s.server.webhooks.Send(event); err != nil {
+		s.logln("webhook", event.Event, "error for", s.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
+
+// notifyWebhook sends an informational event (connect, unpublish or disconnect) in the background,
+// so a slow webhook doesn't hold up the session.
+func (s *Session) notifyWebhook(event *WebhookEvent) {
+	if s.server.webhooks == nil {
+		return
+	}
+	go s.sendWebhook(event)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookServer answers the requests with the status codes in order, the last one is repeated.
+func webhookServer(t *testing.T, delay time.Duration, statusCodes ...int) (*httptest.Server, *int32) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		var event WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("invalid webhook body: %v", err)
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook request %s with %q content type", r.Method, r.Header.Get("Content-Type"))
+		}
+		time.Sleep(delay)
+		if n > len(statusCodes) {
+			n = len(statusCodes)
+		}
+		w.WriteHeader(statusCodes[n-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestWebhooksSend(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCodes  []int
+		retries      int
+		wantStatus   int
+		wantRequests int32
+	}{
+		{name: "accept", statusCodes: []int{http.StatusOK}, wantRequests: 1},
+		{name: "accept with no content", statusCodes: []int{http.StatusNoContent}, retries: 2, wantRequests: 1},
+		{name: "reject", statusCodes: []int{http.StatusForbidden}, retries: 2, wantStatus: http.StatusForbidden, wantRequests: 1},
+		{name: "retry on 5xx", statusCodes: []int{http.StatusInternalServerError, http.StatusOK}, retries: 2, wantRequests: 2},
+		{name: "retries exhausted", statusCodes: []int{http.StatusServiceUnavailable}, retries: 2, wantStatus: http.StatusServiceUnavailable, wantRequests: 3},
+		{name: "no retries", statusCodes: []int{http.StatusBadGateway, http.StatusOK}, wantStatus: http.StatusBadGateway, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := webhookServer(t, 0, tt.statusCodes...)
+			w := NewWebhooks(WebhookConfig{OnPublish: srv.URL, Retries: tt.retries, RetryDelay: time.Millisecond})
+
+			err := w.Send(&WebhookEvent{Event: WebhookPublish, App: "live", StreamKey: "test"})
+			if tt.wantStatus == 0 && err != nil {
+				t.Errorf("Send() error = %v", err)
+			}
+			if tt.wantStatus != 0 {
+				if statusErr, ok := err.(*WebhookStatusError); !ok || statusErr.StatusCode != tt.wantStatus {
+					t.Errorf("Send() error = %v, want status %d", err, tt.wantStatus)
+				}
+			}
+			if got := atomic.LoadInt32(requests); got != tt.wantRequests {
+				t.Errorf("%d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestWebhooksTimeout(t *testing.T) {
+	srv, requests := webhookServer(t, 200*time.Millisecond, http.StatusOK)
+	w := NewWebhooks(WebhookConfig{OnPlay: srv.URL, Timeout: 20 * time.Millisecond, Retries: 1, RetryDelay: time.Millisecond})
+
+	start := time.Now()
+	err := w.Send(&WebhookEvent{Event: WebhookPlay})
+	if err == nil {
+		t.Fatal("Send() succeeded after the timeout")
+	}
+	if _, ok := err.(*WebhookStatusError); ok {
+		t.Errorf("Send() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("Send() returned after %v", elapsed)
+	}
+	// A timeout is retried like the other network errors
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("%d requests, want 2", got)
+	}
+}
+
+func TestWebhooksSendWithoutURL(t *testing.T) {
+	srv, requests := webhookServer(t, 0, http.StatusOK)
+	w := NewWebhooks(WebhookConfig{OnPublish: srv.URL})
+	if err := w.Send(&WebhookEvent{Event: WebhookConnect}); err != nil {
+		t.Errorf("Send() error = %v", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 0 {
+		t.Errorf("%d requests for an event without URL", got)
+	}
+}
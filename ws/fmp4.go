@@ -0,0 +1,244 @@
+package ws
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/gerifield/mini-stream-test/codec"
+	"github.com/gerifield/mini-stream-test/fmp4"
+	"github.com/gerifield/mini-stream-test/server"
+	"github.com/torresjeff/rtmp/audio"
+	"github.com/torresjeff/rtmp/video"
+)
+
+// Track IDs of the init segment
+const (
+	videoTrackID = 1
+	audioTrackID = 2
+)
+
+// audioOnlyAfter is how long the audio goes on without a video sequence header before the stream is played as audio only, in milliseconds
+const audioOnlyAfter = 2000
+
+// message is a WebSocket message to send.
+type message struct {
+	opcode byte
+	data   []byte
+}
+
+// fmp4Muxer remuxes the packets of a subscriber into a MIME type text message, an init segment
+// and a fragment for every video frame (or audio frame without video). It starts at a key frame.
+type fmp4Muxer struct {
+	avcConfig []byte
+	avc       *codec.AVCDecoderConfigurationRecord
+	asc       *codec.AudioSpecificConfig
+	ascData   []byte
+	// expectVideo is cleared if the stream only had audio so far, or the audio goes on without video
+	expectVideo bool
+	firstAudio  *uint64
+
+	started    bool
+	hasVideo   bool
+	hasAudio   bool
+	timestamps server.TimestampRebaser
+	// The last video frame, its duration is only known when the next one arrives
+	pending      *fmp4.Sample
+	pendingTime  uint64
+	lastDuration uint32
+	// Audio frames since the last fragment
+	audio     fmp4.TrackFragment
+	audioTime uint64
+	sequence  uint32
+}
+
+func newFMP4Muxer(codecs server.CodecInfo) *fmp4Muxer {
+	return &fmp4Muxer{
+		expectVideo: codecs.HasVideo || !codecs.HasAudio,
+		audio:       fmp4.TrackFragment{TrackID: audioTrackID},
+	}
+}
+
+// writePacket returns the messages to send for the packet.
+func (m *fmp4Muxer) writePacket(p *server.Packet) ([]message, error) {
+	switch p.Type {
+	case server.TypeVideo:
+		return m.writeVideo(p)
+	case server.TypeAudio:
+		return m.writeAudio(p)
+	}
+	return nil, nil
+}
+
+func (m *fmp4Muxer) writeVideo(p *server.Packet) ([]message, error) {
+	// Frame type and codec, AVC packet type, then the 24 bit composition time
+	if len(p.Payload) < 5 || video.Codec(p.Payload[0]&0x0F) != video.H264 {
+		return nil, nil
+	}
+	switch video.AVCPacketType(p.Payload[1]) {
+	case video.AVCSequenceHeader:
+		avc, err := codec.ParseAVCDecoderConfigurationRecord(p.Payload[5:])
+		if err != nil {
+			return nil, err
+		}
+		m.avc = avc
+		m.avcConfig = p.Payload[5:]
+		return nil, nil
+	case video.AVCNALU:
+	default:
+		return nil, nil
+	}
+	if m.avc == nil {
+		return nil, nil
+	}
+
+	var messages []message
+	keyFrame := p.IsKeyFrame()
+	timestamp := uint64(m.timestamps.Rebase(p))
+	if !m.started {
+		if !keyFrame {
+			return nil, nil
+		}
+		messages = m.start(true, timestamp)
+	}
+	if !m.hasVideo {
+		return nil, nil
+	}
+
+	decodeTime := timestamp * 90
+	if m.pending != nil {
+		m.pending.Duration = uint32(decodeTime - m.pendingTime)
+		m.lastDuration = m.pending.Duration
+		messages = append(messages, m.fragment())
+	}
+	compositionTime := int32(binary.BigEndian.Uint32(p.Payload[1:5])<<8) >> 8
+	m.pending = &fmp4.Sample{
+		CompositionOffset: compositionTime * 90,
+		KeyFrame:          keyFrame,
+		Data:              p.Payload[5:],
+	}
+	m.pendingTime = decodeTime
+	return messages, nil
+}
+
+func (m *fmp4Muxer) writeAudio(p *server.Packet) ([]message, error) {
+	if len(p.Payload) < 2 || audio.Format(p.Payload[0]>>4) != audio.AAC {
+		return nil, nil
+	}
+	if audio.AACPacketType(p.Payload[1]) == audio.AACSequenceHeader {
+		asc, err := codec.ParseAudioSpecificConfig(p.Payload[2:])
+		if err != nil {
+			return nil, err
+		}
+		m.asc = asc
+		m.ascData = p.Payload[2:]
+		return nil, nil
+	}
+	if m.asc == nil {
+		return nil, nil
+	}
+
+	var messages []message
+	timestamp := uint64(m.timestamps.Rebase(p))
+	if !m.started {
+		if m.expectVideo && m.avc == nil {
+			if m.firstAudio == nil {
+				m.firstAudio = &timestamp
+			} else if timestamp >= *m.firstAudio+audioOnlyAfter {
+				m.expectVideo = false
+			}
+		}
+		if m.expectVideo {
+			return nil, nil
+		}
+		messages = m.start(false, timestamp)
+	}
+	if !m.hasAudio {
+		return nil, nil
+	}
+
+	if len(m.audio.Samples) == 0 {
+		m.audio.BaseMediaDecodeTime = m.audioTime
+	}
+	// The decode time counts the samples, so the frames follow each other without gaps
+	m.audio.Samples = append(m.audio.Samples, fmp4.Sample{Duration: uint32(m.asc.FrameLength), KeyFrame: true, Data: p.Payload[2:]})
+	m.audioTime += uint64(m.asc.FrameLength)
+	if !m.hasVideo {
+		messages = append(messages, m.fragment())
+	}
+	return messages, nil
+}
+
+// start returns the MIME type and the init segment of the tracks. The tracks are fixed by then:
+// audio starting after the first key frame is left out.
+func (m *fmp4Muxer) start(hasVideo bool, timestamp uint64) []message {
+	m.started = true
+	m.hasVideo = hasVideo
+	m.hasAudio = m.asc != nil
+
+	var tracks []*fmp4.Track
+	var codecs []string
+	if m.hasVideo {
+		track := &fmp4.Track{
+			ID:        videoTrackID,
+			Type:      fmp4.TrackVideo,
+			TimeScale: fmp4.VideoTimeScale,
+			AVCConfig: m.avcConfig,
+		}
+		if sps, err := m.avc.DecodeSPS(); err == nil {
+			track.Width = sps.Width
+			track.Height = sps.Height
+		}
+		tracks = append(tracks, track)
+		codecs = append(codecs, m.avc.Codec())
+	}
+	if m.hasAudio {
+		tracks = append(tracks, &fmp4.Track{
+			ID:          audioTrackID,
+			Type:        fmp4.TrackAudio,
+			TimeScale:   uint32(m.asc.SampleRate),
+			SampleRate:  m.asc.SampleRate,
+			Channels:    m.asc.Channels(),
+			AudioConfig: m.ascData,
+		})
+		codecs = append(codecs, m.asc.Codec())
+		m.audioTime = timestamp * uint64(m.asc.SampleRate) / 1000
+	}
+
+	mimeType := "audio/mp4"
+	if m.hasVideo {
+		mimeType = "video/mp4"
+	}
+	mimeType += `; codecs="` + strings.Join(codecs, ",") + `"`
+	return []message{
+		{opcode: OpText, data: []byte(mimeType)},
+		{opcode: OpBinary, data: fmp4.InitSegment(tracks)},
+	}
+}
+
+// fragment returns the pending video frame and the audio frames collected since the last fragment.
+func (m *fmp4Muxer) fragment() message {
+	var fragments []fmp4.TrackFragment
+	if m.pending != nil {
+		fragments = append(fragments, fmp4.TrackFragment{
+			TrackID:             videoTrackID,
+			BaseMediaDecodeTime: m.pendingTime,
+			Samples:             []fmp4.Sample{*m.pending},
+		})
+		m.pending = nil
+	}
+	fragments = append(fragments, m.audio)
+	m.audio.Samples = nil
+	m.sequence++
+	return message{opcode: OpBinary, data: fmp4.Fragment(m.sequence, fragments)}
+}
+
+// close returns the last fragment, the last video frame is as long as the one before it.
+func (m *fmp4Muxer) close() []message {
+	if m.pending == nil && len(m.audio.Samples) == 0 {
+		return nil
+	}
+	if m.pending != nil {
+		m.pending.Duration = m.lastDuration
+	}
+	return []message{m.fragment()}
+}
@@ -0,0 +1,284 @@
+package ws
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes of the frames (RFC 6455 5.2)
+const (
+	OpText   = 0x1
+	OpBinary = 0x2
+	OpClose  = 0x8
+	OpPing   = 0x9
+	OpPong   = 0xA
+)
+
+// Status codes of the close frames (RFC 6455 7.4.1)
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseInternalError = 1011
+)
+
+// acceptGUID is appended to the key of the client to calculate Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxControlPayload is the longest payload of a control frame
+const maxControlPayload = 125
+
+// closeTimeout is how long Close waits for the close frame of the client
+const closeTimeout = 2 * time.Second
+
+// writeTimeout is how long writing a frame could block, the writes fail after it if the client doesn't read
+const writeTimeout = 10 * time.Second
+
+var (
+	ErrNotWebSocket     = errors.New("websocket: not a websocket handshake")
+	ErrUnmaskedFrame    = errors.New("websocket: client frame is not masked")
+	ErrControlTooLong   = errors.New("websocket: control frame is too long")
+	ErrConnectionClosed = errors.New("websocket: connection closed")
+)
+
+// Conn is the server side of a WebSocket connection. The messages of the client are read and dropped,
+// only the pings and the close frame are answered. It is safe to write from multiple goroutines.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+	// closeSent is set once a close frame was written, nothing is written after it
+	closeSent bool
+
+	// done is closed when the client closes the connection, or reading from it fails
+	done chan struct{}
+}
+
+// Upgrade answers the WebSocket handshake of the request and takes over the connection.
+// On an invalid handshake it responds with 400 Bad Request and returns ErrNotWebSocket.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "websocket handshake expected", http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, ErrNotWebSocket
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The deadlines of the HTTP server don't apply to the long lived connection
+	_ = conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &Conn{
+		conn: conn,
+		r:    rw.Reader,
+		w:    rw.Writer,
+		done: make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// headerContains reports whether the comma separated header has the token, case insensitively.
+func headerContains(h http.Header, name string, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Done returns a channel closed when the client closed the connection or it broke.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// RemoteAddr returns the address of the client.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// WriteMessage writes a message in a single frame.
+func (c *Conn) WriteMessage(opcode byte, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrame(opcode, data)
+}
+
+// writeFrame writes and flushes an unmasked final frame. c.writeMu must be held.
+func (c *Conn) writeFrame(opcode byte, data []byte) error {
+	if c.closeSent {
+		return ErrConnectionClosed
+	}
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(data); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, byte(n>>8), byte(n))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	// Big payloads are written to the connection directly, not only by the flush
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(data); err != nil {
+		return err
+	}
+	if opcode == OpClose {
+		c.closeSent = true
+	}
+	return c.w.Flush()
+}
+
+// Close sends a close frame with the status code and reason, waits a bit for the client to answer it,
+// then closes the connection.
+func (c *Conn) Close(code int, reason string) error {
+	c.writeMu.Lock()
+	err := c.writeFrame(OpClose, closePayload(code, reason))
+	c.writeMu.Unlock()
+	if err == nil {
+		select {
+		case <-c.done:
+		case <-time.After(closeTimeout):
+		}
+	}
+	if closeErr := c.conn.Close(); err == nil || err == ErrConnectionClosed {
+		err = closeErr
+	}
+	return err
+}
+
+// closePayload returns the payload of a close frame.
+func closePayload(code int, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	return payload
+}
+
+// readLoop reads the frames of the client until it closes the connection. The data frames are dropped.
+func (c *Conn) readLoop() {
+	defer close(c.done)
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			if err == ErrUnmaskedFrame || err == ErrControlTooLong {
+				c.writeMu.Lock()
+				c.writeFrame(OpClose, closePayload(CloseProtocolError, err.Error()))
+				c.writeMu.Unlock()
+				c.conn.Close()
+			}
+			return
+		}
+		switch opcode {
+		case OpPing:
+			c.WriteMessage(OpPong, payload)
+		case OpClose:
+			// Echo the status code, unless we started the closing handshake
+			c.writeMu.Lock()
+			if !c.closeSent {
+				if len(payload) > 2 {
+					payload = payload[:2]
+				}
+				c.writeFrame(OpClose, payload)
+			}
+			c.writeMu.Unlock()
+			return
+		}
+	}
+}
+
+// readFrame reads a frame and unmasks its payload. The payload of the data frames is skipped.
+func (c *Conn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, ErrUnmaskedFrame
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+
+	if opcode < OpClose {
+		_, err := io.CopyN(ioutil.Discard, c.r, int64(length))
+		return opcode, nil, err
+	}
+	if length > maxControlPayload {
+		return 0, nil, ErrControlTooLong
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
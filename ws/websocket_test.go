@@ -0,0 +1,200 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dial connects to the server with the sample handshake of RFC 6455, and returns the connection after
+// the response headers.
+func dial(t *testing.T, handler func(c *Conn)) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			t.Errorf("Upgrade() error = %v", err)
+			return
+		}
+		handler(c)
+	}))
+	t.Cleanup(ts.Close)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("reading the handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake response %d, Sec-WebSocket-Accept %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return conn, r
+}
+
+// readServerFrame reads an unmasked frame of the server.
+func readServerFrame(t *testing.T, r *bufio.Reader) (header []byte, payload []byte) {
+	t.Helper()
+	header = make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatalf("reading a frame: %v", err)
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		header = append(header, 0, 0)
+		if _, err := io.ReadFull(r, header[2:]); err != nil {
+			t.Fatal(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(header[2:]))
+	case 127:
+		header = append(header, make([]byte, 8)...)
+		if _, err := io.ReadFull(r, header[2:]); err != nil {
+			t.Fatal(err)
+		}
+		length = binary.BigEndian.Uint64(header[2:])
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header, payload
+}
+
+// clientFrame returns a final client frame, masked if masked is set.
+func clientFrame(opcode byte, payload []byte, masked bool) []byte {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{0x80 | opcode, byte(len(payload))}
+	if !masked {
+		return append(frame, payload...)
+	}
+	frame[1] |= 0x80
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestWriteMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		length     int
+		wantHeader []byte
+	}{
+		{"7 bit length", 125, []byte{0x82, 125}},
+		{"16 bit length", 126, []byte{0x82, 126, 0, 126}},
+		{"64 bit length", 70000, []byte{0x82, 127, 0, 0, 0, 0, 0, 1, 0x11, 0x70}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte{0xA}, tt.length)
+			conn, r := dial(t, func(c *Conn) {
+				if err := c.WriteMessage(OpBinary, data); err != nil {
+					t.Errorf("WriteMessage() error = %v", err)
+				}
+				<-c.Done()
+			})
+			defer conn.Close()
+			header, payload := readServerFrame(t, r)
+			if !bytes.Equal(header, tt.wantHeader) {
+				t.Errorf("frame header % X, want % X", header, tt.wantHeader)
+			}
+			if !bytes.Equal(payload, data) {
+				t.Errorf("payload of %d bytes, want %d", len(payload), len(data))
+			}
+		})
+	}
+}
+
+func TestReadLoop(t *testing.T) {
+	closed := make(chan error, 1)
+	conn, r := dial(t, func(c *Conn) {
+		<-c.Done()
+		closed <- c.Close(CloseNormal, "")
+	})
+
+	// The data frames are dropped, the pings are answered
+	if _, err := conn.Write(append(clientFrame(OpText, []byte("hello"), true), clientFrame(OpPing, []byte("ping"), true)...)); err != nil {
+		t.Fatal(err)
+	}
+	header, payload := readServerFrame(t, r)
+	if header[0] != 0x80|OpPong || string(payload) != "ping" {
+		t.Errorf("answer to the ping % X %q, want a pong with ping", header, payload)
+	}
+
+	// The close frame is echoed with its status code
+	if _, err := conn.Write(clientFrame(OpClose, append([]byte{0x03, 0xE9}, "bye"...), true)); err != nil {
+		t.Fatal(err)
+	}
+	header, payload = readServerFrame(t, r)
+	if header[0] != 0x80|OpClose || !bytes.Equal(payload, []byte{0x03, 0xE9}) {
+		t.Errorf("answer to the close frame % X % X, want a close frame with 1001", header, payload)
+	}
+	if err := <-closed; err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestReadLoopProtocolError(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"unmasked frame", clientFrame(OpBinary, []byte("data"), false)},
+		{"long control frame", append([]byte{0x80 | OpPing, 0x80 | 126, 0, 126, 0, 0, 0, 0}, make([]byte, 126)...)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, r := dial(t, func(c *Conn) { <-c.Done() })
+			if _, err := conn.Write(tt.frame); err != nil {
+				t.Fatal(err)
+			}
+			header, payload := readServerFrame(t, r)
+			if header[0] != 0x80|OpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != CloseProtocolError {
+				t.Errorf("answer % X % X, want a close frame with 1002", header, payload)
+			}
+		})
+	}
+}
+
+func TestUpgradeInvalid(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "8")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	w := httptest.NewRecorder()
+	if _, err := Upgrade(w, r); err != ErrNotWebSocket {
+		t.Errorf("Upgrade() error = %v, want %v", err, ErrNotWebSocket)
+	}
+	if w.Code != http.StatusBadRequest || w.Header().Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("status %d, Sec-WebSocket-Version %q", w.Code, w.Header().Get("Sec-WebSocket-Version"))
+	}
+}
+
+func TestClosePayload(t *testing.T) {
+	if got := closePayload(CloseGoingAway, "bye"); !bytes.Equal(got, []byte{0x03, 0xE9, 'b', 'y', 'e'}) {
+		t.Errorf("closePayload() = % X", got)
+	}
+	// Control frames are limited to 125 bytes
+	if got := closePayload(CloseNormal, strings.Repeat("x", 200)); len(got) != maxControlPayload {
+		t.Errorf("closePayload() of %d bytes, want %d", len(got), maxControlPayload)
+	}
+}
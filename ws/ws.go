@@ -0,0 +1,153 @@
+// Package ws serves the live streams of a server over WebSocket, for the Media Source Extensions players
+// running behind proxies which buffer the chunked HTTP responses.
+//
+// The FLV output (GET /{app}/{stream}.flv) sends the FLV header, then every tag in its own binary message,
+// the way flv.js reads it. The fMP4 output (GET /{app}/{stream}.mp4) sends the MIME type with the codecs
+// in a text message, the init segment, then a fragment for every frame. Both start at the latest key frame,
+// and the connection is closed with a normal close frame when the publisher stops.
+package ws
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"github.com/gerifield/mini-stream-test/flv"
+	"github.com/gerifield/mini-stream-test/httpflv"
+	"github.com/gerifield/mini-stream-test/server"
+)
+
+// Handler serves the WebSocket outputs of the streams of a registry.
+type Handler struct {
+	registry *server.Registry
+	logger   *log.Logger
+}
+
+// New creates a Handler serving the streams of the registry.
+func New(registry *server.Registry, logger *log.Logger) *Handler {
+	return &Handler{
+		registry: registry,
+		logger:   logger,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := "fMP4"
+	app, key, ok := httpflv.ParsePath(r.URL.Path, ".mp4")
+	if !ok {
+		format = "FLV"
+		app, key, ok = httpflv.ParsePath(r.URL.Path, ".flv")
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	st := h.registry.Get(app, key)
+	if st == nil {
+		http.NotFound(w, r)
+		return
+	}
+	conn, err := Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	id := app + "/" + key
+	h.logger.Println("WebSocket", format, "viewer", r.RemoteAddr, "joined", id)
+	sub := st.Subscribe()
+	if format == "FLV" {
+		err = streamFLV(conn, sub, st.CodecInfo())
+	} else {
+		err = streamFMP4(conn, sub, st.CodecInfo())
+	}
+	st.Unsubscribe(sub)
+
+	switch {
+	case err == nil:
+		conn.Close(CloseNormal, "stream unpublished")
+	case err == ErrConnectionClosed:
+		// The viewer left
+		conn.Close(CloseNormal, "")
+	default:
+		h.logger.Println("WebSocket", format, "viewer", r.RemoteAddr, "of", id, "error:", err)
+		conn.Close(CloseInternalError, err.Error())
+	}
+	h.logger.Println("WebSocket", format, "viewer", r.RemoteAddr, "left", id)
+}
+
+// streamFLV sends the packets of the subscriber as FLV tags until the publisher stops (nil error) or the viewer
+// leaves (ErrConnectionClosed). The frames before the first key frame are skipped.
+func streamFLV(conn *Conn, sub *server.Subscriber, codecs server.CodecInfo) error {
+	var buf bytes.Buffer
+	fw := flv.NewWriter(&buf)
+	// Without any packet so far, the stream could have both
+	if err := fw.WriteHeader(codecs.HasAudio || !codecs.HasVideo, codecs.HasVideo || !codecs.HasAudio); err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(OpBinary, buf.Bytes()); err != nil {
+		return err
+	}
+
+	started := !codecs.HasVideo
+	var timestamps server.TimestampRebaser
+	for {
+		select {
+		case <-conn.Done():
+			return ErrConnectionClosed
+		case p, ok := <-sub.Packets():
+			if !ok {
+				if sub.Err() == server.ErrStreamUnpublished {
+					return nil
+				}
+				return sub.Err()
+			}
+			if !started && (p.Type == server.TypeAudio || p.Type == server.TypeVideo) && !p.IsSequenceHeader() {
+				if !p.IsKeyFrame() {
+					continue
+				}
+				started = true
+			}
+			buf.Reset()
+			if err := fw.WriteTag(&flv.Tag{Type: p.Type, Timestamp: timestamps.Rebase(p), Data: p.Payload}); err != nil {
+				return err
+			}
+			if err := conn.WriteMessage(OpBinary, buf.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// streamFMP4 sends the packets of the subscriber as fMP4 fragments until the publisher stops (nil error)
+// or the viewer leaves (ErrConnectionClosed).
+func streamFMP4(conn *Conn, sub *server.Subscriber, codecs server.CodecInfo) error {
+	muxer := newFMP4Muxer(codecs)
+	for {
+		var messages []message
+		select {
+		case <-conn.Done():
+			return ErrConnectionClosed
+		case p, ok := <-sub.Packets():
+			if !ok {
+				if sub.Err() != server.ErrStreamUnpublished {
+					return sub.Err()
+				}
+				for _, m := range muxer.close() {
+					if err := conn.WriteMessage(m.opcode, m.data); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			var err error
+			if messages, err = muxer.writePacket(p); err != nil {
+				return err
+			}
+		}
+		for _, m := range messages {
+			if err := conn.WriteMessage(m.opcode, m.data); err != nil {
+				return err
+			}
+		}
+	}
+}